@@ -0,0 +1,130 @@
+// Package audit records who changed which fields of the audited models, through gorm v2 callbacks.
+//
+// A model is audited when some of its fields are tagged with `audit:"true"`, or `audit:"masked"` for the sensitive
+// fields, whose changes are recorded without their values. Every create, update and delete of an audited model
+// records an Entry with the before/after values of the tagged fields that changed, and the App-ID and Request-ID of
+// the request, read from the logger.ContextAppId{} and logger.ContextReqId{} values of the statement context.
+//
+//	type Card struct {
+//		ID     uint64
+//		Status string                     `audit:"true"`
+//		Limit  int64                      `audit:"true"`
+//		Pan    encryption.EncryptedString `audit:"masked"`
+//	}
+//
+//	err := audit.Register(db, audit.TableSink{})
+//	err = db.WithContext(c.Request.Context()).Model(&card).Update("status", "blocked").Error
+//
+// The rows are read before and after an update or a delete to compute the changes, so an audited change costs two
+// more queries. The changes made with raw SQL, or on a map instead of a model, aren't recorded.
+package audit
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ============ Constants =============
+
+// Action is the kind of change of an Entry.
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+const (
+	TableName = "audit_entries"
+	// Tag is the struct tag marking the audited fields, with the value TagTracked or TagMasked.
+	Tag        = "audit"
+	TagTracked = "true"
+	TagMasked  = "masked"
+	// MaskedValue replaces the values of the masked fields.
+	MaskedValue = "***"
+)
+
+// ============ Structs =============
+
+// Entry is the change of a row of an audited model.
+type Entry struct {
+	ID        uint64    `gorm:"primaryKey" json:"-"`
+	Table     string    `gorm:"size:255;not null;index:idx_audit_entries_record,priority:1" json:"table"`
+	RecordID  string    `gorm:"size:255;not null;index:idx_audit_entries_record,priority:2" json:"record_id"`
+	Action    Action    `gorm:"size:16;not null" json:"action"`
+	Changes   Changes   `gorm:"type:text" json:"changes"`
+	AppID     string    `gorm:"size:255;index" json:"app_id,omitempty"`
+	RequestID string    `gorm:"size:255;index" json:"request_id,omitempty"`
+	CreatedAt time.Time `gorm:"not null" json:"created_at"`
+}
+
+func (Entry) TableName() string {
+	return TableName
+}
+
+// Change is the value of a field before and after a change. Old is nil on create, and New is nil on delete.
+type Change struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// Changes are the changes of the fields of an Entry, by column name, stored as json.
+type Changes map[string]Change
+
+// Sink stores the audit entries.
+type Sink interface {
+	// Write stores the entries of a statement. When InTransaction is true, it is called with db, the connection of
+	// the statement, before the commit of its transaction, and an error rolls the change back. Otherwise it is called
+	// with a nil db once the statement succeeded, and after its commit unless it runs in an explicit transaction.
+	Write(ctx context.Context, db *gorm.DB, entries []Entry) error
+	InTransaction() bool
+}
+
+// TableSink writes the entries to the audit table, in the transaction of the change.
+type TableSink struct{}
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+func (c Changes) Value() (driver.Value, error) {
+	if c == nil {
+		return nil, nil
+	}
+	j, err := json.Marshal(c)
+	return string(j), err
+}
+
+func (c *Changes) Scan(src interface{}) error {
+	switch source := src.(type) {
+	case nil:
+		*c = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(source, c)
+	case string:
+		return json.Unmarshal([]byte(source), c)
+	default:
+		return fmt.Errorf("unsupported type %T for audit changes", src)
+	}
+}
+
+// Migrate creates or updates the audit table.
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(&Entry{})
+}
+
+func (TableSink) Write(ctx context.Context, db *gorm.DB, entries []Entry) error {
+	if err := db.Session(&gorm.Session{NewDB: true, Context: ctx}).Create(&entries).Error; err != nil {
+		return fmt.Errorf("error while writing audit entries: %w", err)
+	}
+	return nil
+}
+
+func (TableSink) InTransaction() bool {
+	return true
+}
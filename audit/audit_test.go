@@ -0,0 +1,87 @@
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"testing"
+
+	"github.com/happay/cms-utils-go/v3/logger"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type card struct {
+	ID     uint64
+	Status string `audit:"true"`
+	Pan    string `audit:"masked"`
+	Notes  string
+}
+
+type memorySink struct {
+	entries []Entry
+}
+
+func (s *memorySink) Write(_ context.Context, _ *gorm.DB, entries []Entry) error {
+	s.entries = append(s.entries, entries...)
+	return nil
+}
+
+func (s *memorySink) InTransaction() bool {
+	return false
+}
+
+func dryRunDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestCreateIsAudited(t *testing.T) {
+	db := dryRunDB(t)
+	sink := &memorySink{}
+	if err := Register(db, sink); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), logger.ContextAppId{}, slog.String("app_id", "app-1"))
+	ctx = context.WithValue(ctx, logger.ContextReqId{}, slog.String("req_id", "req-1"))
+	if err := db.WithContext(ctx).Create(&card{ID: 7, Status: "active", Pan: "4111111111111111", Notes: "x"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sink.entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(sink.entries))
+	}
+	entry := sink.entries[0]
+	if entry.Table != "cards" || entry.RecordID != "7" || entry.Action != ActionCreate || entry.AppID != "app-1" || entry.RequestID != "req-1" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+	want := Changes{"status": {New: "active"}, "pan": {New: MaskedValue}}
+	if !reflect.DeepEqual(entry.Changes, want) {
+		t.Errorf("got changes %v, want %v", entry.Changes, want)
+	}
+}
+
+func TestUpdateEntryHasChangedFieldsOnly(t *testing.T) {
+	db := dryRunDB(t)
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(&card{}); err != nil {
+		t.Fatal(err)
+	}
+	tx := db.Session(&gorm.Session{})
+	tx.Statement.Schema, tx.Statement.Table = stmt.Schema, stmt.Schema.Table
+	oldRow := reflect.ValueOf(card{ID: 7, Status: "active", Pan: "4111", Notes: "x"})
+	newRow := reflect.ValueOf(card{ID: 7, Status: "blocked", Pan: "4111", Notes: "y"})
+
+	entry := (&auditor{}).entry(tx, ActionUpdate, auditedFields(stmt.Schema), oldRow, newRow)
+	want := Changes{"status": {Old: "active", New: "blocked"}}
+	if !reflect.DeepEqual(entry.Changes, want) {
+		t.Errorf("got changes %v, want %v", entry.Changes, want)
+	}
+}
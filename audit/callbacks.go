@@ -0,0 +1,310 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/happay/cms-utils-go/v3/logger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// ============ Constants =============
+
+const (
+	beforeKey  = "audit:before"
+	entriesKey = "audit:entries"
+)
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// Register registers the audit callbacks on db, a gorm v2 connection such as the one of connector.GetPostgres,
+// writing the entries to sink. The legacy connections of connector.GetPgConn can't be audited: jinzhu/gorm
+// statements carry no context to read the App-ID and Request-ID from.
+func Register(db *gorm.DB, sink Sink) error {
+	a := &auditor{sink: sink}
+	create, update, del := db.Callback().Create(), db.Callback().Update(), db.Callback().Delete()
+	// the rows are read, and the entries written by a sink InTransaction, in the transaction of the change
+	const begin, commit = "gorm:begin_transaction", "gorm:commit_or_rollback_transaction"
+	registrations := []error{
+		create.After("gorm:create").Before(commit).Register("audit:after_create", a.afterCreate),
+		update.After(begin).Before("gorm:update").Register("audit:before_update", a.before),
+		update.After("gorm:update").Before(commit).Register("audit:after_update", a.afterUpdate),
+		del.After(begin).Before("gorm:delete").Register("audit:before_delete", a.before),
+		del.After("gorm:delete").Before(commit).Register("audit:after_delete", a.afterDelete),
+	}
+	if !sink.InTransaction() {
+		registrations = append(registrations,
+			create.After("gorm:commit_or_rollback_transaction").Register("audit:ship", a.ship),
+			update.After("gorm:commit_or_rollback_transaction").Register("audit:ship", a.ship),
+			del.After("gorm:commit_or_rollback_transaction").Register("audit:ship", a.ship),
+		)
+	}
+	for _, err := range registrations {
+		if err != nil {
+			return fmt.Errorf("error while registering audit callbacks: %w", err)
+		}
+	}
+	return nil
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+type auditor struct {
+	sink Sink
+}
+
+// auditedFields returns the fields of the schema tagged for audit, or nil if the model isn't audited.
+func auditedFields(s *schema.Schema) (fields []*schema.Field) {
+	if s == nil {
+		return nil
+	}
+	for _, field := range s.Fields {
+		if value, found := field.Tag.Lookup(Tag); found && field.DBName != "" && (value == TagTracked || value == TagMasked) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// before reads the rows about to be updated or deleted.
+func (a *auditor) before(tx *gorm.DB) {
+	if tx.Error != nil || tx.Statement.SQL.Len() != 0 || len(auditedFields(tx.Statement.Schema)) == 0 {
+		return
+	}
+	query, ok := rowsQuery(tx)
+	if !ok {
+		logger.GetLoggerV3().WarnContext(tx.Statement.Context,
+			fmt.Sprintf("audit: %s changed without condition is not audited", tx.Statement.Table))
+		return
+	}
+	rows, err := findRows(query, tx.Statement.Schema)
+	if err != nil {
+		tx.AddError(fmt.Errorf("error while reading the audited rows of %s: %w", tx.Statement.Table, err))
+		return
+	}
+	tx.Statement.Settings.Store(beforeKey, rows)
+}
+
+func (a *auditor) afterCreate(tx *gorm.DB) {
+	fields := auditedFields(tx.Statement.Schema)
+	if tx.Error != nil || len(fields) == 0 {
+		return
+	}
+	var entries []Entry
+	for _, row := range structs(tx.Statement.ReflectValue) {
+		entries = append(entries, a.entry(tx, ActionCreate, fields, reflect.Value{}, row))
+	}
+	a.record(tx, entries)
+}
+
+func (a *auditor) afterUpdate(tx *gorm.DB) {
+	before, fields, ok := a.beforeRows(tx)
+	if !ok {
+		return
+	}
+	after, err := findRows(tx.Session(&gorm.Session{NewDB: true}).Model(reflect.New(tx.Statement.Schema.ModelType).Interface()).
+		Unscoped().Where(primaryKeyCondition(tx.Statement.Schema, before)), tx.Statement.Schema)
+	if err != nil {
+		tx.AddError(fmt.Errorf("error while reading the audited rows of %s: %w", tx.Statement.Table, err))
+		return
+	}
+	afterById := make(map[string]reflect.Value, len(after))
+	for _, row := range after {
+		afterById[recordId(tx.Statement.Context, tx.Statement.Schema, row)] = row
+	}
+	var entries []Entry
+	for _, old := range before {
+		entry := a.entry(tx, ActionUpdate, fields, old, afterById[recordId(tx.Statement.Context, tx.Statement.Schema, old)])
+		if len(entry.Changes) != 0 {
+			entries = append(entries, entry)
+		}
+	}
+	a.record(tx, entries)
+}
+
+func (a *auditor) afterDelete(tx *gorm.DB) {
+	before, fields, ok := a.beforeRows(tx)
+	if !ok {
+		return
+	}
+	var entries []Entry
+	for _, old := range before {
+		entries = append(entries, a.entry(tx, ActionDelete, fields, old, reflect.Value{}))
+	}
+	a.record(tx, entries)
+}
+
+func (a *auditor) beforeRows(tx *gorm.DB) ([]reflect.Value, []*schema.Field, bool) {
+	value, found := tx.Statement.Settings.LoadAndDelete(beforeKey)
+	if tx.Error != nil || !found || tx.Statement.RowsAffected == 0 {
+		return nil, nil, false
+	}
+	before := value.([]reflect.Value)
+	return before, auditedFields(tx.Statement.Schema), len(before) != 0
+}
+
+// record writes the entries in the transaction, or keeps them for ship.
+func (a *auditor) record(tx *gorm.DB, entries []Entry) {
+	if len(entries) == 0 {
+		return
+	}
+	if !a.sink.InTransaction() {
+		tx.Statement.Settings.Store(entriesKey, entries)
+		return
+	}
+	if err := a.sink.Write(tx.Statement.Context, tx, entries); err != nil {
+		tx.AddError(err)
+	}
+}
+
+// ship writes the entries kept by record, once the change is committed.
+func (a *auditor) ship(tx *gorm.DB) {
+	value, found := tx.Statement.Settings.LoadAndDelete(entriesKey)
+	if !found || tx.Error != nil {
+		return
+	}
+	entries := value.([]Entry)
+	if err := a.sink.Write(tx.Statement.Context, nil, entries); err != nil {
+		logger.GetLoggerV3().ErrorContext(tx.Statement.Context,
+			fmt.Sprintf("audit: error while shipping %d entries of %s: %s", len(entries), tx.Statement.Table, err))
+	}
+}
+
+// entry builds the entry of a row, oldRow or newRow being invalid on create and delete.
+func (a *auditor) entry(tx *gorm.DB, action Action, fields []*schema.Field, oldRow, newRow reflect.Value) Entry {
+	ctx := tx.Statement.Context
+	row := newRow
+	if !row.IsValid() {
+		row = oldRow
+	}
+	entry := Entry{
+		Table:     tx.Statement.Table,
+		RecordID:  recordId(ctx, tx.Statement.Schema, row),
+		Action:    action,
+		Changes:   make(Changes),
+		AppID:     contextValue(ctx, logger.ContextAppId{}),
+		RequestID: contextValue(ctx, logger.ContextReqId{}),
+		CreatedAt: time.Now(),
+	}
+	for _, field := range fields {
+		// the values of the fields themselves, as ValueOf wraps those of the serializers, e.g. encryption.EncryptedString
+		var change Change
+		if oldRow.IsValid() {
+			change.Old = field.ReflectValueOf(ctx, oldRow).Interface()
+		}
+		if newRow.IsValid() {
+			change.New = field.ReflectValueOf(ctx, newRow).Interface()
+		}
+		if oldRow.IsValid() && newRow.IsValid() && reflect.DeepEqual(change.Old, change.New) {
+			continue
+		}
+		if field.Tag.Get(Tag) == TagMasked {
+			change = maskChange(oldRow.IsValid(), newRow.IsValid())
+		}
+		entry.Changes[field.DBName] = change
+	}
+	return entry
+}
+
+// maskChange records that a masked field changed, without its values.
+func maskChange(hasOld, hasNew bool) Change {
+	masked := Change{}
+	if hasOld {
+		masked.Old = MaskedValue
+	}
+	if hasNew {
+		masked.New = MaskedValue
+	}
+	return masked
+}
+
+// rowsQuery builds the query of the rows matched by the conditions of the statement, and by the primary key of
+// its model. It returns false if the statement has no condition.
+func rowsQuery(tx *gorm.DB) (*gorm.DB, bool) {
+	stmt := tx.Statement
+	query := tx.Session(&gorm.Session{NewDB: true}).Model(reflect.New(stmt.Schema.ModelType).Interface())
+	query.Statement.Unscoped = stmt.Unscoped
+	conditions := false
+	if where, found := stmt.Clauses["WHERE"]; found {
+		if w, ok := where.Expression.(clause.Where); ok && len(w.Exprs) != 0 {
+			query = query.Where(clause.And(w.Exprs...))
+			conditions = true
+		}
+	}
+	if rows := structs(stmt.ReflectValue); len(rows) != 0 && len(stmt.Schema.PrimaryFields) != 0 {
+		for _, row := range rows {
+			for _, field := range stmt.Schema.PrimaryFields {
+				if _, zero := field.ValueOf(stmt.Context, row); zero {
+					return query, conditions
+				}
+			}
+		}
+		query = query.Where(primaryKeyCondition(stmt.Schema, rows))
+		conditions = true
+	}
+	return query, conditions
+}
+
+// primaryKeyCondition matches the rows by primary key.
+func primaryKeyCondition(s *schema.Schema, rows []reflect.Value) clause.Expression {
+	var or []clause.Expression
+	for _, row := range rows {
+		var and []clause.Expression
+		for _, field := range s.PrimaryFields {
+			value, _ := field.ValueOf(context.Background(), row)
+			and = append(and, clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: value})
+		}
+		or = append(or, clause.And(and...))
+	}
+	return clause.Or(or...)
+}
+
+func findRows(query *gorm.DB, s *schema.Schema) ([]reflect.Value, error) {
+	rows := reflect.New(reflect.SliceOf(s.ModelType))
+	if err := query.Find(rows.Interface()).Error; err != nil {
+		return nil, err
+	}
+	return structs(rows.Elem()), nil
+}
+
+// structs returns the structs of a struct, or of a slice of structs or pointers to structs.
+func structs(value reflect.Value) (rows []reflect.Value) {
+	value = reflect.Indirect(value)
+	switch value.Kind() {
+	case reflect.Struct:
+		return []reflect.Value{value}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			if row := reflect.Indirect(value.Index(i)); row.Kind() == reflect.Struct {
+				rows = append(rows, row)
+			}
+		}
+	}
+	return rows
+}
+
+func recordId(ctx context.Context, s *schema.Schema, row reflect.Value) string {
+	ids := make([]string, 0, len(s.PrimaryFields))
+	for _, field := range s.PrimaryFields {
+		value, _ := field.ValueOf(ctx, row)
+		ids = append(ids, fmt.Sprint(value))
+	}
+	return strings.Join(ids, ",")
+}
+
+// contextValue reads a request id set by the logger context keys, as a slog.Attr.
+func contextValue(ctx context.Context, key interface{}) string {
+	switch value := ctx.Value(key).(type) {
+	case slog.Attr:
+		return value.Value.Resolve().String()
+	case string:
+		return value
+	}
+	return ""
+}
@@ -0,0 +1,411 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+func TestUpdateIsAuditedInTransaction(t *testing.T) {
+	db, store := openFakeAuditDB(t)
+	if err := Register(db, TableSink{}); err != nil {
+		t.Fatal(err)
+	}
+	store.addCard(card{ID: 7, Status: "active", Pan: "4111111111111111", Notes: "x"})
+
+	err := db.Model(&card{ID: 7}).Updates(card{Status: "blocked", Pan: "4000000000000002", Notes: "y"}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := store.card(7); got.Status != "blocked" {
+		t.Errorf("card %+v not updated", got)
+	}
+	entries := store.auditEntries()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	want := Changes{"status": {Old: "active", New: "blocked"}, "pan": {Old: MaskedValue, New: MaskedValue}}
+	if entry := entries[0]; entry.Action != ActionUpdate || entry.RecordID != "7" || !reflect.DeepEqual(entry.Changes, want) {
+		t.Errorf("got entry %+v, want the changes %v", entry, want)
+	}
+	// the entry is written between the update and the commit of its transaction
+	if events := strings.Join(store.events(), ","); events != "begin,update cards,insert audit_entries,commit" {
+		t.Errorf("got events %s", events)
+	}
+
+	// an update changing no audited field records nothing
+	if err = db.Model(&card{ID: 7}).Update("notes", "z").Error; err != nil {
+		t.Fatal(err)
+	}
+	if entries = store.auditEntries(); len(entries) != 1 {
+		t.Errorf("got %d entries, want the first one only", len(entries))
+	}
+}
+
+func TestDeleteIsShippedAfterCommit(t *testing.T) {
+	db, store := openFakeAuditDB(t)
+	sink := &committedSink{store: store}
+	if err := Register(db, sink); err != nil {
+		t.Fatal(err)
+	}
+	store.addCard(card{ID: 7, Status: "active", Pan: "4111111111111111", Notes: "x"})
+
+	if err := db.Delete(&card{ID: 7}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(store.auditEntries()) != 0 {
+		t.Error("entries written to the audit table by a sink out of the transaction")
+	}
+	if len(sink.entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(sink.entries))
+	}
+	want := Changes{"status": {Old: "active"}, "pan": {Old: MaskedValue}}
+	if entry := sink.entries[0]; entry.Action != ActionDelete || entry.RecordID != "7" || !reflect.DeepEqual(entry.Changes, want) {
+		t.Errorf("got entry %+v, want the changes %v", entry, want)
+	}
+	if !reflect.DeepEqual(sink.committed, []bool{true}) {
+		t.Errorf("got writes after commit %v, want the entries written once committed", sink.committed)
+	}
+}
+
+func TestRolledBackChangeIsNotAudited(t *testing.T) {
+	abort := errors.New("abort")
+	t.Run("transaction", func(t *testing.T) {
+		db, store := openFakeAuditDB(t)
+		if err := Register(db, TableSink{}); err != nil {
+			t.Fatal(err)
+		}
+		store.addCard(card{ID: 7, Status: "active"})
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&card{ID: 7}).Update("status", "blocked").Error; err != nil {
+				return err
+			}
+			return abort
+		})
+		if !errors.Is(err, abort) {
+			t.Fatalf("got %v, want the error of the transaction", err)
+		}
+		if got, entries := store.card(7), store.auditEntries(); got.Status != "active" || len(entries) != 0 {
+			t.Errorf("got card %+v and entries %+v, want both rolled back", got, entries)
+		}
+	})
+	t.Run("failed update", func(t *testing.T) {
+		db, store := openFakeAuditDB(t)
+		sink := &committedSink{store: store}
+		if err := Register(db, sink); err != nil {
+			t.Fatal(err)
+		}
+		store.addCard(card{ID: 7, Status: "active"})
+		store.failUpdates = true
+		if err := db.Model(&card{ID: 7}).Update("status", "blocked").Error; err == nil {
+			t.Fatal("got no error, want the update to fail")
+		}
+		if len(sink.entries) != 0 {
+			t.Errorf("sent %+v for a rolled back update", sink.entries)
+		}
+	})
+	t.Run("failed audit write", func(t *testing.T) {
+		db, store := openFakeAuditDB(t)
+		if err := Register(db, TableSink{}); err != nil {
+			t.Fatal(err)
+		}
+		store.addCard(card{ID: 7, Status: "active"})
+		store.failAuditWrites = true
+		if err := db.Model(&card{ID: 7}).Update("status", "blocked").Error; err == nil {
+			t.Fatal("got no error, want the audit write to fail")
+		}
+		if got := store.card(7); got.Status != "active" {
+			t.Errorf("card %+v, want the update rolled back with its audit entry", got)
+		}
+	})
+}
+
+// committedSink is a sink out of the transaction, recording whether the change was committed when it is called.
+type committedSink struct {
+	store     *fakeAuditDB
+	entries   []Entry
+	committed []bool
+}
+
+func (s *committedSink) Write(_ context.Context, _ *gorm.DB, entries []Entry) error {
+	s.entries = append(s.entries, entries...)
+	s.committed = append(s.committed, !s.store.inTransaction())
+	return nil
+}
+
+func (s *committedSink) InTransaction() bool {
+	return false
+}
+
+// ============ Fake database ==============
+
+// fakeAuditDB holds the cards and audit_entries tables, understanding the queries generated by gorm for postgres.
+// A transaction is rolled back by restoring the tables as they were at its beginning.
+type fakeAuditDB struct {
+	mu      sync.Mutex
+	cards   map[uint64]card
+	entries []Entry
+	log     []string
+	// snapshot holds the tables at the beginning of the transaction in progress
+	snapshot *fakeAuditSnapshot
+	// failUpdates and failAuditWrites fail the updates of the cards, and the writes of the audit entries
+	failUpdates     bool
+	failAuditWrites bool
+}
+
+type fakeAuditSnapshot struct {
+	cards   map[uint64]card
+	entries []Entry
+}
+
+var fakeAuditDBs sync.Map
+
+func init() {
+	sql.Register("audit-fake", fakeAuditDriver{})
+}
+
+func openFakeAuditDB(t *testing.T) (*gorm.DB, *fakeAuditDB) {
+	t.Helper()
+	store := &fakeAuditDB{cards: map[uint64]card{}}
+	fakeAuditDBs.Store(t.Name(), store)
+	sqlDB, err := sql.Open("audit-fake", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a single connection, as the transactions of the fake aren't isolated
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: gormlogger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, store
+}
+
+func (d *fakeAuditDB) addCard(c card) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cards[c.ID] = c
+}
+
+func (d *fakeAuditDB) card(id uint64) card {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cards[id]
+}
+
+func (d *fakeAuditDB) auditEntries() []Entry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Entry(nil), d.entries...)
+}
+
+func (d *fakeAuditDB) events() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.log...)
+}
+
+func (d *fakeAuditDB) inTransaction() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.snapshot != nil
+}
+
+func (d *fakeAuditDB) begin() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	snapshot := &fakeAuditSnapshot{cards: make(map[uint64]card, len(d.cards)), entries: append([]Entry(nil), d.entries...)}
+	for id, c := range d.cards {
+		snapshot.cards[id] = c
+	}
+	d.snapshot = snapshot
+	d.log = append(d.log, "begin")
+}
+
+func (d *fakeAuditDB) end(commit bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if commit {
+		d.log = append(d.log, "commit")
+	} else {
+		d.cards, d.entries = d.snapshot.cards, d.snapshot.entries
+		d.log = append(d.log, "rollback")
+	}
+	d.snapshot = nil
+}
+
+var (
+	fakeSelectCard  = regexp.MustCompile(`^SELECT \* FROM "cards" WHERE "cards"\."id" = \$1$`)
+	fakeUpdateCard  = regexp.MustCompile(`^UPDATE "cards" SET (.+) WHERE "id" = \$(\d+)$`)
+	fakeDeleteCard  = regexp.MustCompile(`^DELETE FROM "cards" WHERE "cards"\."id" = \$1$`)
+	fakeInsertEntry = regexp.MustCompile(`^INSERT INTO "audit_entries" \(([^)]+)\) VALUES .+ RETURNING "id"$`)
+	fakeSetColumn   = regexp.MustCompile(`"(\w+)"=\$(\d+)`)
+)
+
+func (d *fakeAuditDB) query(query string, args []driver.NamedValue) (driver.Rows, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if fakeSelectCard.MatchString(query) {
+		rows := &fakeAuditRows{columns: []string{"id", "status", "pan", "notes"}}
+		if c, found := d.cards[fakeAuditId(args[0].Value)]; found {
+			rows.values = append(rows.values, []driver.Value{int64(c.ID), c.Status, c.Pan, c.Notes})
+		}
+		return rows, nil
+	}
+	if match := fakeInsertEntry.FindStringSubmatch(query); match != nil {
+		if d.failAuditWrites {
+			return nil, errors.New("audit table unavailable")
+		}
+		columns := strings.Split(strings.ReplaceAll(match[1], `"`, ""), ",")
+		rows := &fakeAuditRows{columns: []string{"id"}}
+		for i := 0; i+len(columns) <= len(args); i += len(columns) {
+			entry := Entry{ID: uint64(len(d.entries) + 1)}
+			for j, column := range columns {
+				value := args[i+j].Value
+				switch column {
+				case "table":
+					entry.Table = value.(string)
+				case "record_id":
+					entry.RecordID = value.(string)
+				case "action":
+					entry.Action = Action(value.(string))
+				case "changes":
+					if err := entry.Changes.Scan(value); err != nil {
+						return nil, err
+					}
+				case "app_id":
+					entry.AppID = value.(string)
+				case "request_id":
+					entry.RequestID = value.(string)
+				case "created_at":
+					entry.CreatedAt = value.(time.Time)
+				default:
+					return nil, fmt.Errorf("unexpected column %s", column)
+				}
+			}
+			d.entries = append(d.entries, entry)
+			rows.values = append(rows.values, []driver.Value{int64(entry.ID)})
+		}
+		d.log = append(d.log, "insert audit_entries")
+		return rows, nil
+	}
+	return nil, fmt.Errorf("unexpected query %s", query)
+}
+
+func (d *fakeAuditDB) exec(query string, args []driver.NamedValue) (driver.Result, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if match := fakeUpdateCard.FindStringSubmatch(query); match != nil {
+		if d.failUpdates {
+			return nil, errors.New("cards table unavailable")
+		}
+		arg := func(placeholder string) driver.Value {
+			n, _ := strconv.Atoi(placeholder)
+			return args[n-1].Value
+		}
+		id := fakeAuditId(arg(match[2]))
+		c, found := d.cards[id]
+		if !found {
+			return driver.RowsAffected(0), nil
+		}
+		for _, set := range fakeSetColumn.FindAllStringSubmatch(match[1], -1) {
+			value, _ := arg(set[2]).(string)
+			switch set[1] {
+			case "status":
+				c.Status = value
+			case "pan":
+				c.Pan = value
+			case "notes":
+				c.Notes = value
+			default:
+				return nil, fmt.Errorf("unexpected column %s", set[1])
+			}
+		}
+		d.cards[id] = c
+		d.log = append(d.log, "update cards")
+		return driver.RowsAffected(1), nil
+	}
+	if fakeDeleteCard.MatchString(query) {
+		id := fakeAuditId(args[0].Value)
+		if _, found := d.cards[id]; !found {
+			return driver.RowsAffected(0), nil
+		}
+		delete(d.cards, id)
+		d.log = append(d.log, "delete cards")
+		return driver.RowsAffected(1), nil
+	}
+	return nil, fmt.Errorf("unexpected statement %s", query)
+}
+
+func fakeAuditId(value driver.Value) uint64 {
+	id, _ := value.(int64)
+	return uint64(id)
+}
+
+type fakeAuditDriver struct{}
+
+func (fakeAuditDriver) Open(name string) (driver.Conn, error) {
+	store, found := fakeAuditDBs.Load(name)
+	if !found {
+		return nil, fmt.Errorf("unknown fake audit database %s", name)
+	}
+	return &fakeAuditConn{store: store.(*fakeAuditDB)}, nil
+}
+
+type fakeAuditConn struct {
+	store *fakeAuditDB
+}
+
+func (c *fakeAuditConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeAuditConn) Close() error                        { return nil }
+func (c *fakeAuditConn) Begin() (driver.Tx, error) {
+	c.store.begin()
+	return c, nil
+}
+func (c *fakeAuditConn) Commit() error {
+	c.store.end(true)
+	return nil
+}
+func (c *fakeAuditConn) Rollback() error {
+	c.store.end(false)
+	return nil
+}
+
+func (c *fakeAuditConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.store.query(query, args)
+}
+
+func (c *fakeAuditConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.store.exec(query, args)
+}
+
+type fakeAuditRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeAuditRows) Columns() []string { return r.columns }
+func (r *fakeAuditRows) Close() error      { return nil }
+func (r *fakeAuditRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
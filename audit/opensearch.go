@@ -0,0 +1,41 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/olivere/elastic/v7"
+	"gorm.io/gorm"
+)
+
+// OpenSearchSink ships the entries to an OpenSearch index, e.g. with the client of connector.GetOpenSearchConnection,
+// once the change is committed. An entry whose shipping fails is logged and lost.
+type OpenSearchSink struct {
+	Client *elastic.Client
+	Index  string
+}
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+func (s OpenSearchSink) Write(ctx context.Context, _ *gorm.DB, entries []Entry) error {
+	bulk := s.Client.Bulk().Index(s.Index)
+	for _, entry := range entries {
+		bulk.Add(elastic.NewBulkIndexRequest().Doc(entry))
+	}
+	result, err := bulk.Do(ctx)
+	if err != nil {
+		return fmt.Errorf("error while indexing audit entries in %s: %w", s.Index, err)
+	}
+	if failed := result.Failed(); len(failed) != 0 {
+		reason := fmt.Sprintf("status %d", failed[0].Status)
+		if failed[0].Error != nil {
+			reason = failed[0].Error.Reason
+		}
+		return fmt.Errorf("%d audit entries not indexed in %s: %s", len(failed), s.Index, reason)
+	}
+	return nil
+}
+
+func (OpenSearchSink) InTransaction() bool {
+	return false
+}
@@ -0,0 +1,260 @@
+package connector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/happay/cms-utils-go/v3/logger"
+	"golang.org/x/sync/singleflight"
+	"gopkg.in/yaml.v2"
+	"gorm.io/gorm"
+)
+
+// ============ Constants =============
+
+// connection pool config params, shared by the postgres and the mysql configs
+const (
+	DBMaxOpenConn      = "max_open_connections"
+	DBMaxIdleConn      = "max_idle_connections"
+	DBConnMaxLifetime  = "conn_max_lifetime"
+	DBConnMaxIdleTime  = "conn_max_idle_time"
+	DBStatementTimeout = "statement_timeout"
+	DBApplicationName  = "application_name"
+)
+
+const (
+	DefaultConnectRetries    = 3
+	DefaultConnectRetryDelay = time.Second
+)
+
+// DBPoolConfig holds the connection pool and session settings of a database config.
+// The durations are written as "30s", "5m" or "1h30m", a bare number being a number of seconds.
+type DBPoolConfig struct {
+	MaxOpenConns int
+	MaxIdleConns int
+	// ConnMaxLifetime closes the connections older than it, e.g. to spread the reconnections after an RDS failover.
+	ConnMaxLifetime time.Duration
+	// ConnMaxIdleTime closes the connections idle for longer than it, e.g. before RDS proxy drops them.
+	ConnMaxIdleTime time.Duration
+	// StatementTimeout aborts the statements running for longer than it. On mysql it only applies to the SELECT.
+	StatementTimeout time.Duration
+	// ApplicationName identifies the service in pg_stat_activity. It is only sent to postgres.
+	ApplicationName string
+}
+
+// errMissingConfigValue is the error of a required key of a database config which is missing or empty.
+var errMissingConfigValue = errors.New("value is required")
+
+// InvalidConfigKey is a key of a database config whose value can't be used.
+type InvalidConfigKey struct {
+	Key string
+	Err error
+}
+
+// DBConfigError lists every invalid key of a database config.
+type DBConfigError struct {
+	ConfigKey   string
+	InvalidKeys []InvalidConfigKey
+}
+
+func (e *DBConfigError) Error() string {
+	return fmt.Sprintf("invalid %s database config: %s", e.ConfigKey, formatInvalidKeys(e.InvalidKeys))
+}
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// ParseDBPoolConfig reads the connection pool settings of the dbConfigs, whose values are resolved with resolve.
+// It returns a *DBConfigError listing every invalid key, instead of stopping at the first one.
+func ParseDBPoolConfig(configKey string, dbConfigs map[string]string, resolve func(string) string) (pool DBPoolConfig, err error) {
+	var invalid []InvalidConfigKey
+	get := func(key string) (string, bool) {
+		rawValue, found := dbConfigs[key]
+		if !found {
+			return "", false
+		}
+		value := strings.TrimSpace(resolve(rawValue))
+		return value, value != ""
+	}
+	parseCount := func(key string, dst *int) {
+		if value, found := get(key); found {
+			n, parseErr := strconv.Atoi(value)
+			if parseErr == nil && n < 0 {
+				parseErr = fmt.Errorf("%d is negative", n)
+			}
+			if parseErr != nil {
+				invalid = append(invalid, InvalidConfigKey{Key: key, Err: parseErr})
+				return
+			}
+			*dst = n
+		}
+	}
+	parseDuration := func(key string, dst *time.Duration) {
+		if value, found := get(key); found {
+			d, parseErr := parseConfigDuration(value)
+			if parseErr != nil {
+				invalid = append(invalid, InvalidConfigKey{Key: key, Err: parseErr})
+				return
+			}
+			*dst = d
+		}
+	}
+
+	parseCount(DBMaxOpenConn, &pool.MaxOpenConns)
+	parseCount(DBMaxIdleConn, &pool.MaxIdleConns)
+	parseDuration(DBConnMaxLifetime, &pool.ConnMaxLifetime)
+	parseDuration(DBConnMaxIdleTime, &pool.ConnMaxIdleTime)
+	parseDuration(DBStatementTimeout, &pool.StatementTimeout)
+	pool.ApplicationName, _ = get(DBApplicationName)
+
+	if pool.MaxOpenConns > 0 && pool.MaxIdleConns > pool.MaxOpenConns {
+		invalid = append(invalid, InvalidConfigKey{
+			Key: DBMaxIdleConn,
+			Err: fmt.Errorf("%d is more than the %d max open connections", pool.MaxIdleConns, pool.MaxOpenConns),
+		})
+	}
+	if len(invalid) != 0 {
+		err = &DBConfigError{ConfigKey: configKey, InvalidKeys: invalid}
+	}
+	return
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+// parseConfigDuration parses a Go duration, or a bare number of seconds.
+func parseConfigDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		value = strconv.Itoa(seconds) + "s"
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%s is negative", d)
+	}
+	return d, nil
+}
+
+// apply sets the limits of the connection pool. The zero values leave the defaults of database/sql.
+func (pool DBPoolConfig) apply(sqlDB *sql.DB) {
+	if pool.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(pool.MaxOpenConns)
+	}
+	if pool.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(pool.MaxIdleConns)
+	}
+	if pool.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	}
+	if pool.ConnMaxIdleTime > 0 {
+		sqlDB.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
+	}
+}
+
+// dbInstances holds the connection pools shared by the Get* functions, keyed by config file and config key.
+type dbInstances struct {
+	mu    sync.Mutex
+	pools map[string]*gorm.DB
+	// group de-duplicates the concurrent creations of a pool, outside of mu so that
+	// a slow or unreachable database doesn't block the lookups of the others.
+	group singleflight.Group
+}
+
+// getOrCreate returns the pool registered with key, or creates and registers it.
+// Nothing is registered if create fails, so that the next call tries again.
+func (instances *dbInstances) getOrCreate(key string, create func() (*gorm.DB, error)) (*gorm.DB, error) {
+	if db, found := instances.lookup(key); found {
+		return db, nil
+	}
+	db, err, _ := instances.group.Do(key, func() (interface{}, error) {
+		if db, found := instances.lookup(key); found {
+			return db, nil
+		}
+		db, err := create()
+		if err != nil {
+			return nil, err
+		}
+		instances.mu.Lock()
+		if instances.pools == nil {
+			instances.pools = make(map[string]*gorm.DB)
+		}
+		instances.pools[key] = db
+		instances.mu.Unlock()
+		return db, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db.(*gorm.DB), nil
+}
+
+func (instances *dbInstances) lookup(key string) (*gorm.DB, bool) {
+	instances.mu.Lock()
+	defer instances.mu.Unlock()
+	db, found := instances.pools[key]
+	return db, found
+}
+
+// readDbConfig reads the configKey section of a database yaml configuration file.
+func readDbConfig(dbCredPath, configKey string) (map[string]string, error) {
+	return readConfigSection(dbCredPath, configKey, "database")
+}
+
+// readConfigSection reads the configKey section of a yaml configuration file. kind names the configured service
+// in the errors, e.g. database.
+func readConfigSection(credPath, configKey, kind string) (map[string]string, error) {
+	bytes, err := os.ReadFile(credPath)
+	if err != nil {
+		return nil, fmt.Errorf("file read error %s: %w", credPath, err)
+	}
+	configs := make(map[string]map[string]string)
+	if err = yaml.Unmarshal(bytes, &configs); err != nil {
+		return nil, fmt.Errorf("error while parsing the %s configuration: %w", kind, err)
+	}
+	config, found := configs[configKey]
+	if !found {
+		return nil, fmt.Errorf("%s %s config not found on yaml file: %s", configKey, kind, credPath)
+	}
+	return config, nil
+}
+
+func formatInvalidKeys(keys []InvalidConfigKey) string {
+	invalid := make([]string, 0, len(keys))
+	for _, key := range keys {
+		invalid = append(invalid, fmt.Sprintf("%s: %s", key.Key, key.Err))
+	}
+	return strings.Join(invalid, "; ")
+}
+
+// pingWithRetries pings the database, retrying with an exponential backoff until it answers,
+// the retries are exhausted or ctx is done. name identifies the database in the logs and errors.
+func pingWithRetries(ctx context.Context, sqlDB *sql.DB, name string, retries int, delay time.Duration) (err error) {
+	if retries <= 0 {
+		retries = DefaultConnectRetries
+	}
+	if delay <= 0 {
+		delay = DefaultConnectRetryDelay
+	}
+	for attempt := 0; ; attempt++ {
+		if err = sqlDB.PingContext(ctx); err == nil {
+			return nil
+		}
+		if attempt >= retries {
+			return fmt.Errorf("%s is not reachable after %d attempts: %w", name, attempt+1, err)
+		}
+		logger.GetLoggerV3().Warn(fmt.Sprintf("%s is not reachable, retrying in %s: %s", name, delay, err))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s is not reachable: %w", name, ctx.Err())
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,47 @@
+package connector
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseDBPoolConfig(t *testing.T) {
+	identity := func(value string) string { return value }
+
+	pool, err := ParseDBPoolConfig("payments", map[string]string{
+		DBMaxOpenConn:      "20",
+		DBMaxIdleConn:      "5",
+		DBConnMaxLifetime:  "30m",
+		DBConnMaxIdleTime:  "90",
+		DBStatementTimeout: "5s",
+		DBApplicationName:  "payments-api",
+	}, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DBPoolConfig{
+		MaxOpenConns:     20,
+		MaxIdleConns:     5,
+		ConnMaxLifetime:  30 * time.Minute,
+		ConnMaxIdleTime:  90 * time.Second,
+		StatementTimeout: 5 * time.Second,
+		ApplicationName:  "payments-api",
+	}
+	if pool != want {
+		t.Errorf("got %+v, want %+v", pool, want)
+	}
+
+	_, err = ParseDBPoolConfig("payments", map[string]string{
+		DBMaxOpenConn:      "many",
+		DBConnMaxLifetime:  "-1m",
+		DBStatementTimeout: "5 seconds",
+	}, identity)
+	var configErr *DBConfigError
+	if !errors.As(err, &configErr) {
+		t.Fatalf("got error %v, want a *DBConfigError", err)
+	}
+	if len(configErr.InvalidKeys) != 3 {
+		t.Errorf("got invalid keys %v, want the 3 invalid keys", configErr.InvalidKeys)
+	}
+}
@@ -22,6 +22,8 @@ const (
 // =========== Exposed (public) Methods - can be called from external packages ============
 
 // GetElasticConnection provides a client to elastic search which can be used for insertion, search, removal etc. operations
+//
+// Deprecated: the client is a global shared by all the clusters. Use GetElasticClient.
 func GetElasticSearchConnection(esCredPath, esConfigKey string, index string) (*elastic.Client, error) {
 	var err error
 	err = initElasticConnectionAndIndexes(esCredPath, esConfigKey, index)
@@ -29,11 +31,10 @@ func GetElasticSearchConnection(esCredPath, esConfigKey string, index string) (*
 }
 
 // GetElasticSearchData get the data from elastic search
+//
+// Deprecated: use GetElasticSearchDataContext, or Search to decode the documents.
 func GetElasticSearchData(elasticClient *elastic.Client, index string, query *elastic.BoolQuery) (searchResult *elastic.SearchResult, err error) {
-	searchResult, err = elasticClient.Search().
-		Index(index).
-		Query(query).
-		Do(context.TODO())
+	searchResult, err = GetElasticSearchDataContext(context.TODO(), elasticClient, index, query)
 	if err != nil {
 		err = fmt.Errorf("failed to query the data | %s", err)
 		logger.GetLoggerV3().Error(err.Error())
@@ -44,30 +45,15 @@ func GetElasticSearchData(elasticClient *elastic.Client, index string, query *el
 
 // PushElasticSearchData push data to elastic search
 func PushElasticSearchData(elasticClient *elastic.Client, index, docType string, data interface{}) (err error) {
-	_, err = elasticClient.Index().
-		Index(index).
-		Type(docType).
-		BodyJson(data).
-		Do(context.TODO())
-	if err != nil {
-		err = fmt.Errorf("error while uploading data to ES | %s", err)
-		return
-	}
-	return
+	return PushElasticSearchDataContext(context.TODO(), elasticClient, index, docType, data)
 }
 
 // GetTopElasticSearchData will return the top hit search result
 func GetTopElasticSearchData(elasticClient *elastic.Client, index string, query *elastic.BoolQuery) (topHitSearchResult *elastic.SearchHit, err error) {
-	searchResult, err := GetElasticSearchData(elasticClient, index, query)
+	topHitSearchResult, err = GetTopElasticSearchDataContext(context.TODO(), elasticClient, index, query)
 	if err != nil {
-		err = fmt.Errorf("failed to query the data | %s", err)
-		return
-	}
-	if searchResult.TotalHits() != 1 {
-		err = fmt.Errorf("unique data not found | %s", err)
-		return
+		logger.GetLoggerV3().Error(err.Error())
 	}
-	topHitSearchResult = searchResult.Hits.Hits[0]
 	return
 }
 
@@ -107,7 +93,7 @@ func initElasticConnectionAndIndexes(esCredPath, esConfigKey string, index strin
 	// initialize elastic search client
 	elasticConnectionURL := fmt.Sprintf("%s", util.GetConfigValue(elasticConfig[ElasticUrl]))
 	elasticClient, err = elastic.NewSimpleClient(elastic.SetURL(elasticConnectionURL)) // connecting to elastic search, NOTE: sniffing is turned off currently
-	if elastic.IsConnErr(err) {
+	if err != nil {
 		err = fmt.Errorf("initializing elastic search client failed: %s", err)
 		return
 	}
@@ -122,37 +108,9 @@ func initElasticConnectionAndIndexes(esCredPath, esConfigKey string, index strin
 
 // createIndex checks if the indexName is already exists, and create it otherwise
 func createIndex(indexName string) (err error) {
-
-	// check if the index already exist
-	index, err := elasticClient.Search(indexName).Do(context.Background())
-	if err != nil { // index doesn't exist
-		reason := fmt.Sprintf("error checking if %s index already exist: %s", indexName, err)
-		logger.GetLoggerV3().Error(reason)
-	} else if index.Shards.Successful < index.Shards.Total { // index already exist, but some shards are unavailable
-		reason := fmt.Sprintf("%s index already exist, but only %d shards are available out of %d", indexName,
-			index.Shards.Successful, index.Shards.Total)
-		logger.GetLoggerV3().Error(reason)
-		//	TODO: Should we raise a panic here?
-	} else {
-		reason := fmt.Sprintf("%s index already exist, so skipping creation", indexName)
-		logger.GetLoggerV3().Error(reason)
-		return
-	}
-
-	// as index is non-existent, so creating it
-	var result *elastic.IndicesCreateResult
-	result, err = elasticClient.CreateIndex(indexName).Do(context.Background())
-	if err != nil {
-		err = fmt.Errorf("%s index creation fails: %s", indexName, err)
+	client := &ElasticClient{Client: elasticClient, name: "elastic search"}
+	if err = client.EnsureIndex(context.Background(), indexName, nil); err != nil {
 		logger.GetLoggerV3().Error(err.Error())
-		return
-	}
-
-	// checking if the index creating request is successfully acknowledged by elastic search
-	if !result.Acknowledged {
-		err = fmt.Errorf("%s index creation is not acknowledged by elastic search", indexName)
-		logger.GetLoggerV3().Error(err.Error())
-		return
 	}
 	return
 }
@@ -0,0 +1,362 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/happay/cms-utils-go/v3/logger"
+	"github.com/olivere/elastic/v7"
+)
+
+// ============ Constants =============
+
+const (
+	DefaultBulkWorkers       = 1
+	DefaultBulkBatchSize     = 1000
+	DefaultBulkBatchBytes    = 5 << 20
+	DefaultBulkFlushInterval = 5 * time.Second
+	DefaultBulkMaxRetries    = 3
+)
+
+var ErrBulkWriterClosed = errors.New("bulk writer is closed")
+
+// bulkRetryStatusCodes are the statuses of the documents, or of the whole bulk request, which are retried.
+var bulkRetryStatusCodes = map[int]bool{
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// BulkConfig configures a BulkWriter. The zero values are replaced with the defaults.
+type BulkConfig struct {
+	// Workers is the number of batches committed concurrently. The default is DefaultBulkWorkers.
+	Workers int
+	// BatchSize and BatchBytes commit the batch of a worker once it reaches that many documents or bytes.
+	// The defaults are DefaultBulkBatchSize and DefaultBulkBatchBytes.
+	BatchSize  int
+	BatchBytes int
+	// FlushInterval commits the batches at least that often. The default is DefaultBulkFlushInterval.
+	FlushInterval time.Duration
+	// QueueSize is the number of documents waiting for a worker, after which Add blocks. The default is BatchSize.
+	QueueSize int
+
+	// MaxRetries is the number of times a document is retried, when it, or its whole batch, fails with a 429 or
+	// a 5xx status, or on a connection error. The default is DefaultBulkMaxRetries, and a negative value disables
+	// the retries. The backoff between the retries is the one of the ElasticClient retries.
+	MaxRetries      int
+	RetryBackoffMin time.Duration
+	RetryBackoffMax time.Duration
+
+	// OnFailure is called with each document which couldn't be indexed. The default logs the failure.
+	OnFailure func(BulkFailure)
+}
+
+// BulkFailure is a document which couldn't be indexed, after the retries if it was retryable.
+type BulkFailure struct {
+	Request elastic.BulkableRequest
+	// Status is the status of the document, or zero if the whole batch failed.
+	Status int
+	Err    error
+}
+
+// BulkStats counts the documents of a BulkWriter.
+type BulkStats struct {
+	Indexed int64
+	Failed  int64
+	Retried int64
+}
+
+// BulkWriter indexes the documents in batches with the bulk API, from a pool of workers.
+//
+// Add blocks when the queues of the workers are full, so that the producers slow down to the pace of the cluster
+// instead of piling the documents up in memory.
+type BulkWriter struct {
+	client *elastic.Client
+	cfg    BulkConfig
+	// ctx is canceled by Close once its own context is done, aborting the requests and the retries in progress
+	ctx    context.Context
+	cancel context.CancelFunc
+	queues []chan bulkOp
+	next   uint64
+	// closing is closed by Close before it takes mu, so that Add and Flush stop waiting on the queues
+	closing     chan struct{}
+	closingOnce sync.Once
+	mu          sync.RWMutex
+	closed      bool
+	wg          sync.WaitGroup
+	indexed     int64
+	failed      int64
+	retried     int64
+}
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// NewBulkWriter starts a bulk writer on the client. It must be closed with Close to commit its last documents.
+func NewBulkWriter(client *elastic.Client, cfg BulkConfig) *BulkWriter {
+	if cfg.Workers <= 0 {
+		cfg.Workers = DefaultBulkWorkers
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBulkBatchSize
+	}
+	if cfg.BatchBytes <= 0 {
+		cfg.BatchBytes = DefaultBulkBatchBytes
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = DefaultBulkFlushInterval
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = cfg.BatchSize
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = DefaultBulkMaxRetries
+	}
+	if cfg.RetryBackoffMin <= 0 {
+		cfg.RetryBackoffMin = DefaultElasticRetryBackoffMin
+	}
+	if cfg.RetryBackoffMax <= 0 {
+		cfg.RetryBackoffMax = DefaultElasticRetryBackoffMax
+	}
+	if cfg.OnFailure == nil {
+		cfg.OnFailure = logBulkFailure
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &BulkWriter{client: client, cfg: cfg, ctx: ctx, cancel: cancel, closing: make(chan struct{})}
+	for i := 0; i < cfg.Workers; i++ {
+		queue := make(chan bulkOp, (cfg.QueueSize+cfg.Workers-1)/cfg.Workers)
+		w.queues = append(w.queues, queue)
+		w.wg.Add(1)
+		go w.work(queue)
+	}
+	return w
+}
+
+// NewBulkWriter starts a bulk writer on the cluster of the client.
+func (c *ElasticClient) NewBulkWriter(cfg BulkConfig) *BulkWriter {
+	return NewBulkWriter(c.Client, cfg)
+}
+
+// Add queues the request, e.g. an elastic.NewBulkIndexRequest. It blocks while the queue is full, until ctx is done
+// or the writer is closed.
+func (w *BulkWriter) Add(ctx context.Context, request elastic.BulkableRequest) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return ErrBulkWriterClosed
+	}
+	queue := w.queues[atomic.AddUint64(&w.next, 1)%uint64(len(w.queues))]
+	select {
+	case queue <- bulkOp{request: request}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-w.closing:
+		return ErrBulkWriterClosed
+	}
+}
+
+// Index queues the indexing of the document in the index.
+func (w *BulkWriter) Index(ctx context.Context, index string, doc interface{}) error {
+	return w.Add(ctx, elastic.NewBulkIndexRequest().Index(index).Doc(doc))
+}
+
+// Flush commits the documents added before it, and waits for them to be indexed, or to fail, until ctx is done
+// or the writer is closed.
+func (w *BulkWriter) Flush(ctx context.Context) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return ErrBulkWriterClosed
+	}
+	flushed := make(chan struct{}, len(w.queues))
+	for _, queue := range w.queues {
+		select {
+		case queue <- bulkOp{flushed: flushed}:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.closing:
+			return ErrBulkWriterClosed
+		}
+	}
+	for range w.queues {
+		select {
+		case <-flushed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.closing:
+			return ErrBulkWriterClosed
+		}
+	}
+	return nil
+}
+
+// Close stops accepting documents, and waits for the queued ones to be committed until ctx is done,
+// e.g. on the shutdown of the service. Once ctx is done, the requests and the retries in progress are aborted,
+// and the documents not indexed yet are reported to OnFailure.
+func (w *BulkWriter) Close(ctx context.Context) error {
+	// releases the producers waiting on a full queue, which hold mu
+	w.closingOnce.Do(func() { close(w.closing) })
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		for _, queue := range w.queues {
+			close(queue)
+		}
+	}
+	w.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		w.cancel()
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		// the workers fail the remaining documents right away
+		<-done
+		return fmt.Errorf("bulk writer not flushed on close: %w", ctx.Err())
+	}
+}
+
+// Stats returns the counts of the documents indexed, failed and retried so far.
+func (w *BulkWriter) Stats() BulkStats {
+	return BulkStats{
+		Indexed: atomic.LoadInt64(&w.indexed),
+		Failed:  atomic.LoadInt64(&w.failed),
+		Retried: atomic.LoadInt64(&w.retried),
+	}
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+// bulkOp is a request to index, or a flush signal.
+type bulkOp struct {
+	request elastic.BulkableRequest
+	flushed chan<- struct{}
+}
+
+func (w *BulkWriter) work(queue <-chan bulkOp) {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	var batch []elastic.BulkableRequest
+	var batchBytes int
+	commit := func() {
+		w.commit(batch)
+		batch, batchBytes = nil, 0
+	}
+	for {
+		select {
+		case op, ok := <-queue:
+			if !ok {
+				commit()
+				return
+			}
+			if op.flushed != nil {
+				commit()
+				op.flushed <- struct{}{}
+				continue
+			}
+			batch = append(batch, op.request)
+			if lines, err := op.request.Source(); err == nil {
+				for _, line := range lines {
+					batchBytes += len(line) + 1
+				}
+			}
+			if len(batch) >= w.cfg.BatchSize || batchBytes >= w.cfg.BatchBytes {
+				commit()
+			}
+		case <-ticker.C:
+			commit()
+		}
+	}
+}
+
+// commit indexes the batch, retrying the retryable failures.
+func (w *BulkWriter) commit(batch []elastic.BulkableRequest) {
+	for attempt := 0; len(batch) != 0; attempt++ {
+		canRetry := w.cfg.MaxRetries > 0 && attempt < w.cfg.MaxRetries && w.ctx.Err() == nil
+		result, err := w.client.Bulk().Add(batch...).Do(w.ctx)
+		var retry []elastic.BulkableRequest
+		switch {
+		case err != nil && canRetry && isRetryableBulkError(err):
+			retry = batch
+		case err != nil:
+			for _, request := range batch {
+				w.fail(BulkFailure{Request: request, Err: err})
+			}
+		default:
+			for i, item := range result.Items {
+				for _, itemResult := range item {
+					switch {
+					case itemResult.Status >= 200 && itemResult.Status < 300:
+						atomic.AddInt64(&w.indexed, 1)
+					case canRetry && bulkRetryStatusCodes[itemResult.Status]:
+						retry = append(retry, batch[i])
+					default:
+						w.fail(BulkFailure{Request: batch[i], Status: itemResult.Status, Err: bulkItemError(itemResult)})
+					}
+				}
+			}
+		}
+		if len(retry) != 0 {
+			atomic.AddInt64(&w.retried, int64(len(retry)))
+			select {
+			case <-w.ctx.Done():
+				for _, request := range retry {
+					w.fail(BulkFailure{Request: request, Err: w.ctx.Err()})
+				}
+				return
+			case <-time.After(w.backoff(attempt)):
+			}
+		}
+		batch = retry
+	}
+}
+
+func (w *BulkWriter) fail(failure BulkFailure) {
+	atomic.AddInt64(&w.failed, 1)
+	w.cfg.OnFailure(failure)
+}
+
+// backoff returns the jittered exponential delay before the retry of the attempt.
+func (w *BulkWriter) backoff(attempt int) time.Duration {
+	backoff := w.cfg.RetryBackoffMin << attempt
+	if backoff > w.cfg.RetryBackoffMax || backoff <= 0 {
+		backoff = w.cfg.RetryBackoffMax
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+func isRetryableBulkError(err error) bool {
+	var elasticErr *elastic.Error
+	if errors.As(err, &elasticErr) {
+		return bulkRetryStatusCodes[elasticErr.Status]
+	}
+	return elastic.IsConnErr(err) || elastic.IsTimeout(err)
+}
+
+func bulkItemError(item *elastic.BulkResponseItem) error {
+	if item.Error != nil {
+		return fmt.Errorf("%s: %s", item.Error.Type, item.Error.Reason)
+	}
+	return fmt.Errorf("status %d", item.Status)
+}
+
+func logBulkFailure(failure BulkFailure) {
+	logger.GetLoggerV3().Error(fmt.Sprintf("bulk indexing failed with status %d: %s", failure.Status, failure.Err))
+}
@@ -0,0 +1,178 @@
+package connector
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+func TestBulkWriterRetriesAndReportsFailures(t *testing.T) {
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var items []string
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			// action line, then document line
+			scanner.Scan()
+			doc := scanner.Text()
+			attempts[doc]++
+			status := http.StatusCreated
+			switch {
+			case strings.Contains(doc, "throttled") && attempts[doc] == 1:
+				status = http.StatusTooManyRequests
+			case strings.Contains(doc, "invalid"):
+				status = http.StatusBadRequest
+			}
+			items = append(items, fmt.Sprintf(`{"index":{"_index":"logs","status":%d}}`, status))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"errors":true,"items":[%s]}`, strings.Join(items, ","))
+	}))
+	defer server.Close()
+
+	client, err := elastic.NewSimpleClient(elastic.SetURL(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failures []BulkFailure
+	writer := NewBulkWriter(client, BulkConfig{
+		BatchSize:       10,
+		RetryBackoffMin: time.Millisecond,
+		OnFailure:       func(failure BulkFailure) { failures = append(failures, failure) },
+	})
+	ctx := context.Background()
+	for _, doc := range []string{"ok", "throttled", "invalid"} {
+		if err = writer.Index(ctx, "logs", map[string]string{"doc": doc}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = writer.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := writer.Stats(); stats != (BulkStats{Indexed: 2, Failed: 1, Retried: 1}) {
+		t.Errorf("got stats %+v", stats)
+	}
+	if len(failures) != 1 || failures[0].Status != http.StatusBadRequest {
+		t.Errorf("got failures %+v, want the invalid document", failures)
+	}
+	if err = writer.Index(ctx, "logs", "late"); err != ErrBulkWriterClosed {
+		t.Errorf("got error %v after close, want ErrBulkWriterClosed", err)
+	}
+}
+
+func TestBulkWriterCloseAbortsRetries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client, err := elastic.NewSimpleClient(elastic.SetURL(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var failures []BulkFailure
+	writer := NewBulkWriter(client, BulkConfig{
+		MaxRetries:      10,
+		RetryBackoffMin: time.Hour,
+		RetryBackoffMax: time.Hour,
+		OnFailure: func(failure BulkFailure) {
+			mu.Lock()
+			defer mu.Unlock()
+			failures = append(failures, failure)
+		},
+	})
+	if err = writer.Index(context.Background(), "logs", map[string]string{"doc": "unavailable"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Close commits the queued document, which is retried after an hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err = writer.Close(ctx); err == nil {
+		t.Error("got no error closing with retries pending")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("close took %s, want it aborted with its context", elapsed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(failures) != 1 || !errors.Is(failures[0].Err, context.Canceled) {
+		t.Errorf("got failures %+v, want the document aborted", failures)
+	}
+}
+
+func TestBulkWriterCloseReleasesBlockedAdd(t *testing.T) {
+	requested := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case requested <- struct{}{}:
+		default:
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client, err := elastic.NewSimpleClient(elastic.SetURL(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := NewBulkWriter(client, BulkConfig{
+		BatchSize:       1,
+		QueueSize:       1,
+		MaxRetries:      10,
+		RetryBackoffMin: time.Hour,
+		RetryBackoffMax: time.Hour,
+		OnFailure:       func(BulkFailure) {},
+	})
+	ctx := context.Background()
+	// the worker retries the first document, the second one fills the queue, and the third one blocks
+	if err = writer.Index(ctx, "logs", map[string]string{"doc": "1"}); err != nil {
+		t.Fatal(err)
+	}
+	<-requested
+	if err = writer.Index(ctx, "logs", map[string]string{"doc": "2"}); err != nil {
+		t.Fatal(err)
+	}
+	blocked := make(chan error, 1)
+	go func() {
+		blocked <- writer.Index(ctx, "logs", map[string]string{"doc": "3"})
+	}()
+	select {
+	case err = <-blocked:
+		t.Fatalf("add on a full queue returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	closeCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err = writer.Close(closeCtx); err == nil {
+		t.Error("got no error closing with retries pending")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("close took %s, want it to return by its deadline", elapsed)
+	}
+	select {
+	case err = <-blocked:
+		if !errors.Is(err, ErrBulkWriterClosed) {
+			t.Errorf("blocked add: got %v, want ErrBulkWriterClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("add still blocked after close")
+	}
+}
@@ -0,0 +1,326 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/happay/cms-utils-go/v3/logger"
+	"github.com/happay/cms-utils-go/v3/util"
+	"github.com/olivere/elastic/v7"
+	"golang.org/x/sync/singleflight"
+)
+
+// ============ Constants =============
+
+// elastic and opensearch config params, in addition to ElasticUrl, which can list comma-separated nodes
+const (
+	ElasticUser                  = "user"
+	ElasticPassword              = "password"
+	ElasticSniff                 = "sniff"
+	ElasticHealthcheck           = "healthcheck"
+	ElasticMaxRetries            = "max_retries"
+	ElasticTimeout               = "timeout"
+	ElasticTLSCAFile             = "tls_ca_file"
+	ElasticTLSCACert             = "tls_ca_cert"
+	ElasticTLSServerName         = "tls_server_name"
+	ElasticTLSInsecureSkipVerify = "tls_insecure_skip_verify"
+)
+
+const (
+	DefaultElasticMaxRetries      = 3
+	DefaultElasticRetryBackoffMin = 100 * time.Millisecond
+	DefaultElasticRetryBackoffMax = 5 * time.Second
+)
+
+// ElasticConfig holds the connection settings of an elastic or opensearch cluster.
+type ElasticConfig struct {
+	URLs     []string
+	User     string
+	Password string
+
+	// Sniff discovers the nodes of the cluster from the URLs. It must stay off behind a load balancer,
+	// e.g. on AWS OpenSearch.
+	Sniff bool
+	// Healthcheck pings the nodes periodically, and on creation of the client.
+	Healthcheck bool
+
+	// MaxRetries is the number of times a request failing on a connection error, or with a 429, 502, 503 or 504
+	// status, is retried. The default is DefaultElasticMaxRetries, and a negative value disables the retries.
+	MaxRetries int
+	// RetryBackoffMin and RetryBackoffMax bound the jittered exponential delay between the retries.
+	RetryBackoffMin time.Duration
+	RetryBackoffMax time.Duration
+	// Timeout is the timeout of a request. Zero means no timeout, but the context of the request.
+	Timeout time.Duration
+
+	// The URLs with the https scheme verify the server certificate with TLSCACert and TLSCAFile,
+	// or the system certificates.
+	TLSCAFile             string
+	TLSCACert             string
+	TLSServerName         string
+	TLSInsecureSkipVerify bool
+}
+
+// ElasticClient is the client of an elastic or opensearch cluster. It embeds the olivere client.
+type ElasticClient struct {
+	*elastic.Client
+	name string
+}
+
+// ElasticConfigError lists every invalid key of an elastic config.
+type ElasticConfigError struct {
+	ConfigKey   string
+	InvalidKeys []InvalidConfigKey
+}
+
+func (e *ElasticConfigError) Error() string {
+	return fmt.Sprintf("invalid %s elastic config: %s", e.ConfigKey, formatInvalidKeys(e.InvalidKeys))
+}
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// LoadElasticConfig reads the esConfigKey config from the esCredPath yaml file, whose values are resolved with
+// util.GetConfigValue. It returns an *ElasticConfigError listing every invalid key.
+func LoadElasticConfig(esCredPath, esConfigKey string) (cfg ElasticConfig, err error) {
+	esConfigs, err := readConfigSection(esCredPath, esConfigKey, "elastic")
+	if err != nil {
+		return
+	}
+	var invalid []InvalidConfigKey
+	get := func(key string) string {
+		if rawValue, found := esConfigs[key]; found {
+			return strings.TrimSpace(util.GetConfigValue(rawValue))
+		}
+		return ""
+	}
+	parseBool := func(key string, dst *bool) {
+		if value := get(key); value != "" {
+			b, parseErr := strconv.ParseBool(value)
+			if parseErr != nil {
+				invalid = append(invalid, InvalidConfigKey{Key: key, Err: parseErr})
+				return
+			}
+			*dst = b
+		}
+	}
+
+	for _, url := range strings.Split(get(ElasticUrl), ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			cfg.URLs = append(cfg.URLs, url)
+		}
+	}
+	if len(cfg.URLs) == 0 {
+		invalid = append(invalid, InvalidConfigKey{Key: ElasticUrl, Err: errors.New("no url")})
+	}
+	cfg.User = get(ElasticUser)
+	cfg.Password = get(ElasticPassword)
+	parseBool(ElasticSniff, &cfg.Sniff)
+	parseBool(ElasticHealthcheck, &cfg.Healthcheck)
+	if value := get(ElasticMaxRetries); value != "" {
+		var parseErr error
+		if cfg.MaxRetries, parseErr = strconv.Atoi(value); parseErr != nil {
+			invalid = append(invalid, InvalidConfigKey{Key: ElasticMaxRetries, Err: parseErr})
+		}
+	}
+	if value := get(ElasticTimeout); value != "" {
+		var parseErr error
+		if cfg.Timeout, parseErr = parseConfigDuration(value); parseErr != nil {
+			invalid = append(invalid, InvalidConfigKey{Key: ElasticTimeout, Err: parseErr})
+		}
+	}
+	cfg.TLSCAFile = get(ElasticTLSCAFile)
+	cfg.TLSCACert = get(ElasticTLSCACert)
+	cfg.TLSServerName = get(ElasticTLSServerName)
+	parseBool(ElasticTLSInsecureSkipVerify, &cfg.TLSInsecureSkipVerify)
+
+	if len(invalid) != 0 {
+		err = &ElasticConfigError{ConfigKey: esConfigKey, InvalidKeys: invalid}
+	}
+	return
+}
+
+// NewElasticClient creates a client of the cluster. With the healthcheck or the sniffing on, it fails if no node
+// answers before ctx is done.
+func NewElasticClient(ctx context.Context, cfg ElasticConfig) (*ElasticClient, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, errors.New("initializing elastic search client failed: no url")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.TLSCACert != "" || cfg.TLSCAFile != "" || cfg.TLSServerName != "" || cfg.TLSInsecureSkipVerify {
+		tlsConfig, err := newTLSConfig("elastic", cfg.TLSServerName, cfg.TLSInsecureSkipVerify, cfg.TLSCACert, cfg.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	options := []elastic.ClientOptionFunc{
+		elastic.SetURL(cfg.URLs...),
+		elastic.SetHttpClient(&http.Client{Transport: transport, Timeout: cfg.Timeout}),
+		elastic.SetSniff(cfg.Sniff),
+		elastic.SetHealthcheck(cfg.Healthcheck),
+		elastic.SetRetrier(newElasticRetrier(cfg)),
+		elastic.SetRetryStatusCodes(http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout),
+	}
+	if cfg.User != "" || cfg.Password != "" {
+		options = append(options, elastic.SetBasicAuth(cfg.User, cfg.Password))
+	}
+	client, err := elastic.DialContext(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("initializing elastic search client failed: %w", err)
+	}
+	name := strings.Join(cfg.URLs, ",")
+	logger.GetLoggerV3().Info(fmt.Sprintf("elastic search client created for %s", name))
+	return &ElasticClient{Client: client, name: name}, nil
+}
+
+// GetElasticClient returns the client of the esConfigKey cluster of the esCredPath yaml file.
+// The client is created on the first call for a cluster, and shared by the next calls.
+// Nothing is kept if the creation fails, so that the next call tries again.
+func GetElasticClient(ctx context.Context, esCredPath, esConfigKey string) (*ElasticClient, error) {
+	instanceKey := esCredPath + "#" + esConfigKey
+	if client, found := lookupElasticInstance(instanceKey); found {
+		return client, nil
+	}
+	client, err, _ := elasticInstanceGroup.Do(instanceKey, func() (interface{}, error) {
+		if client, found := lookupElasticInstance(instanceKey); found {
+			return client, nil
+		}
+		cfg, err := LoadElasticConfig(esCredPath, esConfigKey)
+		if err != nil {
+			return nil, err
+		}
+		client, err := NewElasticClient(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		elasticInstancesMu.Lock()
+		elasticInstances[instanceKey] = client
+		elasticInstancesMu.Unlock()
+		return client, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return client.(*ElasticClient), nil
+}
+
+// IndexExists tells whether the index, or the alias, exists. Unlike a search, a failing request is an error
+// instead of a missing index.
+func (c *ElasticClient) IndexExists(ctx context.Context, index string) (bool, error) {
+	exists, err := c.Client.IndexExists(index).Do(ctx)
+	if err != nil {
+		return false, fmt.Errorf("error checking if %s index exists: %w", index, err)
+	}
+	return exists, nil
+}
+
+// EnsureIndex creates the index with the body, settings and mappings, unless it exists.
+// An index created concurrently by another pod is not an error.
+func (c *ElasticClient) EnsureIndex(ctx context.Context, index string, body interface{}) error {
+	exists, err := c.IndexExists(ctx, index)
+	if err != nil || exists {
+		return err
+	}
+	create := c.Client.CreateIndex(index)
+	if body != nil {
+		create = create.BodyJson(body)
+	}
+	result, err := create.Do(ctx)
+	if err != nil {
+		var elasticErr *elastic.Error
+		if errors.As(err, &elasticErr) && elasticErr.Details != nil && elasticErr.Details.Type == "resource_already_exists_exception" {
+			return nil
+		}
+		return fmt.Errorf("%s index creation fails: %w", index, err)
+	}
+	if !result.Acknowledged {
+		return fmt.Errorf("%s index creation is not acknowledged by %s", index, c.name)
+	}
+	return nil
+}
+
+// GetElasticSearchDataContext gets the documents of the index matching the query.
+func GetElasticSearchDataContext(ctx context.Context, elasticClient *elastic.Client, index string, query elastic.Query) (*elastic.SearchResult, error) {
+	searchResult, err := elasticClient.Search().
+		Index(index).
+		Query(query).
+		Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the data of %s: %w", index, err)
+	}
+	return searchResult, nil
+}
+
+// GetTopElasticSearchDataContext returns the single document of the index matching the query.
+func GetTopElasticSearchDataContext(ctx context.Context, elasticClient *elastic.Client, index string, query elastic.Query) (*elastic.SearchHit, error) {
+	searchResult, err := GetElasticSearchDataContext(ctx, elasticClient, index, query)
+	if err != nil {
+		return nil, err
+	}
+	if searchResult.TotalHits() != 1 {
+		return nil, fmt.Errorf("unique data not found in %s: %d hits", index, searchResult.TotalHits())
+	}
+	return searchResult.Hits.Hits[0], nil
+}
+
+// PushElasticSearchDataContext indexes the data as a document of the index. The docType is only sent if not empty,
+// the mapping types being removed since elastic 7.
+func PushElasticSearchDataContext(ctx context.Context, elasticClient *elastic.Client, index, docType string, data interface{}) error {
+	indexService := elasticClient.Index().
+		Index(index).
+		BodyJson(data)
+	if docType != "" {
+		indexService = indexService.Type(docType)
+	}
+	if _, err := indexService.Do(ctx); err != nil {
+		return fmt.Errorf("error while uploading data to ES index %s: %w", index, err)
+	}
+	return nil
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+var elasticInstances = make(map[string]*ElasticClient)
+var elasticInstancesMu sync.Mutex
+
+// elasticInstanceGroup de-duplicates the concurrent creations of a client, outside of elasticInstancesMu so that
+// a slow or unreachable cluster doesn't block the lookups of the others.
+var elasticInstanceGroup singleflight.Group
+
+func lookupElasticInstance(instanceKey string) (*ElasticClient, bool) {
+	elasticInstancesMu.Lock()
+	defer elasticInstancesMu.Unlock()
+	client, found := elasticInstances[instanceKey]
+	return client, found
+}
+
+// newElasticRetrier retries MaxRetries times, with a jittered exponential backoff.
+func newElasticRetrier(cfg ElasticConfig) elastic.Retrier {
+	maxRetries, backoffMin, backoffMax := cfg.MaxRetries, cfg.RetryBackoffMin, cfg.RetryBackoffMax
+	if maxRetries == 0 {
+		maxRetries = DefaultElasticMaxRetries
+	}
+	if backoffMin <= 0 {
+		backoffMin = DefaultElasticRetryBackoffMin
+	}
+	if backoffMax <= 0 {
+		backoffMax = DefaultElasticRetryBackoffMax
+	}
+	return elastic.RetrierFunc(func(ctx context.Context, retry int, _ *http.Request, _ *http.Response, _ error) (time.Duration, bool, error) {
+		if retry > maxRetries {
+			return 0, false, nil
+		}
+		backoff := backoffMin << (retry - 1)
+		if backoff > backoffMax || backoff <= 0 {
+			backoff = backoffMax
+		}
+		return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1)), true, nil
+	})
+}
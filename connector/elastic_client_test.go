@@ -0,0 +1,129 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEnsureIndex(t *testing.T) {
+	var creations, unavailable int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodHead:
+			// the first check is retried
+			if atomic.AddInt32(&unavailable, 1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+		case http.MethodPut:
+			atomic.AddInt32(&creations, 1)
+			// created meanwhile by another pod
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"type":"resource_already_exists_exception","reason":"index [logs] already exists"},"status":400}`))
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewElasticClient(context.Background(), ElasticConfig{URLs: []string{server.URL}, RetryBackoffMin: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.EnsureIndex(context.Background(), "logs", nil); err != nil {
+		t.Errorf("got error %v", err)
+	}
+	if creations != 1 {
+		t.Errorf("got %d creations, want 1", creations)
+	}
+
+	server.Close()
+	if _, err = client.IndexExists(context.Background(), "logs"); err == nil {
+		t.Error("got no error checking an index on a closed server")
+	}
+}
+
+func TestLoadElasticConfig(t *testing.T) {
+	path := writeDbConfig(t, `
+search:
+  url: SEARCH_ES_URL
+  sniff: SEARCH_ES_SNIFF
+  timeout: SEARCH_ES_TIMEOUT
+broken:
+  sniff: SEARCH_ES_TIMEOUT
+`)
+	t.Setenv("SEARCH_ES_URL", "https://es-1.internal:9200, https://es-2.internal:9200")
+	t.Setenv("SEARCH_ES_SNIFF", "false")
+	t.Setenv("SEARCH_ES_TIMEOUT", "5s")
+
+	cfg, err := LoadElasticConfig(path, "search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ElasticConfig{URLs: []string{"https://es-1.internal:9200", "https://es-2.internal:9200"}, Timeout: 5 * time.Second}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("got %+v, want %+v", cfg, want)
+	}
+
+	_, err = LoadElasticConfig(path, "broken")
+	var configErr *ElasticConfigError
+	if !errors.As(err, &configErr) || len(configErr.InvalidKeys) != 2 {
+		t.Fatalf("got error %v, want an *ElasticConfigError with the url and sniff keys", err)
+	}
+	if msg := err.Error(); !strings.HasPrefix(msg, "invalid broken elastic config: ") {
+		t.Errorf("got message %s", msg)
+	}
+	if _, err = LoadElasticConfig(path, "logs"); err == nil || !strings.Contains(err.Error(), "logs elastic config not found") {
+		t.Errorf("got error %v for a missing config", err)
+	}
+}
+
+func TestGetElasticClientDoesNotBlockOthers(t *testing.T) {
+	release := make(chan struct{})
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slowServer.Close()
+	path := writeDbConfig(t, `
+slow:
+  url: SLOW_ES_URL
+  healthcheck: SLOW_ES_HEALTHCHECK
+fast:
+  url: FAST_ES_URL
+`)
+	t.Setenv("SLOW_ES_URL", slowServer.URL)
+	t.Setenv("SLOW_ES_HEALTHCHECK", "true")
+	t.Setenv("FAST_ES_URL", "http://fast-es.internal:9200")
+
+	slowDone := make(chan error, 1)
+	go func() {
+		_, err := GetElasticClient(context.Background(), path, "slow")
+		slowDone <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	fastDone := make(chan error, 1)
+	go func() {
+		_, err := GetElasticClient(context.Background(), path, "fast")
+		fastDone <- err
+	}()
+	select {
+	case err := <-fastDone:
+		if err != nil {
+			t.Errorf("fast cluster: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("creating a client blocked on the healthcheck of another cluster")
+	}
+	close(release)
+	if err := <-slowDone; err != nil {
+		t.Errorf("slow cluster: %s", err)
+	}
+}
@@ -0,0 +1,131 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/happay/cms-utils-go/v3/pagination"
+	"github.com/olivere/elastic/v7"
+)
+
+// SearchOptions shapes a Search. The zero value returns the first 10 documents by relevance.
+type SearchOptions struct {
+	// Sort orders the documents, by relevance if empty.
+	Sort []pagination.SortField
+	// After starts after the sort values of the last document of the previous page, e.g. decoded from a
+	// pagination cursor. From and Size page by offset instead, From being ignored with After.
+	After []interface{}
+	From  int
+	Size  int
+	// Includes and Excludes filter the fields of the documents.
+	Includes []string
+	Excludes []string
+	// Highlight highlights the matches, returned in SearchHit.Highlight.
+	Highlight *elastic.Highlight
+	// Aggregations are computed on all the matching documents, and decoded with DecodeAggregation.
+	Aggregations map[string]elastic.Aggregation
+	// TrackTotalHits counts all the matching documents, instead of up to 10000.
+	TrackTotalHits bool
+}
+
+// SearchHit is a document of a Search, decoded into T.
+type SearchHit[T any] struct {
+	Index     string
+	ID        string
+	Score     *float64
+	Source    T
+	Highlight map[string][]string
+	// Sort are the sort values of the document, to search after it.
+	Sort []interface{}
+}
+
+// SearchResult is a page of the documents matching a Search.
+type SearchResult[T any] struct {
+	Hits []SearchHit[T]
+	// Total is the number of matching documents, a lower bound past 10000 unless TrackTotalHits is set.
+	Total        int64
+	Aggregations elastic.Aggregations
+}
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// Search returns the documents of the index, or of the comma-separated indices, matching the query,
+// decoded into T.
+func Search[T any](ctx context.Context, client *elastic.Client, index string, query elastic.Query, opts *SearchOptions) (*SearchResult[T], error) {
+	if opts == nil {
+		opts = &SearchOptions{}
+	}
+	search := client.Search(index).Query(query)
+	if len(opts.Sort) != 0 || len(opts.After) != 0 {
+		search = pagination.ElasticSearchAfter(search, opts.Sort, opts.After)
+	}
+	if opts.From > 0 && len(opts.After) == 0 {
+		search = search.From(opts.From)
+	}
+	if opts.Size > 0 {
+		search = search.Size(opts.Size)
+	}
+	if len(opts.Includes) != 0 || len(opts.Excludes) != 0 {
+		search = search.FetchSourceContext(elastic.NewFetchSourceContext(true).Include(opts.Includes...).Exclude(opts.Excludes...))
+	}
+	if opts.Highlight != nil {
+		search = search.Highlight(opts.Highlight)
+	}
+	for name, aggregation := range opts.Aggregations {
+		search = search.Aggregation(name, aggregation)
+	}
+	if opts.TrackTotalHits {
+		search = search.TrackTotalHits(true)
+	}
+
+	result, err := search.Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the data of %s: %w", index, err)
+	}
+	decoded := &SearchResult[T]{Total: result.TotalHits(), Aggregations: result.Aggregations}
+	if result.Hits != nil {
+		decoded.Hits = make([]SearchHit[T], 0, len(result.Hits.Hits))
+		for _, hit := range result.Hits.Hits {
+			decodedHit := SearchHit[T]{Index: hit.Index, ID: hit.Id, Score: hit.Score, Highlight: hit.Highlight, Sort: hit.Sort}
+			if len(hit.Source) != 0 {
+				if err = json.Unmarshal(hit.Source, &decodedHit.Source); err != nil {
+					return nil, fmt.Errorf("error while decoding document %s/%s: %w", hit.Index, hit.Id, err)
+				}
+			}
+			decoded.Hits = append(decoded.Hits, decodedHit)
+		}
+	}
+	return decoded, nil
+}
+
+// Items returns the documents of the hits.
+func (r *SearchResult[T]) Items() []T {
+	items := make([]T, 0, len(r.Hits))
+	for _, hit := range r.Hits {
+		items = append(items, hit.Source)
+	}
+	return items
+}
+
+// After returns the sort values of the last hit, to search the next page after it, or nil on an empty page.
+func (r *SearchResult[T]) After() []interface{} {
+	if len(r.Hits) == 0 {
+		return nil
+	}
+	return r.Hits[len(r.Hits)-1].Sort
+}
+
+// DecodeAggregation decodes the name aggregation of the result into A, e.g. an elastic.AggregationBucketKeyItems
+// for a terms aggregation, or a struct of the fields needed.
+func DecodeAggregation[A any, T any](result *SearchResult[T], name string) (A, error) {
+	var aggregation A
+	raw, found := result.Aggregations[name]
+	if !found {
+		return aggregation, fmt.Errorf("aggregation %s not found", name)
+	}
+	if err := json.Unmarshal(raw, &aggregation); err != nil {
+		return aggregation, fmt.Errorf("error while decoding aggregation %s: %w", name, err)
+	}
+	return aggregation, nil
+}
@@ -0,0 +1,68 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/happay/cms-utils-go/v3/pagination"
+	"github.com/olivere/elastic/v7"
+)
+
+func TestSearch(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		body = string(raw)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"hits":{"total":{"value":2},"hits":[
+			{"_index":"cards","_id":"1","_source":{"name":"gold","limit":100},"highlight":{"name":["<em>gold</em>"]},"sort":[100,"1"]},
+			{"_index":"cards","_id":"2","_source":{"name":"silver","limit":50},"sort":[50,"2"]}]},
+			"aggregations":{"by_name":{"buckets":[{"key":"gold","doc_count":1},{"key":"silver","doc_count":1}]}}}`)
+	}))
+	defer server.Close()
+
+	client, err := elastic.NewSimpleClient(elastic.SetURL(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	type card struct {
+		Name  string `json:"name"`
+		Limit int    `json:"limit"`
+	}
+	result, err := Search[card](context.Background(), client, "cards", elastic.NewMatchQuery("name", "gold"), &SearchOptions{
+		Sort:         []pagination.SortField{{Column: "limit", Desc: true}, {Column: "id"}},
+		After:        []interface{}{200, "0"},
+		Size:         2,
+		Includes:     []string{"name", "limit"},
+		Highlight:    elastic.NewHighlight().Field("name"),
+		Aggregations: map[string]elastic.Aggregation{"by_name": elastic.NewTermsAggregation().Field("name")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{`"search_after":[200,"0"]`, `"includes":["name","limit"]`, `"highlight"`, `"by_name"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("query %s doesn't contain %s", body, want)
+		}
+	}
+	items := result.Items()
+	if result.Total != 2 || len(items) != 2 || items[0] != (card{Name: "gold", Limit: 100}) || result.Hits[0].Highlight["name"][0] != "<em>gold</em>" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if after := result.After(); len(after) != 2 || after[1] != "2" {
+		t.Errorf("got after %v", after)
+	}
+	terms, err := DecodeAggregation[elastic.AggregationBucketKeyItems](result, "by_name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(terms.Buckets) != 2 || terms.Buckets[1].Key != "silver" || terms.Buckets[1].DocCount != 1 {
+		t.Errorf("unexpected aggregation %+v", terms)
+	}
+}
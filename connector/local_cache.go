@@ -0,0 +1,209 @@
+package connector
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/happay/cms-utils-go/v3/logger"
+	"github.com/redis/go-redis/v9"
+)
+
+// LocalCacheClearChannelSuffix is appended to the invalidation channel of a LocalCache to name the channel of Clear.
+// Clear has a channel of its own, as any payload of the invalidation channel can be a key.
+const LocalCacheClearChannelSuffix = ":clear"
+
+// LocalCacheStats are the counters of a LocalCache, for observability.
+type LocalCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Size      int
+}
+
+// LocalCache is an in-process LRU cache with a TTL, whose invalidations are broadcast through redis pub/sub.
+// A Delete on one pod evicts the key from the caches of all the pods subscribed to the same channel.
+type LocalCache[V any] struct {
+	client       redis.UniversalClient
+	channel      string
+	clearChannel string
+	capacity     int
+	ttl          time.Duration
+
+	mu    sync.Mutex
+	items map[string]*list.Element
+	order *list.List // most recently used at the front
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+
+	pubsub *redis.PubSub
+	done   chan struct{}
+}
+
+type localCacheEntry[V any] struct {
+	key       string
+	value     V
+	expiresAt time.Time
+}
+
+// NewLocalCache creates a cache of at most capacity entries, each kept for ttl, and subscribes it to the invalidations
+// published on channel, and to the clears published on channel + LocalCacheClearChannelSuffix.
+// A zero capacity or ttl means no limit. Close must be called to stop listening.
+// As the invalidations published while the subscription is down are lost, the cache is cleared whenever
+// the subscription is restored.
+func NewLocalCache[V any](ctx context.Context, client redis.UniversalClient, channel string, capacity int, ttl time.Duration) (*LocalCache[V], error) {
+	c := &LocalCache[V]{
+		client:       client,
+		channel:      channel,
+		clearChannel: channel + LocalCacheClearChannelSuffix,
+		capacity:     capacity,
+		ttl:          ttl,
+		items:        make(map[string]*list.Element),
+		order:        list.New(),
+		done:         make(chan struct{}),
+	}
+	c.pubsub = client.Subscribe(ctx, c.channel, c.clearChannel)
+	// wait for both subscriptions to be confirmed, so that no invalidation published after this call is missed
+	for i := 0; i < 2; i++ {
+		if _, err := c.pubsub.Receive(ctx); err != nil {
+			c.pubsub.Close()
+			return nil, fmt.Errorf("error while subscribing to cache invalidation channel %s: %w", channel, err)
+		}
+	}
+	go c.listen()
+	return c, nil
+}
+
+// Get returns the value of the key, if it is cached and not expired.
+func (c *LocalCache[V]) Get(key string) (value V, found bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, found := c.items[key]
+	if !found {
+		c.misses.Add(1)
+		return
+	}
+	entry := elem.Value.(*localCacheEntry[V])
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		c.removeElement(elem)
+		c.misses.Add(1)
+		found = false
+		return
+	}
+	c.order.MoveToFront(elem)
+	c.hits.Add(1)
+	return entry.value, true
+}
+
+// Set caches the value of the key on this pod only, evicting the least recently used key if the cache is full.
+func (c *LocalCache[V]) Set(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var expiresAt time.Time
+	if c.ttl > 0 {
+		expiresAt = time.Now().Add(c.ttl)
+	}
+	if elem, found := c.items[key]; found {
+		entry := elem.Value.(*localCacheEntry[V])
+		entry.value = value
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(&localCacheEntry[V]{key: key, value: value, expiresAt: expiresAt})
+	if c.capacity > 0 && c.order.Len() > c.capacity {
+		c.removeElement(c.order.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// Delete evicts the keys from this pod, and publishes the invalidation so that the other pods evict them too.
+func (c *LocalCache[V]) Delete(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		c.evict(key)
+		if err := c.client.Publish(ctx, c.channel, key).Err(); err != nil {
+			return fmt.Errorf("error while publishing invalidation of %s on %s: %w", key, c.channel, err)
+		}
+	}
+	return nil
+}
+
+// Clear evicts every key from this pod and from the other pods.
+func (c *LocalCache[V]) Clear(ctx context.Context) error {
+	c.clearLocal()
+	if err := c.client.Publish(ctx, c.clearChannel, "").Err(); err != nil {
+		return fmt.Errorf("error while publishing invalidation of all keys on %s: %w", c.clearChannel, err)
+	}
+	return nil
+}
+
+// Stats returns the hit, miss and eviction counters and the current number of entries.
+func (c *LocalCache[V]) Stats() LocalCacheStats {
+	c.mu.Lock()
+	size := c.order.Len()
+	c.mu.Unlock()
+	return LocalCacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Size:      size,
+	}
+}
+
+// Close stops listening to the invalidations.
+func (c *LocalCache[V]) Close() error {
+	err := c.pubsub.Close()
+	<-c.done
+	return err
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+// listen applies the invalidations published by all the pods, including this one.
+func (c *LocalCache[V]) listen() {
+	defer close(c.done)
+	for received := range c.pubsub.ChannelWithSubscriptions() {
+		switch msg := received.(type) {
+		case *redis.Subscription:
+			// the initial confirmations were read by NewLocalCache, so this is a resubscription after a reconnection.
+			// The invalidations published while disconnected were lost, so every entry may be stale.
+			if msg.Kind == "subscribe" {
+				logger.GetLoggerV3().Warn(fmt.Sprintf("resubscribed to cache invalidation channel %s, clearing the cache", msg.Channel))
+				c.clearLocal()
+			}
+		case *redis.Message:
+			if msg.Channel == c.clearChannel {
+				c.clearLocal()
+				continue
+			}
+			c.evict(msg.Payload)
+		}
+	}
+	logger.GetLoggerV3().Info(fmt.Sprintf("stopped listening to cache invalidation channel %s", c.channel))
+}
+
+func (c *LocalCache[V]) evict(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, found := c.items[key]; found {
+		c.removeElement(elem)
+	}
+}
+
+func (c *LocalCache[V]) clearLocal() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+// removeElement must be called with the lock held.
+func (c *LocalCache[V]) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.items, elem.Value.(*localCacheEntry[V]).key)
+}
@@ -0,0 +1,102 @@
+package connector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestLocalCache(t *testing.T, mr *miniredis.Miniredis) *LocalCache[string] {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetryBackoff: 10 * time.Millisecond})
+	c, err := NewLocalCache[string](context.Background(), client, "config-invalidation", 10, 0)
+	if err != nil {
+		t.Fatalf("new local cache: %s", err)
+	}
+	t.Cleanup(func() {
+		_ = c.Close()
+		_ = client.Close()
+	})
+	return c
+}
+
+// eventually waits for the condition, as the invalidations are applied asynchronously.
+func eventually(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestLocalCacheInvalidation(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	podA, podB := newTestLocalCache(t, mr), newTestLocalCache(t, mr)
+
+	for _, c := range []*LocalCache[string]{podA, podB} {
+		c.Set("FEE_PERCENT", "1.5")
+		c.Set("FX_MARKUP", "2")
+	}
+	if err := podA.Delete(ctx, "FEE_PERCENT"); err != nil {
+		t.Fatalf("delete: %s", err)
+	}
+	if _, found := podA.Get("FEE_PERCENT"); found {
+		t.Error("key still cached on the deleting pod")
+	}
+	eventually(t, func() bool {
+		_, found := podB.Get("FEE_PERCENT")
+		return !found
+	}, "key not evicted on the other pod")
+	if value, found := podB.Get("FX_MARKUP"); !found || value != "2" {
+		t.Errorf("other key evicted: %q, %v", value, found)
+	}
+
+	// any key can be invalidated, without clearing the others
+	podB.Set("\xff*", "sentinel")
+	if err := podA.Delete(ctx, "\xff*"); err != nil {
+		t.Fatalf("delete: %s", err)
+	}
+	eventually(t, func() bool {
+		_, found := podB.Get("\xff*")
+		return !found
+	}, "key not evicted on the other pod")
+	if _, found := podB.Get("FX_MARKUP"); !found {
+		t.Error("cache cleared by the invalidation of a key")
+	}
+
+	if err := podB.Clear(ctx); err != nil {
+		t.Fatalf("clear: %s", err)
+	}
+	eventually(t, func() bool { return podA.Stats().Size == 0 }, "cache not cleared on the other pod")
+}
+
+func TestLocalCacheClearedOnResubscribe(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := newTestLocalCache(t, mr)
+	c.Set("FEE_PERCENT", "1.5")
+
+	// invalidations published during the outage are lost
+	mr.Close()
+	if err := mr.Restart(); err != nil {
+		t.Fatalf("restart: %s", err)
+	}
+	eventually(t, func() bool {
+		_, found := c.Get("FEE_PERCENT")
+		return !found
+	}, "cache not cleared after the subscription was restored")
+
+	// the invalidations are received again
+	c.Set("FX_MARKUP", "2")
+	mr.Publish("config-invalidation", "FX_MARKUP")
+	eventually(t, func() bool {
+		_, found := c.Get("FX_MARKUP")
+		return !found
+	}, "invalidation not received after the subscription was restored")
+}
@@ -11,33 +11,35 @@ import (
 	"github.com/go-sql-driver/mysql"
 )
 
-var mySqlDb *gorm.DB
-
 const (
 	MySqlConfigKey = "mysql"
 )
 
-func GetMySqlConn(mySQLDbConfigs map[string]string) *gorm.DB {
+// GetMySqlConn creates a new database connection from the raw (unresolved) mysql config map.
+//
+// Deprecated: use GetMySQL or NewMySQL, which read the yaml config, support TLS, share a connection per
+// config key and return a gorm v2 connection and an error.
+func GetMySqlConn(mySQLDbConfigs map[string]string) (mySqlDb *gorm.DB) {
 	logger.GetLoggerV3().Info("initiating db connection")
-	var err error
-	connStr := formatConnString(mySQLDbConfigs)
-	if mySqlDb, err = gorm.Open("mysql", connStr); err != nil {
-		err = fmt.Errorf("initialize db connection failed: %s", err)
+	pool, err := ParseDBPoolConfig(MySqlConfigKey, mySQLDbConfigs, func(value string) string { return value })
+	if err != nil {
 		logger.GetLoggerV3().Error(err.Error())
 		return mySqlDb
 	}
-	if mySqlDb, err = setConnLimits(mySqlDb, mySQLDbConfigs); err != nil {
-		err = fmt.Errorf("error setting connection limits: %s", err)
+	connStr := formatConnString(mySQLDbConfigs, pool)
+	if mySqlDb, err = gorm.Open("mysql", connStr); err != nil {
+		err = fmt.Errorf("initialize db connection failed: %s", err)
 		logger.GetLoggerV3().Error(err.Error())
 		return mySqlDb
 	}
+	pool.apply(mySqlDb.DB())
 
 	mySqlDb.BlockGlobalUpdate(true)
 	logger.GetLoggerV3().Info("db connection established")
 	return mySqlDb
 }
 
-func formatConnString(mySQLDbConfigs map[string]string) string {
+func formatConnString(mySQLDbConfigs map[string]string, pool DBPoolConfig) string {
 	config := mysql.Config{
 		User:                 mySQLDbConfigs["user"],
 		Passwd:               mySQLDbConfigs["password"],
@@ -48,27 +50,9 @@ func formatConnString(mySQLDbConfigs map[string]string) string {
 		AllowNativePasswords: true,
 		Loc:                  time.UTC,
 	}
-	return config.FormatDSN()
-}
-
-func setConnLimits(db *gorm.DB, pgDbConfigs map[string]string) (*gorm.DB, error) {
-	// setting maximum number of connections that this connection pool can have
-	if maxOpenConnCountStr, found := pgDbConfigs["max_open_connections"]; found {
-		maxOpenConnCount, err := strconv.Atoi(maxOpenConnCountStr)
-		if err != nil {
-			err = fmt.Errorf("error setting max open connections: %s", err)
-			return db, err
-		}
-		db.DB().SetMaxOpenConns(maxOpenConnCount)
+	if pool.StatementTimeout > 0 {
+		// session variable set on connection, applying to the SELECT statements
+		config.Params = map[string]string{"max_execution_time": strconv.FormatInt(pool.StatementTimeout.Milliseconds(), 10)}
 	}
-	// setting maximum number of idle (in reserve) connections that this connection pool
-	if maxIdleConnCountStr, found := pgDbConfigs["max_idle_connections"]; found {
-		maxIdleConnCount, err := strconv.Atoi(maxIdleConnCountStr)
-		if err != nil {
-			err = fmt.Errorf("error setting max idle connections: %s", err)
-			return db, err
-		}
-		db.DB().SetMaxIdleConns(maxIdleConnCount)
-	}
-	return db, nil
+	return config.FormatDSN()
 }
@@ -0,0 +1,282 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/happay/cms-utils-go/v3/logger"
+	"github.com/happay/cms-utils-go/v3/util"
+	gormmysql "gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// ============ Constants =============
+
+// mysql config params, in addition to the host, port, dbname, user and password of the postgres config
+// and to the connection pool params
+const (
+	MySqlNet                   = "net"
+	MySqlCharset               = "charset"
+	MySqlCollation             = "collation"
+	MySqlLoc                   = "loc"
+	MySqlTimeout               = "timeout"
+	MySqlReadTimeout           = "read_timeout"
+	MySqlWriteTimeout          = "write_timeout"
+	MySqlTLS                   = "tls"
+	MySqlTLSCAFile             = "tls_ca_file"
+	MySqlTLSCACert             = "tls_ca_cert"
+	MySqlTLSServerName         = "tls_server_name"
+	MySqlTLSInsecureSkipVerify = "tls_insecure_skip_verify"
+)
+
+const (
+	DefaultMySqlCharset = "utf8mb4"
+	DefaultMySqlPort    = "3306"
+)
+
+// MySQLConfig holds the connection settings of a mysql database.
+type MySQLConfig struct {
+	Host     string
+	Port     string
+	DBName   string
+	User     string
+	Password string
+
+	// Net is the network, tcp or unix. The default is tcp.
+	Net string
+	// Charset is the connection character set. The default is DefaultMySqlCharset.
+	Charset   string
+	Collation string
+	// Loc is the time zone of the DATETIME and TIMESTAMP values. The default is UTC.
+	Loc *time.Location
+
+	// Timeout, ReadTimeout and WriteTimeout are the dial, read and write timeouts. Zero means no timeout.
+	Timeout      time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// TLS enables TLS, verifying the server certificate with TLSCACert and TLSCAFile, or the system certificates.
+	TLS                   bool
+	TLSCAFile             string
+	TLSCACert             string
+	TLSServerName         string
+	TLSInsecureSkipVerify bool
+
+	// DBPoolConfig holds the connection pool limits and the statement timeout.
+	DBPoolConfig
+
+	// ConnectRetries is the number of times the initial ping is retried. The default is DefaultConnectRetries.
+	ConnectRetries int
+	// ConnectRetryDelay is the delay before the first retry, doubled on each retry. The default is DefaultConnectRetryDelay.
+	ConnectRetryDelay time.Duration
+	// Logger is the gorm logger. The default is the gorm default logger.
+	Logger gormlogger.Interface
+}
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// LoadMySQLConfig reads the mysqlConfigKey database config from the dbCredPath yaml file, as LoadPostgresConfig.
+// It returns a *DBConfigError listing every invalid key.
+func LoadMySQLConfig(dbCredPath, mysqlConfigKey string) (cfg MySQLConfig, err error) {
+	mysqlDbConfigs, err := readDbConfig(dbCredPath, mysqlConfigKey)
+	if err != nil {
+		return
+	}
+	var invalid []InvalidConfigKey
+	var poolErr *DBConfigError
+	if cfg.DBPoolConfig, err = ParseDBPoolConfig(mysqlConfigKey, mysqlDbConfigs, util.GetConfigValue); errors.As(err, &poolErr) {
+		invalid = poolErr.InvalidKeys
+	}
+
+	get := func(key string) string {
+		if rawValue, found := mysqlDbConfigs[key]; found {
+			return strings.TrimSpace(util.GetConfigValue(rawValue))
+		}
+		return ""
+	}
+	parseDuration := func(key string, dst *time.Duration) {
+		if value := get(key); value != "" {
+			d, parseErr := parseConfigDuration(value)
+			if parseErr != nil {
+				invalid = append(invalid, InvalidConfigKey{Key: key, Err: parseErr})
+				return
+			}
+			*dst = d
+		}
+	}
+	parseBool := func(key string, dst *bool) {
+		if value := get(key); value != "" {
+			b, parseErr := strconv.ParseBool(value)
+			if parseErr != nil {
+				invalid = append(invalid, InvalidConfigKey{Key: key, Err: parseErr})
+				return
+			}
+			*dst = b
+		}
+	}
+
+	cfg.Host = get(PostgresHost)
+	cfg.Port = get(PostgresPort)
+	cfg.DBName = get(PostgresDBName)
+	cfg.User = get(PostgresDBUser)
+	cfg.Password = get(PostgresPassword)
+	cfg.Net = get(MySqlNet)
+	cfg.Charset = get(MySqlCharset)
+	cfg.Collation = get(MySqlCollation)
+	if loc := get(MySqlLoc); loc != "" {
+		var locErr error
+		if cfg.Loc, locErr = time.LoadLocation(loc); locErr != nil {
+			invalid = append(invalid, InvalidConfigKey{Key: MySqlLoc, Err: locErr})
+		}
+	}
+	parseDuration(MySqlTimeout, &cfg.Timeout)
+	parseDuration(MySqlReadTimeout, &cfg.ReadTimeout)
+	parseDuration(MySqlWriteTimeout, &cfg.WriteTimeout)
+	parseBool(MySqlTLS, &cfg.TLS)
+	parseBool(MySqlTLSInsecureSkipVerify, &cfg.TLSInsecureSkipVerify)
+	cfg.TLSCAFile = get(MySqlTLSCAFile)
+	cfg.TLSCACert = get(MySqlTLSCACert)
+	cfg.TLSServerName = get(MySqlTLSServerName)
+
+	if len(invalid) != 0 {
+		err = &DBConfigError{ConfigKey: mysqlConfigKey, InvalidKeys: invalid}
+	}
+	return
+}
+
+// NewMySQL creates a gorm v2 connection pool, and pings the database, retrying with an exponential backoff
+// until it answers, the retries are exhausted or ctx is done.
+func NewMySQL(ctx context.Context, cfg MySQLConfig) (*gorm.DB, error) {
+	dsnConfig, err := cfg.dsnConfig()
+	if err != nil {
+		return nil, err
+	}
+	gormConfig := &gorm.Config{
+		DisableAutomaticPing: true, // pinged below with retries
+	}
+	if cfg.Logger != nil {
+		gormConfig.Logger = cfg.Logger
+	}
+	db, err := gorm.Open(gormmysql.New(gormmysql.Config{DSN: dsnConfig.FormatDSN(), DSNConfig: dsnConfig}), gormConfig)
+	if err != nil {
+		return nil, fmt.Errorf("initialize mysql db connection failed: %w", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("initialize mysql db connection failed: %w", err)
+	}
+	cfg.DBPoolConfig.apply(sqlDB)
+
+	name := fmt.Sprintf("mysql %s/%s", dsnConfig.Addr, cfg.DBName)
+	if err = pingWithRetries(ctx, sqlDB, name, cfg.ConnectRetries, cfg.ConnectRetryDelay); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	logger.GetLoggerV3().Info(fmt.Sprintf("mysql connection established to %s/%s", dsnConfig.Addr, cfg.DBName))
+	return db, nil
+}
+
+// GetMySQL returns the connection pool of the mysqlConfigKey database of the dbCredPath yaml file.
+// The pool is created on the first call for a database, and shared by the next calls.
+// Nothing is kept if the creation fails, so that the next call tries again.
+func GetMySQL(ctx context.Context, dbCredPath, mysqlConfigKey string) (*gorm.DB, error) {
+	return mysqlInstances.getOrCreate(dbCredPath+"#"+mysqlConfigKey, func() (*gorm.DB, error) {
+		cfg, err := LoadMySQLConfig(dbCredPath, mysqlConfigKey)
+		if err != nil {
+			return nil, err
+		}
+		return NewMySQL(ctx, cfg)
+	})
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+var mysqlInstances dbInstances
+
+// mysqlTLSConfigs are the names of the TLS configs registered with the driver, each one being registered once.
+var mysqlTLSConfigs = make(map[string]bool)
+var mysqlTLSConfigsMu sync.Mutex
+
+// dsnConfig builds the driver config. The TLS config, if any, is registered under a name unique to the server.
+func (cfg MySQLConfig) dsnConfig() (*mysql.Config, error) {
+	dsnConfig := mysql.NewConfig()
+	dsnConfig.User = cfg.User
+	dsnConfig.Passwd = cfg.Password
+	dsnConfig.DBName = cfg.DBName
+	dsnConfig.Net = cfg.Net
+	if dsnConfig.Net == "" {
+		dsnConfig.Net = "tcp"
+	}
+	dsnConfig.Addr = cfg.Host
+	if _, _, err := net.SplitHostPort(cfg.Host); dsnConfig.Net == "tcp" && err != nil {
+		// no port in the host, which may be a bare or bracketed IPv6 address
+		port := cfg.Port
+		if port == "" {
+			port = DefaultMySqlPort
+		}
+		dsnConfig.Addr = net.JoinHostPort(strings.Trim(cfg.Host, "[]"), port)
+	}
+	dsnConfig.Collation = cfg.Collation
+	dsnConfig.Params = map[string]string{}
+	if charset := cfg.Charset; charset != "" || cfg.Collation == "" {
+		if charset == "" {
+			charset = DefaultMySqlCharset
+		}
+		dsnConfig.Params["charset"] = charset
+	}
+	if cfg.StatementTimeout > 0 {
+		// session variable set on connection, applying to the SELECT statements
+		dsnConfig.Params["max_execution_time"] = strconv.FormatInt(cfg.StatementTimeout.Milliseconds(), 10)
+	}
+	dsnConfig.ParseTime = true
+	dsnConfig.AllowNativePasswords = true
+	dsnConfig.Loc = cfg.Loc
+	if dsnConfig.Loc == nil {
+		dsnConfig.Loc = time.UTC
+	}
+	dsnConfig.Timeout = cfg.Timeout
+	dsnConfig.ReadTimeout = cfg.ReadTimeout
+	dsnConfig.WriteTimeout = cfg.WriteTimeout
+
+	if cfg.TLS {
+		tlsConfigName := fmt.Sprintf("cms-utils-%s-%s", dsnConfig.Addr, cfg.DBName)
+		if err := cfg.registerTLSConfig(tlsConfigName, dsnConfig.Addr); err != nil {
+			return nil, err
+		}
+		dsnConfig.TLSConfig = tlsConfigName
+	}
+	return dsnConfig, nil
+}
+
+// registerTLSConfig registers the TLS config of the server with the driver under name, unless it is already registered.
+// The config of the first connection to a server is kept, as the driver reads it by name on each connection.
+func (cfg MySQLConfig) registerTLSConfig(name, addr string) error {
+	mysqlTLSConfigsMu.Lock()
+	defer mysqlTLSConfigsMu.Unlock()
+	if mysqlTLSConfigs[name] {
+		return nil
+	}
+	tlsConfig, err := newTLSConfig("mysql", cfg.TLSServerName, cfg.TLSInsecureSkipVerify, cfg.TLSCACert, cfg.TLSCAFile)
+	if err != nil {
+		return err
+	}
+	if tlsConfig.ServerName == "" && !tlsConfig.InsecureSkipVerify {
+		tlsConfig.ServerName = addr
+		if host, _, splitErr := net.SplitHostPort(addr); splitErr == nil {
+			tlsConfig.ServerName = host
+		}
+	}
+	if err = mysql.RegisterTLSConfig(name, tlsConfig); err != nil {
+		return fmt.Errorf("error while registering mysql TLS config: %w", err)
+	}
+	mysqlTLSConfigs[name] = true
+	return nil
+}
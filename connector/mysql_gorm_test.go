@@ -0,0 +1,63 @@
+package connector
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMySQLDSNConfig(t *testing.T) {
+	cfg := MySQLConfig{
+		Host:     "db.internal",
+		DBName:   "cards",
+		User:     "app",
+		Password: "secret",
+		Timeout:  5 * time.Second,
+		TLS:      true,
+	}
+	cfg.StatementTimeout = 10 * time.Second
+	dsnConfig, err := cfg.dsnConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "app:secret@tcp(db.internal:3306)/cards?parseTime=true&timeout=5s&tls=cms-utils-db.internal%3A3306-cards&charset=utf8mb4&max_execution_time=10000"
+	if dsn := dsnConfig.FormatDSN(); dsn != want {
+		t.Errorf("got dsn %s, want %s", dsn, want)
+	}
+}
+
+func TestMySQLDSNConfigAddr(t *testing.T) {
+	for host, want := range map[string]string{
+		"db.internal":      "db.internal:3307",
+		"db.internal:3308": "db.internal:3308",
+		"10.0.0.1":         "10.0.0.1:3307",
+		"::1":              "[::1]:3307",
+		"[::1]":            "[::1]:3307",
+		"[fd00::10]:3308":  "[fd00::10]:3308",
+		"fd00:ec2::23":     "[fd00:ec2::23]:3307",
+	} {
+		dsnConfig, err := MySQLConfig{Host: host, Port: "3307", DBName: "cards"}.dsnConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dsnConfig.Addr != want {
+			t.Errorf("host %s: got addr %s, want %s", host, dsnConfig.Addr, want)
+		}
+	}
+}
+
+func TestMySQLTLSConfigRegisteredOnce(t *testing.T) {
+	cfg := MySQLConfig{Host: "fd00::10", DBName: "cards", TLS: true}
+	first, err := cfg.dsnConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "cms-utils-[fd00::10]:3306-cards"; first.TLSConfig != want || !mysqlTLSConfigs[want] {
+		t.Fatalf("got TLS config %s, want %s registered", first.TLSConfig, want)
+	}
+	// the CA file is only read on the first registration
+	cfg.TLSCAFile = "/nonexistent/ca.pem"
+	second, err := cfg.dsnConfig()
+	if err != nil || second.TLSConfig != first.TLSConfig {
+		t.Errorf("got TLS config %s, %v, want the registered %s", second.TLSConfig, err, first.TLSConfig)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/happay/cms-utils-go/v2/logger"
 
 	"github.com/happay/cms-utils-go/v2/util"
+	"github.com/happay/cms-utils-go/v3/redact"
 	"github.com/olivere/elastic/v7"
 	"gopkg.in/yaml.v2"
 )
@@ -164,12 +165,14 @@ func IndexExists(indexName string) bool {
 	return exists
 }
 
+// PostResponseOpenSearch indexes the request/response log in the monthly index of the service,
+// masked by the default redactor of redact.SetDefault.
 func PostResponseOpenSearch(serviceName string, appId, reqId string, respLog map[string]interface{}) (err error) {
-	index := serviceName + strings.ToLower(time.Now().Month().String()) + "-" + strconv.Itoa(time.Now().Year())
+	index := responseLogIndex(serviceName, time.Now())
 
 	_, err = openSearchClient.Index().
 		Index(index).
-		BodyJson(respLog).
+		BodyJson(redact.Default().Map(respLog)).
 		Do(context.TODO())
 	if err != nil {
 		err = fmt.Errorf("PostResponseOpenSearch | error while uploading Req-Response body to OS for appId : %s | reqId: %s | servicename: %s | err : %s",
@@ -178,6 +181,10 @@ func PostResponseOpenSearch(serviceName string, appId, reqId string, respLog map
 	}
 	return
 }
+
+// GetResponseOpenSearch searches the log of the request in the monthly index of the util.Lock row of the request.
+//
+// Deprecated: it fails once the lock row is removed. Use RequestLogIndices.FindResponseLog.
 func GetResponseOpenSearch(serviceName, appId, reqId string, db *gorm.DB) (searchResult *elastic.SearchResult, err error) {
 	appIdQuery := elastic.NewTermQuery("AppId.keyword", appId)
 	reqIdQuery := elastic.NewTermQuery("RequestId.keyword", reqId)
@@ -189,7 +196,7 @@ func GetResponseOpenSearch(serviceName, appId, reqId string, db *gorm.DB) (searc
 		return
 	}
 
-	index := serviceName + strings.ToLower(res.CreatedAt.Month().String()) + "-" + strconv.Itoa(res.CreatedAt.Year())
+	index := responseLogIndex(serviceName, res.CreatedAt)
 	searchResult, err = openSearchClient.Search().
 		Index(index).
 		Query(query).
@@ -201,3 +208,18 @@ func GetResponseOpenSearch(serviceName, appId, reqId string, db *gorm.DB) (searc
 	}
 	return
 }
+
+// PostResponseOpenSearchBulk queues the request/response log to the index of PostResponseOpenSearch,
+// to be indexed in batches by the bulk writer instead of one request per log.
+func PostResponseOpenSearchBulk(ctx context.Context, writer *BulkWriter, serviceName string, appId, reqId string, respLog map[string]interface{}) error {
+	if err := writer.Index(ctx, responseLogIndex(serviceName, time.Now()), redact.Default().Map(respLog)); err != nil {
+		return fmt.Errorf("PostResponseOpenSearchBulk | error while queuing Req-Response body for appId : %s | reqId: %s | servicename: %s | err : %w",
+			appId, reqId, serviceName, err)
+	}
+	return nil
+}
+
+// responseLogIndex is the monthly index of the request/response logs of the service, e.g. cardsjanuary-2024.
+func responseLogIndex(serviceName string, t time.Time) string {
+	return serviceName + strings.ToLower(t.Month().String()) + "-" + strconv.Itoa(t.Year())
+}
@@ -0,0 +1,424 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/happay/cms-utils-go/v3/logger"
+	"github.com/happay/cms-utils-go/v3/redact"
+	"github.com/olivere/elastic/v7"
+)
+
+// ============ Constants =============
+
+const (
+	DefaultRequestLogRolloverMaxAge  = 30 * 24 * time.Hour
+	DefaultRequestLogRolloverMaxSize = "50gb"
+	DefaultRequestLogShards          = 1
+	// RequestLogTimestamp is the date field set on the request logs indexed by PostResponseLog.
+	RequestLogTimestamp = "@timestamp"
+)
+
+// RequestLogConfig configures the indices of the request/response logs of a service.
+type RequestLogConfig struct {
+	// Service prefixes the indices, the write alias and the policies.
+	Service string
+	// Shards is the number of shards of an index. The default is DefaultRequestLogShards.
+	Shards   int
+	Replicas int
+	// RolloverMaxAge and RolloverMaxSize roll the write alias over to a new index once its index is that old,
+	// or that large. The defaults are DefaultRequestLogRolloverMaxAge and DefaultRequestLogRolloverMaxSize.
+	RolloverMaxAge  time.Duration
+	RolloverMaxSize string
+	// RetentionMonths deletes the indices once all their logs are older than that many months.
+	// Zero keeps them forever. The ISM policy of OpenSearch counts a month as 30 days, so that it deletes the
+	// indices a few days off the calendar months.
+	RetentionMonths int
+	// Redactor masks the logs before they are indexed. The default is the one of redact.Default.
+	Redactor *redact.Redactor
+}
+
+// RequestLogIndices manages the request log indices of a service: the index template mapping the AppId and
+// RequestId as keywords, the write alias rolled over by age and size, and the retention.
+//
+// On OpenSearch the rollover and the retention are done by an ISM policy, elsewhere by Run, e.g. from a single
+// background job. Run also deletes the expired monthly indices of PostResponseOpenSearch, on both.
+type RequestLogIndices struct {
+	client     *ElasticClient
+	cfg        RequestLogConfig
+	openSearch bool
+}
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// NewRequestLogIndices returns the manager of the request log indices of the service, which must be Setup.
+func (c *ElasticClient) NewRequestLogIndices(cfg RequestLogConfig) *RequestLogIndices {
+	if cfg.Shards <= 0 {
+		cfg.Shards = DefaultRequestLogShards
+	}
+	if cfg.RolloverMaxAge <= 0 {
+		cfg.RolloverMaxAge = DefaultRequestLogRolloverMaxAge
+	}
+	if cfg.RolloverMaxSize == "" {
+		cfg.RolloverMaxSize = DefaultRequestLogRolloverMaxSize
+	}
+	return &RequestLogIndices{client: c, cfg: cfg}
+}
+
+// WriteAlias is the alias to index the logs to, pointing to the latest index.
+func (r *RequestLogIndices) WriteAlias() string {
+	return r.cfg.Service + "-reqlog"
+}
+
+// IndexPattern matches all the request log indices, to search them.
+func (r *RequestLogIndices) IndexPattern() string {
+	return r.WriteAlias() + "-*"
+}
+
+// Setup puts the index template and, on OpenSearch, the ISM policy, and creates the first index with the write
+// alias unless it exists. It can be called on every start of the service.
+func (r *RequestLogIndices) Setup(ctx context.Context) (err error) {
+	if r.openSearch, err = r.isOpenSearch(ctx); err != nil {
+		return err
+	}
+	if r.openSearch {
+		if err = r.putISMPolicy(ctx); err != nil {
+			return err
+		}
+	}
+	if err = r.putTemplate(ctx); err != nil {
+		return err
+	}
+
+	exists, err := r.client.Client.IndexExists(r.WriteAlias()).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("error checking if %s alias exists: %w", r.WriteAlias(), err)
+	}
+	if exists {
+		return nil
+	}
+	// the rollover increments the suffix of the index name
+	return r.client.EnsureIndex(ctx, r.WriteAlias()+"-000001", map[string]interface{}{
+		"aliases": map[string]interface{}{
+			r.WriteAlias(): map[string]interface{}{"is_write_index": true},
+		},
+	})
+}
+
+// PostResponseLog indexes the request/response log to the write alias, masked by the Redactor of the config,
+// setting its AppId, RequestId and RequestLogTimestamp fields if missing.
+func (r *RequestLogIndices) PostResponseLog(ctx context.Context, appId, reqId string, respLog map[string]interface{}) error {
+	return PushElasticSearchDataContext(ctx, r.client.Client, r.WriteAlias(), "", r.document(appId, reqId, respLog))
+}
+
+// PostResponseLogBulk queues the request/response log to the write alias, as PostResponseLog.
+func (r *RequestLogIndices) PostResponseLogBulk(ctx context.Context, writer *BulkWriter, appId, reqId string, respLog map[string]interface{}) error {
+	return writer.Index(ctx, r.WriteAlias(), r.document(appId, reqId, respLog))
+}
+
+// Rollover rolls the write alias over to a new index if its index reached the max age or size,
+// and reports whether it did.
+func (r *RequestLogIndices) Rollover(ctx context.Context) (bool, error) {
+	result, err := r.client.Client.RolloverIndex(r.WriteAlias()).
+		AddMaxIndexAgeCondition(elasticDuration(r.cfg.RolloverMaxAge)).
+		AddCondition("max_size", r.cfg.RolloverMaxSize).
+		Do(ctx)
+	if err != nil {
+		return false, fmt.Errorf("error while rolling %s over: %w", r.WriteAlias(), err)
+	}
+	if result.RolledOver {
+		logger.GetLoggerV3().Info(fmt.Sprintf("%s rolled over from %s to %s", r.WriteAlias(), result.OldIndex, result.NewIndex))
+	}
+	return result.RolledOver, nil
+}
+
+// EnforceRetention deletes the request log indices whose logs are all older than the retention, including the
+// monthly indices written by PostResponseOpenSearch. An index which can't be listed or deleted doesn't stop the
+// others: it returns the names of the deleted indices, along with the errors joined.
+func (r *RequestLogIndices) EnforceRetention(ctx context.Context) ([]string, error) {
+	return r.enforceRetention(ctx, true)
+}
+
+// Run rolls the write alias over and enforces the retention every interval, until ctx is done.
+// On OpenSearch it only deletes the expired monthly indices, the ISM policy rolling the write alias over and
+// deleting the expired request log indices.
+func (r *RequestLogIndices) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if !r.openSearch {
+			if _, err := r.Rollover(ctx); err != nil {
+				logger.GetLoggerV3().ErrorContext(ctx, err.Error())
+			}
+		}
+		if _, err := r.enforceRetention(ctx, !r.openSearch); err != nil {
+			logger.GetLoggerV3().ErrorContext(ctx, err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+// enforceRetention deletes the expired monthly indices and, if rolled is set, the expired request log indices.
+func (r *RequestLogIndices) enforceRetention(ctx context.Context, rolled bool) ([]string, error) {
+	if r.cfg.RetentionMonths <= 0 {
+		return nil, nil
+	}
+	cutoff := time.Now().AddDate(0, -r.cfg.RetentionMonths, 0)
+
+	var errs []error
+	var expired []string
+	if rolled {
+		indices, err := r.expiredRolledIndices(ctx, cutoff)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		expired = append(expired, indices...)
+	}
+	indices, err := r.expiredMonthlyIndices(ctx, cutoff)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	expired = append(expired, indices...)
+
+	var deleted []string
+	for _, index := range expired {
+		if _, err = r.client.Client.DeleteIndex(index).Do(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("error while deleting expired index %s: %w", index, err))
+			continue
+		}
+		logger.GetLoggerV3().Info(fmt.Sprintf("expired request log index %s deleted", index))
+		deleted = append(deleted, index)
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// expiredRolledIndices returns the request log indices, except the write index, holding only logs older than cutoff.
+func (r *RequestLogIndices) expiredRolledIndices(ctx context.Context, cutoff time.Time) ([]string, error) {
+	writeIndex, err := r.writeIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.client.Client.CatIndices().Index(r.IndexPattern()).Columns("index", "creation.date").Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error while listing %s indices: %w", r.IndexPattern(), err)
+	}
+	var expired []string
+	for _, row := range rows {
+		// an index holds the logs of at most the rollover max age after its creation
+		lastLog := time.UnixMilli(row.CreationDate).Add(r.cfg.RolloverMaxAge)
+		if row.Index != writeIndex && lastLog.Before(cutoff) {
+			expired = append(expired, row.Index)
+		}
+	}
+	return expired, nil
+}
+
+// expiredMonthlyIndices returns the monthly indices of PostResponseOpenSearch of the months before cutoff.
+// A month which can't be listed doesn't stop the others.
+func (r *RequestLogIndices) expiredMonthlyIndices(ctx context.Context, cutoff time.Time) ([]string, error) {
+	var errs []error
+	var expired []string
+	for month := time.January; month <= time.December; month++ {
+		prefix := r.cfg.Service + strings.ToLower(month.String()) + "-"
+		rows, err := r.client.Client.CatIndices().Index(prefix + "*").Columns("index").Do(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error while listing %s* indices: %w", prefix, err))
+			continue
+		}
+		for _, row := range rows {
+			year, err := strconv.Atoi(strings.TrimPrefix(row.Index, prefix))
+			if err == nil && time.Date(year, month+1, 1, 0, 0, 0, 0, time.UTC).Before(cutoff) {
+				expired = append(expired, row.Index)
+			}
+		}
+	}
+	return expired, errors.Join(errs...)
+}
+
+func (r *RequestLogIndices) document(appId, reqId string, respLog map[string]interface{}) map[string]interface{} {
+	redactor := r.cfg.Redactor
+	if redactor == nil {
+		redactor = redact.Default()
+	}
+	doc := redactor.Map(respLog)
+	if doc == nil {
+		doc = make(map[string]interface{}, 3)
+	}
+	if _, found := doc["AppId"]; !found {
+		doc["AppId"] = appId
+	}
+	if _, found := doc["RequestId"]; !found {
+		doc["RequestId"] = reqId
+	}
+	if _, found := doc[RequestLogTimestamp]; !found {
+		doc[RequestLogTimestamp] = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	return doc
+}
+
+// isOpenSearch tells whether the cluster is OpenSearch, from the distribution of its version.
+func (r *RequestLogIndices) isOpenSearch(ctx context.Context) (bool, error) {
+	res, err := r.client.Client.PerformRequest(ctx, elastic.PerformRequestOptions{Method: http.MethodGet, Path: "/"})
+	if err != nil {
+		return false, fmt.Errorf("error while reading the cluster version: %w", err)
+	}
+	var info struct {
+		Version struct {
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+	}
+	if err = json.Unmarshal(res.Body, &info); err != nil {
+		return false, fmt.Errorf("error while reading the cluster version: %w", err)
+	}
+	return info.Version.Distribution == "opensearch", nil
+}
+
+// putTemplate maps the AppId and RequestId as keywords, keeping the .keyword sub-field of the dynamic mapping of
+// the monthly indices, so that the same queries work on both.
+func (r *RequestLogIndices) putTemplate(ctx context.Context) error {
+	keyword := map[string]interface{}{
+		"type":   "keyword",
+		"fields": map[string]interface{}{"keyword": map[string]interface{}{"type": "keyword"}},
+	}
+	settings := map[string]interface{}{
+		"number_of_shards":   r.cfg.Shards,
+		"number_of_replicas": r.cfg.Replicas,
+	}
+	if r.openSearch {
+		settings["plugins.index_state_management.rollover_alias"] = r.WriteAlias()
+	}
+	template := map[string]interface{}{
+		"index_patterns": []string{r.IndexPattern()},
+		"priority":       100,
+		"template": map[string]interface{}{
+			"settings": settings,
+			"mappings": map[string]interface{}{
+				"properties": map[string]interface{}{
+					"AppId":             keyword,
+					"RequestId":         keyword,
+					RequestLogTimestamp: map[string]interface{}{"type": "date"},
+				},
+			},
+		},
+	}
+	if _, err := r.client.Client.IndexPutIndexTemplate(r.WriteAlias()).BodyJson(template).Do(ctx); err != nil {
+		return fmt.Errorf("error while putting %s index template: %w", r.WriteAlias(), err)
+	}
+	return nil
+}
+
+// putISMPolicy creates or updates the OpenSearch policy rolling the indices over, and deleting them after the
+// retention. The policy applies to the indices created after it.
+func (r *RequestLogIndices) putISMPolicy(ctx context.Context) error {
+	hot := map[string]interface{}{
+		"name": "hot",
+		"actions": []interface{}{
+			map[string]interface{}{"rollover": map[string]interface{}{
+				"min_index_age": elasticDuration(r.cfg.RolloverMaxAge),
+				"min_size":      r.cfg.RolloverMaxSize,
+			}},
+		},
+		"transitions": []interface{}{},
+	}
+	states := []interface{}{hot}
+	if r.cfg.RetentionMonths > 0 {
+		// ISM ages have no month unit, so the retention is approximated with 30 days a month
+		hot["transitions"] = []interface{}{
+			map[string]interface{}{
+				"state_name": "delete",
+				"conditions": map[string]interface{}{"min_rollover_age": fmt.Sprintf("%dd", 30*r.cfg.RetentionMonths)},
+			},
+		}
+		states = append(states, map[string]interface{}{
+			"name":        "delete",
+			"actions":     []interface{}{map[string]interface{}{"delete": map[string]interface{}{}}},
+			"transitions": []interface{}{},
+		})
+	}
+	policy := map[string]interface{}{
+		"policy": map[string]interface{}{
+			"description":   fmt.Sprintf("rollover and retention of the %s request logs", r.cfg.Service),
+			"default_state": "hot",
+			"states":        states,
+			"ism_template": []interface{}{
+				map[string]interface{}{"index_patterns": []string{r.IndexPattern()}, "priority": 100},
+			},
+		},
+	}
+
+	path := "/_plugins/_ism/policies/" + url.PathEscape(r.WriteAlias())
+	params := url.Values{}
+	res, err := r.client.Client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: http.MethodGet, Path: path, IgnoreErrors: []int{http.StatusNotFound},
+	})
+	if err != nil {
+		return fmt.Errorf("error while reading %s ISM policy: %w", r.WriteAlias(), err)
+	}
+	if res.StatusCode == http.StatusOK {
+		// an existing policy is updated given its sequence number
+		var current struct {
+			SeqNo       int64 `json:"_seq_no"`
+			PrimaryTerm int64 `json:"_primary_term"`
+		}
+		if err = json.Unmarshal(res.Body, &current); err != nil {
+			return fmt.Errorf("error while reading %s ISM policy: %w", r.WriteAlias(), err)
+		}
+		params.Set("if_seq_no", strconv.FormatInt(current.SeqNo, 10))
+		params.Set("if_primary_term", strconv.FormatInt(current.PrimaryTerm, 10))
+	}
+	if _, err = r.client.Client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: http.MethodPut, Path: path, Params: params, Body: policy,
+	}); err != nil {
+		return fmt.Errorf("error while putting %s ISM policy: %w", r.WriteAlias(), err)
+	}
+	return nil
+}
+
+// writeIndex returns the index the write alias points to.
+func (r *RequestLogIndices) writeIndex(ctx context.Context) (string, error) {
+	res, err := r.client.Client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: http.MethodGet, Path: "/_alias/" + url.PathEscape(r.WriteAlias()),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error while reading %s alias: %w", r.WriteAlias(), err)
+	}
+	var indices map[string]struct {
+		Aliases map[string]struct {
+			IsWriteIndex *bool `json:"is_write_index"`
+		} `json:"aliases"`
+	}
+	if err = json.Unmarshal(res.Body, &indices); err != nil {
+		return "", fmt.Errorf("error while reading %s alias: %w", r.WriteAlias(), err)
+	}
+	for index, alias := range indices {
+		// the single index of an alias is its write index
+		if isWriteIndex := alias.Aliases[r.WriteAlias()].IsWriteIndex; len(indices) == 1 || (isWriteIndex != nil && *isWriteIndex) {
+			return index, nil
+		}
+	}
+	return "", errors.New(r.WriteAlias() + " alias has no write index")
+}
+
+// elasticDuration formats the duration in the elastic time units, e.g. 30d or 12h.
+func elasticDuration(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	if d%time.Hour == 0 {
+		return fmt.Sprintf("%dh", d/time.Hour)
+	}
+	return fmt.Sprintf("%ds", d/time.Second)
+}
@@ -0,0 +1,91 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// newRetentionTestIndices returns the request log indices of a fake cluster holding an expired request log index,
+// whose deletion fails if failDelete is set, and an expired monthly index.
+func newRetentionTestIndices(t *testing.T, failDelete bool) (*RequestLogIndices, *[]string) {
+	now := time.Now()
+	created := func(monthsAgo int) int64 { return now.AddDate(0, -monthsAgo, 0).UnixMilli() }
+	lastYear := now.Year() - 1
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodDelete && r.URL.Path == "/cards-reqlog-000001" && failDelete:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"error":{"type":"cluster_block_exception","reason":"blocked"},"status":403}`)
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/"))
+			fmt.Fprint(w, `{"acknowledged":true}`)
+		case r.URL.Path == "/_alias/cards-reqlog":
+			fmt.Fprint(w, `{"cards-reqlog-000001":{"aliases":{"cards-reqlog":{"is_write_index":false}}},
+				"cards-reqlog-000002":{"aliases":{"cards-reqlog":{"is_write_index":true}}}}`)
+		case r.URL.Path == "/_cat/indices/cards-reqlog-*":
+			fmt.Fprintf(w, `[{"index":"cards-reqlog-000001","creation.date":"%d"},{"index":"cards-reqlog-000002","creation.date":"%d"}]`,
+				created(8), created(7))
+		case r.URL.Path == "/_cat/indices/cardsjanuary-*":
+			fmt.Fprintf(w, `[{"index":"cardsjanuary-%d"},{"index":"cardsjanuary-%d"}]`, lastYear-1, now.Year()+1)
+		default:
+			fmt.Fprint(w, `[]`)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := elastic.NewSimpleClient(elastic.SetURL(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return (&ElasticClient{Client: client}).NewRequestLogIndices(RequestLogConfig{Service: "cards", RetentionMonths: 6}), &deleted
+}
+
+func TestEnforceRetention(t *testing.T) {
+	indices, deleted := newRetentionTestIndices(t, false)
+	expired, err := indices.EnforceRetention(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the write index is kept however old
+	want := []string{"cards-reqlog-000001", fmt.Sprintf("cardsjanuary-%d", time.Now().Year()-2)}
+	sort.Strings(*deleted)
+	if !reflect.DeepEqual(expired, want) || !reflect.DeepEqual(*deleted, want) {
+		t.Errorf("got expired %v, deleted %v, want %v", expired, *deleted, want)
+	}
+}
+
+func TestEnforceRetentionContinuesPastErrors(t *testing.T) {
+	indices, deleted := newRetentionTestIndices(t, true)
+	expired, err := indices.EnforceRetention(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "cards-reqlog-000001") {
+		t.Errorf("got error %v, want the failed deletion of cards-reqlog-000001", err)
+	}
+	want := []string{fmt.Sprintf("cardsjanuary-%d", time.Now().Year()-2)}
+	if !reflect.DeepEqual(expired, want) || !reflect.DeepEqual(*deleted, want) {
+		t.Errorf("got expired %v, deleted %v, want %v", expired, *deleted, want)
+	}
+}
+
+func TestEnforceRetentionLeavesISMIndices(t *testing.T) {
+	indices, deleted := newRetentionTestIndices(t, false)
+	// as Run on OpenSearch, where the ISM policy deletes the request log indices
+	expired, err := indices.enforceRetention(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{fmt.Sprintf("cardsjanuary-%d", time.Now().Year()-2)}
+	if !reflect.DeepEqual(expired, want) || !reflect.DeepEqual(*deleted, want) {
+		t.Errorf("got expired %v, deleted %v, want %v", expired, *deleted, want)
+	}
+}
@@ -0,0 +1,124 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// ============ Constants =============
+
+var ErrResponseLogNotFound = errors.New("response log not found")
+
+// ResponseLog is a request/response log of a service.
+type ResponseLog struct {
+	Index     string     `json:"-"`
+	ID        string     `json:"-"`
+	AppId     string     `json:"AppId"`
+	RequestId string     `json:"RequestId"`
+	Timestamp *time.Time `json:"@timestamp,omitempty"`
+	// Fields is the whole document.
+	Fields map[string]interface{} `json:"-"`
+}
+
+// ResponseLogQuery narrows a lookup of the response logs. The zero value searches all the indices.
+type ResponseLogQuery struct {
+	// From and To bound the time of the logs. The monthly indices of PostResponseOpenSearch are narrowed to the
+	// months of the range, their logs having no timestamp.
+	From time.Time
+	To   time.Time
+	// Size is the maximum number of logs returned. The default is 10.
+	Size int
+}
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// FindResponseLogs returns the logs of the request, the latest first, from the request log indices and from the
+// monthly indices of PostResponseOpenSearch, without needing the util.Lock row of the request.
+func (r *RequestLogIndices) FindResponseLogs(ctx context.Context, appId, reqId string, query ResponseLogQuery) ([]ResponseLog, error) {
+	boolQuery := elastic.NewBoolQuery().Filter(
+		elastic.NewTermQuery("AppId.keyword", appId),
+		elastic.NewTermQuery("RequestId.keyword", reqId),
+	)
+	if !query.From.IsZero() || !query.To.IsZero() {
+		timeRange := elastic.NewRangeQuery(RequestLogTimestamp)
+		if !query.From.IsZero() {
+			timeRange = timeRange.Gte(query.From.UTC().Format(time.RFC3339Nano))
+		}
+		if !query.To.IsZero() {
+			timeRange = timeRange.Lte(query.To.UTC().Format(time.RFC3339Nano))
+		}
+		// the logs of the monthly indices have no timestamp
+		boolQuery = boolQuery.Filter(elastic.NewBoolQuery().MinimumNumberShouldMatch(1).Should(
+			timeRange,
+			elastic.NewBoolQuery().MustNot(elastic.NewExistsQuery(RequestLogTimestamp)),
+		))
+	}
+	size := query.Size
+	if size <= 0 {
+		size = 10
+	}
+
+	result, err := r.client.Client.Search(r.lookupIndices(query.From, query.To)...).
+		IgnoreUnavailable(true).
+		AllowNoIndices(true).
+		Query(boolQuery).
+		SortBy(elastic.NewFieldSort(RequestLogTimestamp).Desc().UnmappedType("date")).
+		Size(size).
+		Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the response logs of appId %s, reqId %s: %w", appId, reqId, err)
+	}
+	logs := make([]ResponseLog, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		log := ResponseLog{Index: hit.Index, ID: hit.Id}
+		if err = json.Unmarshal(hit.Source, &log); err != nil {
+			return nil, fmt.Errorf("error while decoding response log %s/%s: %w", hit.Index, hit.Id, err)
+		}
+		if err = json.Unmarshal(hit.Source, &log.Fields); err != nil {
+			return nil, fmt.Errorf("error while decoding response log %s/%s: %w", hit.Index, hit.Id, err)
+		}
+		logs = append(logs, log)
+	}
+	return logs, nil
+}
+
+// FindResponseLog returns the latest log of the request, or ErrResponseLogNotFound.
+func (r *RequestLogIndices) FindResponseLog(ctx context.Context, appId, reqId string, query ResponseLogQuery) (*ResponseLog, error) {
+	query.Size = 1
+	logs, err := r.FindResponseLogs(ctx, appId, reqId, query)
+	if err != nil {
+		return nil, err
+	}
+	if len(logs) == 0 {
+		return nil, fmt.Errorf("%w: appId %s, reqId %s", ErrResponseLogNotFound, appId, reqId)
+	}
+	return &logs[0], nil
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+// lookupIndices returns the request log index pattern, and the monthly indices of the time range,
+// or the pattern of all of them.
+func (r *RequestLogIndices) lookupIndices(from, to time.Time) []string {
+	indices := []string{r.IndexPattern()}
+	if from.IsZero() {
+		for month := time.January; month <= time.December; month++ {
+			indices = append(indices, r.cfg.Service+strings.ToLower(month.String())+"-*")
+		}
+		return indices
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+	for month := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC); !month.After(to); month = month.AddDate(0, 1, 0) {
+		indices = append(indices, r.cfg.Service+strings.ToLower(month.Month().String())+"-"+strconv.Itoa(month.Year()))
+	}
+	return indices
+}
@@ -0,0 +1,50 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+func TestFindResponseLog(t *testing.T) {
+	var path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		raw, _ := io.ReadAll(r.Body)
+		body = string(raw)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"hits":{"total":{"value":1},"hits":[{"_index":"cards-reqlog-000002","_id":"x1",
+			"_source":{"AppId":"app-1","RequestId":"req-1","@timestamp":"2024-03-05T10:00:00Z","status":200}}]}}`)
+	}))
+	defer server.Close()
+
+	client, err := elastic.NewSimpleClient(elastic.SetURL(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := (&ElasticClient{Client: client}).NewRequestLogIndices(RequestLogConfig{Service: "cards"})
+	log, err := logs.FindResponseLog(context.Background(), "app-1", "req-1", ResponseLogQuery{
+		From: time.Date(2024, 2, 20, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "/cards-reqlog-%2A,cardsfebruary-2024,cardsmarch-2024/_search"; path != want && path != strings.ReplaceAll(want, "%2A", "*") {
+		t.Errorf("got path %s, want %s", path, want)
+	}
+	if !strings.Contains(body, `"AppId.keyword":"app-1"`) || !strings.Contains(body, `"from":"2024-02-20T00:00:00Z"`) {
+		t.Errorf("unexpected query %s", body)
+	}
+	if log.Index != "cards-reqlog-000002" || log.RequestId != "req-1" || log.Timestamp == nil || log.Fields["status"] != float64(200) {
+		t.Errorf("unexpected log %+v", log)
+	}
+}
@@ -47,6 +47,8 @@ var PostgresConfigParams = []string{
 
 // GetPgConn creates the database connection and the *gorm.Db object.
 // It doesn't set the db logger. You would need to explicity set it using db.SetLogger(<logger>)
+//
+// Deprecated: use GetPostgres or NewPostgres, which return a gorm v2 connection and an error instead of panicking.
 func GetPgConn(dbCredPath string, pgConfigKey string) *gorm.DB {
 	pgConn.Do(func() {
 		logger.GetLoggerV3().Info("initiating postgres connection", dbCredPath, pgConfigKey)
@@ -71,17 +73,18 @@ func GetPgConn(dbCredPath string, pgConfigKey string) *gorm.DB {
 			logger.GetLoggerV3().Error(err.Error())
 			return
 		} else {
-			pgConnStr := createPgConnString(pgDbConfigs)
-			if db, err = gorm.Open("postgres", pgConnStr); err != nil {
-				err = fmt.Errorf("initialize postgres db connection failed: %s", err)
+			pool, err := ParseDBPoolConfig(pgConfigKey, pgDbConfigs, util.GetConfigValue)
+			if err != nil {
 				logger.GetLoggerV3().Error(err.Error())
 				return
 			}
-			if db, err = setPgConnLimits(db, pgDbConfigs); err != nil {
-				err = fmt.Errorf("error setting connection limits: %s", err)
+			pgConnStr := createPgConnString(pgDbConfigs, pool)
+			if db, err = gorm.Open("postgres", pgConnStr); err != nil {
+				err = fmt.Errorf("initialize postgres db connection failed: %s", err)
 				logger.GetLoggerV3().Error(err.Error())
 				return
 			}
+			pool.apply(db.DB())
 			db.BlockGlobalUpdate(true)
 			//db.SetLogger(logger.GetLogger())
 			logger.GetLoggerV3().Info("postgres connection established")
@@ -96,7 +99,7 @@ createPgConnString reads the given database connection config map,
 and creates the collated string argument to be used in
 creating database connection pool.
 */
-func createPgConnString(pgDbConfigs map[string]string) string {
+func createPgConnString(pgDbConfigs map[string]string, pool DBPoolConfig) string {
 	var connStrBuilder strings.Builder
 	for _, val := range PostgresConfigParams {
 		if val == PostgresMaxOpenConn || val == PostgresMaxIdleConn { // these will be configured later directly on the connection pool object (client)
@@ -107,30 +110,12 @@ func createPgConnString(pgDbConfigs map[string]string) string {
 		connStrBuilder.WriteString(util.GetConfigValue(pgDbConfigs[val]))
 		connStrBuilder.WriteString(" ") // delimiter
 	}
-	return connStrBuilder.String()
-}
-
-// setPgConnLimits sets the max number of possible & idle connections in the database connection pool.
-func setPgConnLimits(db *gorm.DB, pgDbConfigs map[string]string) (*gorm.DB, error) {
-	// setting maximum number of connections that this connection pool can have
-	if maxOpenConnCountStr, found := pgDbConfigs[PostgresMaxOpenConn]; found {
-		maxOpenConnCount, err := strconv.Atoi(util.GetConfigValue(maxOpenConnCountStr))
-		if err != nil {
-			err = fmt.Errorf("error setting max open connections: %s", err)
-			// logger.GetLogger().Println(err)
-			return db, err
-		}
-		db.DB().SetMaxOpenConns(maxOpenConnCount)
+	// session settings, sent as run-time parameters on connection
+	if pool.ApplicationName != "" {
+		connStrBuilder.WriteString(DBApplicationName + "=" + quotePgConnValue(pool.ApplicationName) + " ")
 	}
-	// setting maximum number of idle (in reserve) connections that this connection pool
-	if maxIdleConnCountStr, found := pgDbConfigs[PostgresMaxIdleConn]; found {
-		maxIdleConnCount, err := strconv.Atoi(util.GetConfigValue(maxIdleConnCountStr))
-		if err != nil {
-			err = fmt.Errorf("error setting max idle connections: %s", err)
-			//logger.GetLogger().Println(err)
-			return db, err
-		}
-		db.DB().SetMaxIdleConns(maxIdleConnCount)
+	if pool.StatementTimeout > 0 {
+		connStrBuilder.WriteString(DBStatementTimeout + "=" + strconv.FormatInt(pool.StatementTimeout.Milliseconds(), 10) + " ")
 	}
-	return db, nil
+	return connStrBuilder.String()
 }
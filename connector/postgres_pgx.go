@@ -0,0 +1,168 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/happay/cms-utils-go/v3/logger"
+	"github.com/happay/cms-utils-go/v3/util"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// PostgresConfig holds the connection settings of a postgres database.
+type PostgresConfig struct {
+	Host     string
+	Port     string
+	DBName   string
+	SslMode  string
+	User     string
+	Password string
+
+	// DBPoolConfig holds the connection pool limits, the statement timeout and the application name.
+	DBPoolConfig
+
+	// Replicas are the read replicas, as host or host:port. The port defaults to Port.
+	// When set, reads are routed to the replicas and writes and transactions to the primary.
+	Replicas []string
+	// ReplicaPolicy picks the replica of each read. The default is ReplicaRoundRobin.
+	ReplicaPolicy ReplicaPolicy
+
+	// ConnectRetries is the number of times the initial ping is retried. The default is DefaultConnectRetries.
+	ConnectRetries int
+	// ConnectRetryDelay is the delay before the first retry, doubled on each retry. The default is DefaultConnectRetryDelay.
+	ConnectRetryDelay time.Duration
+	// Logger is the gorm logger. The default is the gorm default logger.
+	Logger gormlogger.Interface
+}
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// LoadPostgresConfig reads the pgConfigKey database config from the dbCredPath yaml file, which can hold several
+// named databases. Each value of the config is an env variable or Parameter Store key resolved with util.GetConfigValue.
+// It returns a *DBConfigError listing every invalid key, including a missing host or dbname.
+func LoadPostgresConfig(dbCredPath, pgConfigKey string) (cfg PostgresConfig, err error) {
+	pgDbConfigs, err := readDbConfig(dbCredPath, pgConfigKey)
+	if err != nil {
+		return
+	}
+	var invalid []InvalidConfigKey
+	var poolErr *DBConfigError
+	if cfg.DBPoolConfig, err = ParseDBPoolConfig(pgConfigKey, pgDbConfigs, util.GetConfigValue); errors.As(err, &poolErr) {
+		invalid = poolErr.InvalidKeys
+	}
+
+	get := func(key string) string {
+		if rawValue, found := pgDbConfigs[key]; found {
+			return strings.TrimSpace(util.GetConfigValue(rawValue))
+		}
+		return ""
+	}
+	cfg.Host = get(PostgresHost)
+	cfg.Port = get(PostgresPort)
+	cfg.DBName = get(PostgresDBName)
+	cfg.SslMode = get(PostgresSslMode)
+	cfg.User = get(PostgresDBUser)
+	cfg.Password = get(PostgresPassword)
+	for _, host := range strings.Split(get(PostgresReplicaHosts), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			cfg.Replicas = append(cfg.Replicas, host)
+		}
+	}
+	cfg.ReplicaPolicy = ReplicaPolicy(get(PostgresReplicaPolicy))
+
+	for _, required := range []struct{ key, value string }{{PostgresHost, cfg.Host}, {PostgresDBName, cfg.DBName}} {
+		if required.value == "" {
+			invalid = append(invalid, InvalidConfigKey{Key: required.key, Err: errMissingConfigValue})
+		}
+	}
+	if len(invalid) != 0 {
+		err = &DBConfigError{ConfigKey: pgConfigKey, InvalidKeys: invalid}
+	}
+	return
+}
+
+// NewPostgres creates a gorm v2 connection pool using the pgx driver, and pings the database,
+// retrying with an exponential backoff until it answers, the retries are exhausted or ctx is done.
+func NewPostgres(ctx context.Context, cfg PostgresConfig) (*gorm.DB, error) {
+	gormConfig := &gorm.Config{
+		DisableAutomaticPing: true, // pinged below with retries
+	}
+	if cfg.Logger != nil {
+		gormConfig.Logger = cfg.Logger
+	}
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: cfg.dsn()}), gormConfig)
+	if err != nil {
+		return nil, fmt.Errorf("initialize postgres db connection failed: %w", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("initialize postgres db connection failed: %w", err)
+	}
+	cfg.DBPoolConfig.apply(sqlDB)
+
+	name := fmt.Sprintf("postgres %s:%s/%s", cfg.Host, cfg.Port, cfg.DBName)
+	if err = pingWithRetries(ctx, sqlDB, name, cfg.ConnectRetries, cfg.ConnectRetryDelay); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	logger.GetLoggerV3().Info(fmt.Sprintf("postgres connection established to %s:%s/%s", cfg.Host, cfg.Port, cfg.DBName))
+	if len(cfg.Replicas) != 0 {
+		if err = registerReplicas(ctx, db, cfg); err != nil {
+			sqlDB.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// GetPostgres returns the connection pool of the pgConfigKey database of the dbCredPath yaml file.
+// The pool is created on the first call for a database, and shared by the next calls.
+// Nothing is kept if the creation fails, so that the next call tries again.
+func GetPostgres(ctx context.Context, dbCredPath, pgConfigKey string) (*gorm.DB, error) {
+	return postgresInstances.getOrCreate(dbCredPath+"#"+pgConfigKey, func() (*gorm.DB, error) {
+		cfg, err := LoadPostgresConfig(dbCredPath, pgConfigKey)
+		if err != nil {
+			return nil, err
+		}
+		return NewPostgres(ctx, cfg)
+	})
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+var postgresInstances dbInstances
+
+// dsn builds the keyword/value connection string, quoting the values so that they can contain spaces and quotes.
+func (cfg PostgresConfig) dsn() string {
+	params := [][2]string{
+		{PostgresHost, cfg.Host},
+		{PostgresPort, cfg.Port},
+		{PostgresDBName, cfg.DBName},
+		{PostgresSslMode, cfg.SslMode},
+		{PostgresDBUser, cfg.User},
+		{PostgresPassword, cfg.Password},
+		{DBApplicationName, cfg.ApplicationName},
+	}
+	if cfg.StatementTimeout > 0 {
+		params = append(params, [2]string{DBStatementTimeout, strconv.FormatInt(cfg.StatementTimeout.Milliseconds(), 10)})
+	}
+	var connStrBuilder strings.Builder
+	for _, param := range params {
+		if param[1] == "" {
+			continue
+		}
+		connStrBuilder.WriteString(param[0] + "=" + quotePgConnValue(param[1]) + " ")
+	}
+	return strings.TrimSpace(connStrBuilder.String())
+}
+
+// quotePgConnValue quotes a value of a keyword/value connection string, so that it can contain spaces and quotes.
+func quotePgConnValue(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
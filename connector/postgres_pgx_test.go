@@ -0,0 +1,161 @@
+package connector
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func writeDbConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "db.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPostgresConfig(t *testing.T) {
+	path := writeDbConfig(t, `
+payments:
+  host: PAYMENTS_DB_HOST
+  port: PAYMENTS_DB_PORT
+  dbname: PAYMENTS_DB_NAME
+  user: PAYMENTS_DB_USER
+  password: PAYMENTS_DB_PASSWORD
+  max_open_connections: PAYMENTS_DB_MAX_OPEN
+  replica_hosts: PAYMENTS_DB_REPLICAS
+  replica_policy: PAYMENTS_DB_REPLICA_POLICY
+broken:
+  port: PAYMENTS_DB_PORT
+  dbname: BROKEN_DB_NAME
+  max_open_connections: BROKEN_DB_MAX_OPEN
+`)
+	t.Setenv("PAYMENTS_DB_HOST", "primary.internal")
+	t.Setenv("PAYMENTS_DB_PORT", "5432")
+	t.Setenv("PAYMENTS_DB_NAME", "payments")
+	t.Setenv("PAYMENTS_DB_USER", "api")
+	t.Setenv("PAYMENTS_DB_PASSWORD", "s3cr3t")
+	t.Setenv("PAYMENTS_DB_MAX_OPEN", "20")
+	t.Setenv("PAYMENTS_DB_REPLICAS", "replica-1.internal, replica-2.internal:5433,")
+	t.Setenv("PAYMENTS_DB_REPLICA_POLICY", "least_latency")
+	t.Setenv("BROKEN_DB_NAME", " ")
+	t.Setenv("BROKEN_DB_MAX_OPEN", "many")
+
+	cfg, err := LoadPostgresConfig(path, "payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PostgresConfig{
+		Host:          "primary.internal",
+		Port:          "5432",
+		DBName:        "payments",
+		User:          "api",
+		Password:      "s3cr3t",
+		DBPoolConfig:  DBPoolConfig{MaxOpenConns: 20},
+		Replicas:      []string{"replica-1.internal", "replica-2.internal:5433"},
+		ReplicaPolicy: ReplicaLeastLatency,
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("got %+v, want %+v", cfg, want)
+	}
+
+	_, err = LoadPostgresConfig(path, "broken")
+	var configErr *DBConfigError
+	if !errors.As(err, &configErr) {
+		t.Fatalf("got error %v, want a *DBConfigError", err)
+	}
+	invalid := map[string]error{}
+	for _, key := range configErr.InvalidKeys {
+		invalid[key.Key] = key.Err
+	}
+	if len(invalid) != 3 || invalid[PostgresHost] != errMissingConfigValue || invalid[PostgresDBName] != errMissingConfigValue ||
+		invalid[DBMaxOpenConn] == nil {
+		t.Errorf("got invalid keys %v, want host, dbname and %s", configErr.InvalidKeys, DBMaxOpenConn)
+	}
+
+	if _, err = LoadPostgresConfig(path, "reporting"); err == nil {
+		t.Error("loaded a missing config")
+	}
+}
+
+func TestPostgresDSN(t *testing.T) {
+	cfg := PostgresConfig{
+		Host:         "primary.internal",
+		Port:         "5432",
+		DBName:       "payments",
+		User:         "api",
+		Password:     `it's a \secret`,
+		DBPoolConfig: DBPoolConfig{ApplicationName: "payments api", StatementTimeout: 5 * time.Second},
+	}
+	want := `host='primary.internal' port='5432' dbname='payments' user='api' password='it\'s a \\secret' ` +
+		`application_name='payments api' statement_timeout='5000'`
+	if dsn := cfg.dsn(); dsn != want {
+		t.Errorf("got %s, want %s", dsn, want)
+	}
+}
+
+func TestDBInstancesDoNotBlockOthers(t *testing.T) {
+	var instances dbInstances
+	slow := make(chan struct{})
+	slowDone := make(chan struct{})
+	go func() {
+		defer close(slowDone)
+		instances.getOrCreate("slow", func() (*gorm.DB, error) {
+			<-slow
+			return nil, errors.New("unreachable")
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	var creates int32
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			instances.getOrCreate("fast", func() (*gorm.DB, error) {
+				atomic.AddInt32(&creates, 1)
+				return &gorm.DB{}, nil
+			})
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("creating a pool blocked on another one")
+	}
+	close(slow)
+	<-slowDone
+	if creates != 1 {
+		t.Errorf("pool created %d times", creates)
+	}
+	if _, found := instances.lookup("slow"); found {
+		t.Error("failed pool registered")
+	}
+}
+
+func TestLegacyPgConnStringQuotesApplicationName(t *testing.T) {
+	pgDbConfigs := map[string]string{}
+	for _, param := range PostgresConfigParams {
+		pgDbConfigs[param] = "LEGACY_DB_" + strings.ToUpper(param)
+		t.Setenv(pgDbConfigs[param], "x")
+	}
+	connStr := createPgConnString(pgDbConfigs, DBPoolConfig{ApplicationName: `payments api's worker`})
+	if want := ` application_name='payments api\'s worker' `; !strings.Contains(connStr, want) {
+		t.Errorf("got %s, want it to contain %s", connStr, want)
+	}
+}
@@ -0,0 +1,168 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/happay/cms-utils-go/v3/logger"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// ============ Constants =============
+
+// postgres replica config params
+const (
+	// PostgresReplicaHosts holds a comma separated list of host or host:port.
+	PostgresReplicaHosts  = "replica_hosts"
+	PostgresReplicaPolicy = "replica_policy"
+)
+
+// ReplicaPolicy picks the replica a read is routed to.
+type ReplicaPolicy string
+
+const (
+	ReplicaRoundRobin   ReplicaPolicy = "round_robin"
+	ReplicaLeastLatency ReplicaPolicy = "least_latency"
+)
+
+// replicaProbeInterval is how often the least latency policy measures the latency of the replicas.
+const replicaProbeInterval = 10 * time.Second
+
+// sqlDBClosedMessage is the message of the unexported error returned by a closed *sql.DB.
+const sqlDBClosedMessage = "sql: database is closed"
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// UsePrimary routes the queries of db to the primary, e.g. to read a row just written,
+// which may not have reached the replicas yet.
+//
+//	connector.UsePrimary(db).First(&card, id)
+func UsePrimary(db *gorm.DB) *gorm.DB {
+	return db.Clauses(dbresolver.Write)
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+// registerReplicas routes the reads of db to the replicas of the config. The writes, the raw Exec and the
+// transactions stay on the primary.
+func registerReplicas(ctx context.Context, db *gorm.DB, cfg PostgresConfig) error {
+	var policy dbresolver.Policy
+	switch cfg.ReplicaPolicy {
+	case "", ReplicaRoundRobin:
+		policy = dbresolver.StrictRoundRobinPolicy()
+	case ReplicaLeastLatency:
+		policy = newLeastLatencyPolicy(replicaProbeInterval)
+	default:
+		return fmt.Errorf("unknown postgres replica policy %q", cfg.ReplicaPolicy)
+	}
+
+	replicas := make([]gorm.Dialector, 0, len(cfg.Replicas))
+	for _, replica := range cfg.Replicas {
+		replicaCfg := cfg
+		replicaCfg.Host = replica
+		if host, port, err := net.SplitHostPort(replica); err == nil {
+			replicaCfg.Host, replicaCfg.Port = host, port
+		}
+		replicas = append(replicas, postgres.New(postgres.Config{DSN: replicaCfg.dsn()}))
+	}
+	resolver := dbresolver.Register(dbresolver.Config{
+		Replicas: replicas,
+		Policy:   policy,
+	})
+	if cfg.MaxOpenConns > 0 {
+		resolver.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		resolver.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		resolver.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	if cfg.ConnMaxIdleTime > 0 {
+		resolver.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	}
+	if err := db.Use(resolver); err != nil {
+		return fmt.Errorf("error while registering postgres replicas: %w", err)
+	}
+
+	// an unreachable replica doesn't prevent the start, as it may come back before it is needed
+	resolver.Call(func(connPool gorm.ConnPool) error {
+		if pinger, ok := connPool.(interface{ PingContext(context.Context) error }); ok {
+			if err := pinger.PingContext(ctx); err != nil {
+				logger.GetLoggerV3().Warn(fmt.Sprintf("postgres replica of %s is not reachable: %s", cfg.DBName, err))
+			}
+		}
+		return nil
+	})
+	logger.GetLoggerV3().Info(fmt.Sprintf("postgres reads of %s routed to %d replicas", cfg.DBName, len(cfg.Replicas)))
+	return nil
+}
+
+// leastLatencyPolicy routes the reads to the replica which answered a ping the fastest.
+// The latencies are measured in the background, at most every interval, until the replica pools are closed.
+type leastLatencyPolicy struct {
+	interval time.Duration
+
+	mu        sync.Mutex
+	latencies map[gorm.ConnPool]time.Duration
+	probedAt  time.Time
+	closed    bool
+}
+
+func newLeastLatencyPolicy(interval time.Duration) *leastLatencyPolicy {
+	return &leastLatencyPolicy{
+		interval:  interval,
+		latencies: make(map[gorm.ConnPool]time.Duration),
+	}
+}
+
+func (p *leastLatencyPolicy) Resolve(connPools []gorm.ConnPool) gorm.ConnPool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed && time.Since(p.probedAt) > p.interval {
+		p.probedAt = time.Now()
+		go p.probe(connPools)
+	}
+	// the replicas not measured yet have a zero latency, so that they are tried
+	best := connPools[0]
+	for _, connPool := range connPools[1:] {
+		if p.latencies[connPool] < p.latencies[best] {
+			best = connPool
+		}
+	}
+	return best
+}
+
+func (p *leastLatencyPolicy) probe(connPools []gorm.ConnPool) {
+	for _, connPool := range connPools {
+		pinger, ok := connPool.(interface{ PingContext(context.Context) error })
+		if !ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), p.interval/2)
+		start := time.Now()
+		var latency time.Duration
+		err := pinger.PingContext(ctx)
+		if err != nil {
+			latency = math.MaxInt64
+		} else {
+			latency = time.Since(start)
+		}
+		cancel()
+		p.mu.Lock()
+		if err != nil && err.Error() == sqlDBClosedMessage {
+			// the pools were closed along with the db, no read will be routed anymore
+			p.closed = true
+			p.mu.Unlock()
+			return
+		}
+		p.latencies[connPool] = latency
+		p.mu.Unlock()
+	}
+}
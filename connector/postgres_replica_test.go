@@ -0,0 +1,96 @@
+package connector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// fakeReplica is a replica pool answering the pings after latency.
+type fakeReplica struct {
+	gorm.ConnPool
+	latency time.Duration
+	down    bool
+	// closed is set to a closed pool once the replica is closed
+	closed atomic.Pointer[sql.DB]
+	pings  atomic.Int32
+}
+
+func (r *fakeReplica) PingContext(ctx context.Context) error {
+	r.pings.Add(1)
+	if db := r.closed.Load(); db != nil {
+		return db.PingContext(ctx)
+	}
+	if r.down {
+		return errors.New("connection refused")
+	}
+	time.Sleep(r.latency)
+	return nil
+}
+
+func probed(p *leastLatencyPolicy, count int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.latencies) == count
+}
+
+func TestLeastLatencyPolicy(t *testing.T) {
+	slow := &fakeReplica{latency: 30 * time.Millisecond}
+	fast := &fakeReplica{latency: time.Millisecond}
+	down := &fakeReplica{down: true}
+	replicas := []gorm.ConnPool{slow, down, fast}
+	p := newLeastLatencyPolicy(time.Hour)
+
+	// nothing measured yet
+	if got := p.Resolve(replicas); got != slow {
+		t.Errorf("first read routed to %+v, want the first replica", got)
+	}
+	eventually(t, func() bool { return probed(p, 3) }, "replicas not probed")
+	for i := 0; i < 3; i++ {
+		if got := p.Resolve(replicas); got != fast {
+			t.Fatalf("read routed to %+v, want the fastest replica", got)
+		}
+	}
+	if pings := slow.pings.Load(); pings != 1 {
+		t.Errorf("replica probed %d times within the interval", pings)
+	}
+}
+
+func TestLeastLatencyPolicyStopsOnClose(t *testing.T) {
+	replica := &fakeReplica{}
+	replicas := []gorm.ConnPool{replica}
+	p := newLeastLatencyPolicy(time.Millisecond)
+
+	p.Resolve(replicas)
+	eventually(t, func() bool { return probed(p, 1) }, "replica not probed")
+
+	// closing the db closes the replica pools
+	db, err := sql.Open("pgx", "host=localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	replica.closed.Store(db)
+	time.Sleep(2 * time.Millisecond)
+	p.Resolve(replicas)
+	eventually(t, func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.closed
+	}, "probe not stopped")
+
+	pings := replica.pings.Load()
+	for i := 0; i < 3; i++ {
+		time.Sleep(2 * time.Millisecond)
+		p.Resolve(replicas)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if got := replica.pings.Load(); got != pings {
+		t.Errorf("replica probed %d times after close", got-pings)
+	}
+}
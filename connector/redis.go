@@ -2,10 +2,8 @@ package connector
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
 	"os"
-	"sync"
 	"time"
 
 	"github.com/happay/cms-utils-go/v2/logger"
@@ -16,32 +14,39 @@ import (
 
 const Pong = "PONG"
 
-var redisClient *redis.Client
-var redisClusterClient *redis.ClusterClient
-var redisConn sync.Once
+// names of the instances created by the legacy connection functions
+const (
+	redisConnInstancePrefix = "redis-conn:"
+	redisAuthInstance       = "redis-cluster-auth"
+)
 
 // GetRedisConn returns the redis.Client object. It takes a parameter redisAddrKey
 // which is the Redis env variable key stored on the os or AWS Parameter Store.
 // This function will try to get the value of the input key from the os or Parameter Store and create the redisClient.
+// One client is created per redisAddrKey.
+//
+// Deprecated: use LoadRedisConfig and GetRedisInstance, which support authentication, TLS and pool settings.
 func GetRedisConn(redisAddrKey string) *redis.Client {
-	redisConn.Do(func() {
-		redisClient = redis.NewClient(&redis.Options{
+	client, err := getOrCreateRedisInstance(redisConnInstancePrefix+redisAddrKey, func() (redis.UniversalClient, error) {
+		redisClient := redis.NewClient(&redis.Options{
 			Addr:     util.GetConfigValue(redisAddrKey),
 			Password: "", // no password set
 			DB:       0,  // use default DB
 		})
-		ctx := context.Background()
-		pong, err := redisClient.Ping(ctx).Result()
-		if err != nil || pong != Pong {
-			reason := fmt.Sprintf("Error while creating Redis connection pool: %s", err)
-			logger.GetLogger("", "").Println(reason)
+		if err := pingRedis(context.Background(), redisClient); err != nil {
+			// the client is kept, as it reconnects once redis is reachable
+			logger.GetLoggerV3().Error(fmt.Sprintf("Error while creating Redis connection pool: %s", err))
 		}
+		return redisClient, nil
 	})
+	if err != nil {
+		logger.GetLoggerV3().Error(err.Error())
+		return nil
+	}
+	redisClient, _ := client.(*redis.Client)
 	return redisClient
 }
 
-var createRedisConnection sync.Once
-
 const (
 	RedisGet    = "redis/get"
 	RedisSet    = "redis/set"
@@ -49,76 +54,112 @@ const (
 	RedisDelete = "redis/delete"
 )
 
+// GetRedisConnectionWithAuth returns the redis cluster client configured by the REDIS_URL and REDIS_PASSWORD env variables.
+// The TLS certificate is verified, unless REDIS_TLS_INSECURE_SKIP_VERIFY is set to true.
+//
+// Deprecated: use LoadRedisConfig and GetRedisInstance, which support multiple seed addresses,
+// CA bundles and pool settings.
 func GetRedisConnectionWithAuth(ctx context.Context) *redis.ClusterClient {
-	createRedisConnection.Do(func() {
-		redisClusterClient = redis.NewClusterClient(&redis.ClusterOptions{
-			Addrs:    []string{os.Getenv("REDIS_URL")},
-			Password: os.Getenv("REDIS_PASSWORD"),
-			TLSConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-
-			ReadOnly:       false,
-			RouteRandomly:  false,
-			RouteByLatency: false,
+	client, err := getOrCreateRedisInstance(redisAuthInstance, func() (redis.UniversalClient, error) {
+		clusterClient, err := newRedisClusterClient(RedisConfig{
+			Mode:                  RedisModeCluster,
+			Addrs:                 []string{os.Getenv("REDIS_URL")},
+			Password:              os.Getenv("REDIS_PASSWORD"),
+			TLS:                   true,
+			TLSInsecureSkipVerify: os.Getenv("REDIS_TLS_INSECURE_SKIP_VERIFY") == "true",
 		})
-		pong, err := redisClusterClient.Ping(ctx).Result()
-		if err != nil || pong != "PONG" {
-			reason := fmt.Sprintf("Error while creating Redis connection pool: %s", err)
-			logger.GetLogger("", "").Println(reason)
+		if err != nil {
+			return nil, err
+		}
+		if err = pingRedis(ctx, clusterClient); err != nil {
+			// the client is kept, as it reconnects once redis is reachable
+			logger.GetLoggerV3().Error(fmt.Sprintf("Error while creating Redis connection pool: %s", err))
 		}
+		return clusterClient, nil
 	})
-	return redisClusterClient
+	if err != nil {
+		logger.GetLoggerV3().Error(err.Error())
+		return nil
+	}
+	clusterClient, _ := client.(*redis.ClusterClient)
+	return clusterClient
 }
 
+// SetRedisKey sets the key on the default redis store.
+//
+// Deprecated: use RedisStore.Set, which accepts a context.
 func SetRedisKey(key string, data string, exp time.Duration) error {
-	ctx := context.Background()
-	GetRedisConnectionWithAuth(ctx)
-	return redisClusterClient.Set(ctx, key, data, exp).Err()
+	store, err := DefaultRedisStore()
+	if err != nil {
+		return err
+	}
+	return store.Set(context.Background(), key, data, exp)
 }
 
+// SetRedisKeyObject sets the key on the default redis store. The data must implement encoding.BinaryMarshaler.
+//
+// Deprecated: use SetJSON or SetObject, which encode any value with a codec.
 func SetRedisKeyObject(key string, data interface{}, exp time.Duration) error {
-	ctx := context.Background()
-	GetRedisConnectionWithAuth(ctx)
-	return redisClusterClient.Set(ctx, key, data, exp).Err()
+	store, err := DefaultRedisStore()
+	if err != nil {
+		return err
+	}
+	return store.Set(context.Background(), key, data, exp)
 }
 
+// GetRedisKeyValueString gets the value of the key from the default redis store.
+//
+// Deprecated: use RedisStore.Get, which accepts a context.
 func GetRedisKeyValueString(key string) (result string, err error) {
-	ctx := context.Background()
-	GetRedisConnectionWithAuth(ctx)
-	if !RedisKeyExists(key) {
-		err = fmt.Errorf("key %s doesn't exist", key)
+	store, err := DefaultRedisStore()
+	if err != nil {
 		return
 	}
-	result = redisClusterClient.Get(ctx, key).Val()
-	return
+	return store.Get(context.Background(), key)
 }
 
+// GetRedisKeyValueBytes gets the value of the key from the default redis store.
+//
+// Deprecated: use RedisStore.GetBytes, which accepts a context.
 func GetRedisKeyValueBytes(key string) (result []byte, err error) {
-	ctx := context.Background()
-	GetRedisConnectionWithAuth(ctx)
-	if !RedisKeyExists(key) {
-		err = fmt.Errorf("key %s doesn't exist", key)
+	store, err := DefaultRedisStore()
+	if err != nil {
 		return
 	}
-	result, err = redisClusterClient.Get(ctx, key).Bytes()
-	return
+	return store.GetBytes(context.Background(), key)
 }
 
+// RedisKeyExists checks if the key exists on the default redis store.
+//
+// Deprecated: use RedisStore.Exists, which accepts a context and returns the error.
 func RedisKeyExists(refNo string) bool {
-	ctx := context.Background()
-	GetRedisConnectionWithAuth(ctx)
-	return redisClusterClient.Exists(ctx, refNo).Val() == 1
+	store, err := DefaultRedisStore()
+	if err != nil {
+		return false
+	}
+	exists, _ := store.Exists(context.Background(), refNo)
+	return exists
 }
 
+// DeleteRedisKey deletes the key from the default redis store.
+//
+// Deprecated: use RedisStore.Delete, which accepts a context.
 func DeleteRedisKey(refNo string) error {
-	ctx := context.Background()
-	GetRedisConnectionWithAuth(ctx)
-	return redisClusterClient.Del(ctx, refNo).Err()
+	store, err := DefaultRedisStore()
+	if err != nil {
+		return err
+	}
+	return store.Delete(context.Background(), refNo)
 }
 
+// GetRedisTTL returns the remaining time to live of the key on the default redis store.
+//
+// Deprecated: use RedisStore.TTL, which accepts a context and returns the error.
 func GetRedisTTL(key string) time.Duration {
-	ctx := context.Background()
-	GetRedisConnectionWithAuth(ctx)
-	return redisClusterClient.TTL(ctx, key).Val()
+	store, err := DefaultRedisStore()
+	if err != nil {
+		return -2
+	}
+	ttl, _ := store.TTL(context.Background(), key)
+	return ttl
 }
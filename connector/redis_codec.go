@@ -0,0 +1,124 @@
+package connector
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// ============ Errors =============
+
+var (
+	// ErrRedisKeyNotFound is returned when the requested key doesn't exist on redis.
+	ErrRedisKeyNotFound = errors.New("redis key not found")
+
+	// ErrRedisEncode is returned when a value can't be encoded by the codec before storing it on redis.
+	ErrRedisEncode = errors.New("redis value encoding failed")
+
+	// ErrRedisDecode is returned when a value read from redis can't be decoded by the codec.
+	ErrRedisDecode = errors.New("redis value decoding failed")
+)
+
+// ============ Codecs =============
+
+// Codec encodes and decodes the objects stored on redis.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSONCodec encodes the objects as JSON. It is the default codec of RedisStore.
+	JSONCodec Codec = jsonCodec{}
+
+	// MsgpackCodec encodes the objects as MessagePack, which is more compact than JSON.
+	MsgpackCodec Codec = msgpackCodec{}
+
+	// GobCodec encodes the objects with encoding/gob. Only go services can decode these values.
+	GobCodec Codec = gobCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// SetObject encodes the value with the codec of the store and saves it at key with the given expiration.
+func SetObject[T any](ctx context.Context, store *RedisStore, key string, value T, exp time.Duration) error {
+	return setWithCodec(ctx, store, store.codec, key, value, exp)
+}
+
+// GetObject reads the value at key and decodes it into T with the codec of the store.
+// It returns ErrRedisKeyNotFound if the key doesn't exist and ErrRedisDecode if the value can't be decoded.
+func GetObject[T any](ctx context.Context, store *RedisStore, key string) (T, error) {
+	return getWithCodec[T](ctx, store, store.codec, key)
+}
+
+// SetJSON encodes the value as JSON and saves it at key with the given expiration.
+func SetJSON[T any](ctx context.Context, store *RedisStore, key string, value T, exp time.Duration) error {
+	return setWithCodec(ctx, store, JSONCodec, key, value, exp)
+}
+
+// GetJSON reads the value at key and decodes it from JSON into T.
+// It returns ErrRedisKeyNotFound if the key doesn't exist and ErrRedisDecode if the value can't be decoded.
+func GetJSON[T any](ctx context.Context, store *RedisStore, key string) (T, error) {
+	return getWithCodec[T](ctx, store, JSONCodec, key)
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+func setWithCodec[T any](ctx context.Context, store *RedisStore, codec Codec, key string, value T, exp time.Duration) error {
+	data, err := codec.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("%w: key %s: %w", ErrRedisEncode, key, err)
+	}
+	return store.Set(ctx, key, data, exp)
+}
+
+func getWithCodec[T any](ctx context.Context, store *RedisStore, codec Codec, key string) (result T, err error) {
+	data, err := store.GetBytes(ctx, key)
+	if err != nil {
+		return
+	}
+	if err = codec.Unmarshal(data, &result); err != nil {
+		err = fmt.Errorf("%w: key %s: %w", ErrRedisDecode, key, err)
+	}
+	return
+}
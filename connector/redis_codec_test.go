@@ -0,0 +1,65 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	type card struct {
+		Id     string
+		Amount int64
+		Tags   []string
+	}
+	in := card{Id: "card-1", Amount: 1050, Tags: []string{"virtual", "prepaid"}}
+	for name, codec := range map[string]Codec{"json": JSONCodec, "msgpack": MsgpackCodec, "gob": GobCodec} {
+		data, err := codec.Marshal(in)
+		if err != nil {
+			t.Fatalf("%s marshal: %s", name, err)
+		}
+		var out card
+		if err = codec.Unmarshal(data, &out); err != nil {
+			t.Fatalf("%s unmarshal: %s", name, err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Errorf("%s round trip mismatch: got %+v, want %+v", name, out, in)
+		}
+	}
+}
+
+func TestSetGetJSON(t *testing.T) {
+	store, mr := newTestRedisStore(t, WithCodec(MsgpackCodec))
+	ctx := context.Background()
+	type card struct {
+		Id     string `json:"id"`
+		Amount int64  `json:"amount"`
+	}
+
+	if _, err := GetJSON[card](ctx, store, "card:1"); !errors.Is(err, ErrRedisKeyNotFound) {
+		t.Fatalf("get missing key: got %v, want ErrRedisKeyNotFound", err)
+	}
+	if err := SetJSON(ctx, store, "card:1", card{Id: "card-1", Amount: 1050}, time.Minute); err != nil {
+		t.Fatalf("set: %s", err)
+	}
+	// JSON whatever the codec of the store
+	if raw, _ := mr.Get("card:1"); raw != `{"id":"card-1","amount":1050}` {
+		t.Errorf("stored %s", raw)
+	}
+	if got, err := GetJSON[card](ctx, store, "card:1"); err != nil || got != (card{Id: "card-1", Amount: 1050}) {
+		t.Fatalf("get: got %+v, %v", got, err)
+	}
+
+	mr.Set("card:2", "not json")
+	var syntaxErr *json.SyntaxError
+	if _, err := GetJSON[card](ctx, store, "card:2"); !errors.Is(err, ErrRedisDecode) || !errors.As(err, &syntaxErr) {
+		t.Errorf("get invalid value: got %v, want ErrRedisDecode wrapping the json error", err)
+	}
+	var unsupportedErr *json.UnsupportedTypeError
+	if err := SetJSON(ctx, store, "card:3", make(chan int), 0); !errors.Is(err, ErrRedisEncode) || !errors.As(err, &unsupportedErr) {
+		t.Errorf("set invalid value: got %v, want ErrRedisEncode wrapping the json error", err)
+	}
+}
@@ -0,0 +1,379 @@
+package connector
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/happay/cms-utils-go/v3/util"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+// ============ Constants =============
+
+// RedisMode is the deployment mode of the redis server.
+type RedisMode string
+
+const (
+	RedisModeStandalone RedisMode = "standalone"
+	RedisModeCluster    RedisMode = "cluster"
+	RedisModeSentinel   RedisMode = "sentinel"
+)
+
+// redis config keys, prefixed with the prefix given to LoadRedisConfig (e.g. REDIS_URL)
+const (
+	RedisConfigMode                  = "MODE"
+	RedisConfigUrl                   = "URL"
+	RedisConfigUsername              = "USERNAME"
+	RedisConfigPassword              = "PASSWORD"
+	RedisConfigDB                    = "DB"
+	RedisConfigMasterName            = "MASTER_NAME"
+	RedisConfigSentinelPassword      = "SENTINEL_PASSWORD"
+	RedisConfigTLS                   = "TLS"
+	RedisConfigTLSCAFile             = "TLS_CA_FILE"
+	RedisConfigTLSCACert             = "TLS_CA_CERT"
+	RedisConfigTLSServerName         = "TLS_SERVER_NAME"
+	RedisConfigTLSInsecureSkipVerify = "TLS_INSECURE_SKIP_VERIFY"
+	RedisConfigPoolSize              = "POOL_SIZE"
+	RedisConfigMinIdleConns          = "MIN_IDLE_CONNS"
+	RedisConfigDialTimeout           = "DIAL_TIMEOUT"
+	RedisConfigReadTimeout           = "READ_TIMEOUT"
+	RedisConfigWriteTimeout          = "WRITE_TIMEOUT"
+	RedisConfigPoolTimeout           = "POOL_TIMEOUT"
+	RedisConfigRouteByLatency        = "ROUTE_BY_LATENCY"
+	RedisConfigRouteRandomly         = "ROUTE_RANDOMLY"
+	RedisConfigReadOnly              = "READ_ONLY"
+)
+
+// RedisConfig holds the connection settings of a redis server, cluster or sentinel group.
+// Zero values fall back to the go-redis defaults.
+type RedisConfig struct {
+	Mode RedisMode
+
+	// Addrs are the seed addresses (host:port) of the cluster nodes, the sentinels, or the standalone server.
+	Addrs    []string
+	Username string
+	Password string
+	// DB is ignored in cluster mode.
+	DB int
+
+	// MasterName and SentinelPassword are only used in sentinel mode.
+	MasterName       string
+	SentinelPassword string
+
+	TLS                   bool
+	TLSCAFile             string // path of a PEM CA bundle used to verify the server
+	TLSCACert             string // PEM CA bundle used to verify the server
+	TLSServerName         string
+	TLSInsecureSkipVerify bool
+
+	PoolSize     int
+	MinIdleConns int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	PoolTimeout  time.Duration
+
+	// RouteByLatency, RouteRandomly and ReadOnly allow read only commands to be served by replica nodes.
+	// They are only used in cluster and sentinel modes.
+	RouteByLatency bool
+	RouteRandomly  bool
+	ReadOnly       bool
+}
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// LoadRedisConfig reads the redis config using util.GetConfigValue, with the keys prefixed by prefix and "_",
+// e.g. REDIS_URL, REDIS_PASSWORD, REDIS_POOL_SIZE for the prefix REDIS.
+// URL holds a comma separated list of addresses, timeouts are durations such as "500ms",
+// and the mode defaults to cluster.
+func LoadRedisConfig(prefix string) (cfg RedisConfig, err error) {
+	get := func(key string) string {
+		return strings.TrimSpace(util.GetConfigValue(prefix + "_" + key))
+	}
+	var invalid []string
+	parseInt := func(key string, dst *int) {
+		if val := get(key); val != "" {
+			n, parseErr := strconv.Atoi(val)
+			if parseErr != nil {
+				invalid = append(invalid, fmt.Sprintf("%s_%s: %s", prefix, key, parseErr))
+				return
+			}
+			*dst = n
+		}
+	}
+	parseBool := func(key string, dst *bool) {
+		if val := get(key); val != "" {
+			b, parseErr := strconv.ParseBool(val)
+			if parseErr != nil {
+				invalid = append(invalid, fmt.Sprintf("%s_%s: %s", prefix, key, parseErr))
+				return
+			}
+			*dst = b
+		}
+	}
+	parseDuration := func(key string, dst *time.Duration) {
+		if val := get(key); val != "" {
+			d, parseErr := time.ParseDuration(val)
+			if parseErr != nil {
+				invalid = append(invalid, fmt.Sprintf("%s_%s: %s", prefix, key, parseErr))
+				return
+			}
+			*dst = d
+		}
+	}
+
+	cfg.Mode = RedisMode(strings.ToLower(get(RedisConfigMode)))
+	if cfg.Mode == "" {
+		cfg.Mode = RedisModeCluster
+	}
+	for _, addr := range strings.Split(get(RedisConfigUrl), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			cfg.Addrs = append(cfg.Addrs, addr)
+		}
+	}
+	cfg.Username = get(RedisConfigUsername)
+	cfg.Password = get(RedisConfigPassword)
+	cfg.MasterName = get(RedisConfigMasterName)
+	cfg.SentinelPassword = get(RedisConfigSentinelPassword)
+	cfg.TLSCAFile = get(RedisConfigTLSCAFile)
+	cfg.TLSCACert = get(RedisConfigTLSCACert)
+	cfg.TLSServerName = get(RedisConfigTLSServerName)
+	parseInt(RedisConfigDB, &cfg.DB)
+	parseBool(RedisConfigTLS, &cfg.TLS)
+	parseBool(RedisConfigTLSInsecureSkipVerify, &cfg.TLSInsecureSkipVerify)
+	parseInt(RedisConfigPoolSize, &cfg.PoolSize)
+	parseInt(RedisConfigMinIdleConns, &cfg.MinIdleConns)
+	parseDuration(RedisConfigDialTimeout, &cfg.DialTimeout)
+	parseDuration(RedisConfigReadTimeout, &cfg.ReadTimeout)
+	parseDuration(RedisConfigWriteTimeout, &cfg.WriteTimeout)
+	parseDuration(RedisConfigPoolTimeout, &cfg.PoolTimeout)
+	parseBool(RedisConfigRouteByLatency, &cfg.RouteByLatency)
+	parseBool(RedisConfigRouteRandomly, &cfg.RouteRandomly)
+	parseBool(RedisConfigReadOnly, &cfg.ReadOnly)
+
+	if len(invalid) != 0 {
+		err = fmt.Errorf("invalid redis config: %s", strings.Join(invalid, "; "))
+		return
+	}
+	err = cfg.Validate()
+	return
+}
+
+// Validate checks that the config has the settings required by its mode.
+func (cfg RedisConfig) Validate() error {
+	if len(cfg.Addrs) == 0 {
+		return errors.New("invalid redis config: no address configured")
+	}
+	switch cfg.Mode {
+	case RedisModeStandalone, RedisModeCluster:
+	case RedisModeSentinel:
+		if cfg.MasterName == "" {
+			return errors.New("invalid redis config: master name is required in sentinel mode")
+		}
+	default:
+		return fmt.Errorf("invalid redis config: unknown mode %q", cfg.Mode)
+	}
+	return nil
+}
+
+// NewRedisClient creates a client to a standalone redis server and checks the connection with a PING.
+func NewRedisClient(ctx context.Context, cfg RedisConfig) (*redis.Client, error) {
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:         cfg.Addrs[0],
+		Username:     cfg.Username,
+		Password:     cfg.Password,
+		DB:           cfg.DB,
+		TLSConfig:    tlsConfig,
+		PoolSize:     cfg.PoolSize,
+		MinIdleConns: cfg.MinIdleConns,
+		DialTimeout:  cfg.DialTimeout,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		PoolTimeout:  cfg.PoolTimeout,
+	})
+	if err = pingRedis(ctx, client); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// NewRedisClusterClient creates a client to a redis cluster and checks the connection with a PING.
+func NewRedisClusterClient(ctx context.Context, cfg RedisConfig) (*redis.ClusterClient, error) {
+	client, err := newRedisClusterClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err = pingRedis(ctx, client); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// NewRedisSentinelClient creates a client to the master of a sentinel monitored redis and checks the connection with a PING.
+// If ReadOnly, RouteByLatency or RouteRandomly is set, read only commands are sent to the replicas.
+func NewRedisSentinelClient(ctx context.Context, cfg RedisConfig) (redis.UniversalClient, error) {
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	opts := &redis.FailoverOptions{
+		MasterName:       cfg.MasterName,
+		SentinelAddrs:    cfg.Addrs,
+		SentinelPassword: cfg.SentinelPassword,
+		Username:         cfg.Username,
+		Password:         cfg.Password,
+		DB:               cfg.DB,
+		TLSConfig:        tlsConfig,
+		PoolSize:         cfg.PoolSize,
+		MinIdleConns:     cfg.MinIdleConns,
+		DialTimeout:      cfg.DialTimeout,
+		ReadTimeout:      cfg.ReadTimeout,
+		WriteTimeout:     cfg.WriteTimeout,
+		PoolTimeout:      cfg.PoolTimeout,
+		RouteByLatency:   cfg.RouteByLatency,
+		RouteRandomly:    cfg.RouteRandomly,
+	}
+	var client redis.UniversalClient
+	if cfg.ReadOnly || cfg.RouteByLatency || cfg.RouteRandomly {
+		client = redis.NewFailoverClusterClient(opts)
+	} else {
+		client = redis.NewFailoverClient(opts)
+	}
+	if err = pingRedis(ctx, client); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// NewRedisUniversalClient creates a client for the mode of the config.
+func NewRedisUniversalClient(ctx context.Context, cfg RedisConfig) (redis.UniversalClient, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	switch cfg.Mode {
+	case RedisModeStandalone:
+		return NewRedisClient(ctx, cfg)
+	case RedisModeSentinel:
+		return NewRedisSentinelClient(ctx, cfg)
+	default:
+		return NewRedisClusterClient(ctx, cfg)
+	}
+}
+
+// GetRedisInstance returns the client registered with name. The client is created from cfg on the first call,
+// and the next calls with the same name return that client and ignore cfg.
+// Using different names, a service can connect to more than one redis.
+func GetRedisInstance(ctx context.Context, name string, cfg RedisConfig) (redis.UniversalClient, error) {
+	return getOrCreateRedisInstance(name, func() (redis.UniversalClient, error) {
+		return NewRedisUniversalClient(ctx, cfg)
+	})
+}
+
+// CloseRedisInstances closes all the named redis clients. It should be called on shutdown.
+func CloseRedisInstances() (err error) {
+	redisInstancesMu.Lock()
+	defer redisInstancesMu.Unlock()
+	for name, client := range redisInstances {
+		if closeErr := client.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("error closing redis instance %s: %w", name, closeErr))
+		}
+		delete(redisInstances, name)
+	}
+	return
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+var redisInstances = make(map[string]redis.UniversalClient)
+var redisInstancesMu sync.Mutex
+
+// redisInstanceGroup de-duplicates the concurrent creations of an instance, outside of redisInstancesMu so that
+// a slow or unreachable instance doesn't block the lookups of the others.
+var redisInstanceGroup singleflight.Group
+
+// getOrCreateRedisInstance returns the client registered with name, or creates and registers it.
+// Nothing is registered if create fails, so that the next call tries again.
+func getOrCreateRedisInstance(name string, create func() (redis.UniversalClient, error)) (redis.UniversalClient, error) {
+	if client, found := lookupRedisInstance(name); found {
+		return client, nil
+	}
+	client, err, _ := redisInstanceGroup.Do(name, func() (interface{}, error) {
+		if client, found := lookupRedisInstance(name); found {
+			return client, nil
+		}
+		client, err := create()
+		if err != nil {
+			return nil, fmt.Errorf("error while creating redis instance %s: %w", name, err)
+		}
+		redisInstancesMu.Lock()
+		redisInstances[name] = client
+		redisInstancesMu.Unlock()
+		return client, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return client.(redis.UniversalClient), nil
+}
+
+func lookupRedisInstance(name string) (redis.UniversalClient, bool) {
+	redisInstancesMu.Lock()
+	defer redisInstancesMu.Unlock()
+	client, found := redisInstances[name]
+	return client, found
+}
+
+func newRedisClusterClient(cfg RedisConfig) (*redis.ClusterClient, error) {
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	return redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:          cfg.Addrs,
+		Username:       cfg.Username,
+		Password:       cfg.Password,
+		TLSConfig:      tlsConfig,
+		PoolSize:       cfg.PoolSize,
+		MinIdleConns:   cfg.MinIdleConns,
+		DialTimeout:    cfg.DialTimeout,
+		ReadTimeout:    cfg.ReadTimeout,
+		WriteTimeout:   cfg.WriteTimeout,
+		PoolTimeout:    cfg.PoolTimeout,
+		ReadOnly:       cfg.ReadOnly,
+		RouteByLatency: cfg.RouteByLatency,
+		RouteRandomly:  cfg.RouteRandomly,
+	}), nil
+}
+
+// tlsConfig builds the TLS config of the connection, or returns nil if TLS is disabled.
+func (cfg RedisConfig) tlsConfig() (*tls.Config, error) {
+	if !cfg.TLS {
+		return nil, nil
+	}
+	return newTLSConfig("redis", cfg.TLSServerName, cfg.TLSInsecureSkipVerify, cfg.TLSCACert, cfg.TLSCAFile)
+}
+
+func pingRedis(ctx context.Context, client redis.UniversalClient) error {
+	pong, err := client.Ping(ctx).Result()
+	if err != nil {
+		return fmt.Errorf("error while connecting to redis: %w", err)
+	}
+	if pong != Pong {
+		return fmt.Errorf("error while connecting to redis: unexpected ping reply %s", pong)
+	}
+	return nil
+}
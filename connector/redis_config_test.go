@@ -0,0 +1,114 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestLoadRedisConfig(t *testing.T) {
+	t.Setenv("CARDS_REDIS_MODE", "Sentinel")
+	t.Setenv("CARDS_REDIS_URL", "sentinel-1:26379, sentinel-2:26379,")
+	t.Setenv("CARDS_REDIS_MASTER_NAME", "cards")
+	t.Setenv("CARDS_REDIS_PASSWORD", "secret")
+	t.Setenv("CARDS_REDIS_DB", "2")
+	t.Setenv("CARDS_REDIS_TLS", "true")
+	t.Setenv("CARDS_REDIS_POOL_SIZE", "20")
+	t.Setenv("CARDS_REDIS_DIAL_TIMEOUT", "500ms")
+
+	cfg, err := LoadRedisConfig("CARDS_REDIS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := RedisConfig{
+		Mode:        RedisModeSentinel,
+		Addrs:       []string{"sentinel-1:26379", "sentinel-2:26379"},
+		MasterName:  "cards",
+		Password:    "secret",
+		DB:          2,
+		TLS:         true,
+		PoolSize:    20,
+		DialTimeout: 500 * time.Millisecond,
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("got %+v, want %+v", cfg, want)
+	}
+
+	t.Setenv("CARDS_REDIS_POOL_SIZE", "many")
+	t.Setenv("CARDS_REDIS_DIAL_TIMEOUT", "5")
+	if _, err = LoadRedisConfig("CARDS_REDIS"); err == nil ||
+		!strings.Contains(err.Error(), "CARDS_REDIS_POOL_SIZE") || !strings.Contains(err.Error(), "CARDS_REDIS_DIAL_TIMEOUT") {
+		t.Errorf("got %v, want the invalid settings", err)
+	}
+}
+
+func TestRedisConfigValidate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		cfg   RedisConfig
+		valid bool
+	}{
+		"cluster":            {RedisConfig{Mode: RedisModeCluster, Addrs: []string{"a:6379"}}, true},
+		"no address":         {RedisConfig{Mode: RedisModeStandalone}, false},
+		"sentinel":           {RedisConfig{Mode: RedisModeSentinel, Addrs: []string{"a:26379"}, MasterName: "m"}, true},
+		"sentinel no master": {RedisConfig{Mode: RedisModeSentinel, Addrs: []string{"a:26379"}}, false},
+		"unknown mode":       {RedisConfig{Mode: "ring", Addrs: []string{"a:6379"}}, false},
+	} {
+		if err := tc.cfg.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+}
+
+func TestGetOrCreateRedisInstanceDoesNotBlockOthers(t *testing.T) {
+	t.Cleanup(func() { CloseRedisInstances() })
+	slow := make(chan struct{})
+	slowDone := make(chan struct{})
+	go func() {
+		defer close(slowDone)
+		getOrCreateRedisInstance("test-slow", func() (redis.UniversalClient, error) {
+			<-slow
+			return nil, errors.New("unreachable")
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	var creates int32
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			getOrCreateRedisInstance("test-fast", func() (redis.UniversalClient, error) {
+				atomic.AddInt32(&creates, 1)
+				return redis.NewClient(&redis.Options{Addr: "localhost:0"}), nil
+			})
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("creating an instance blocked on another one")
+	}
+	close(slow)
+	<-slowDone
+	if creates != 1 {
+		t.Errorf("instance created %d times", creates)
+	}
+	if _, err := getOrCreateRedisInstance("test-slow", func() (redis.UniversalClient, error) {
+		return nil, context.DeadlineExceeded
+	}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("failed instance registered: %v", err)
+	}
+}
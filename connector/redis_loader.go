@@ -0,0 +1,205 @@
+package connector
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/happay/cms-utils-go/v3/logger"
+	"golang.org/x/sync/singleflight"
+)
+
+// ============ Constants =============
+
+const (
+	// DefaultLoadTTLJitter is the default fraction by which the TTL of a loaded value is randomly shortened or extended.
+	DefaultLoadTTLJitter = 0.1
+
+	loadLockSuffix   = ":load-lock"
+	loadPollInterval = 50 * time.Millisecond
+)
+
+// negativeCacheMarker is stored in place of the value when the loader reports ErrLoadNotFound.
+var negativeCacheMarker = []byte("\x00cms-utils:not-found")
+
+// ErrLoadNotFound should be returned by a loader when the value doesn't exist in the source.
+// GetOrLoad returns it as is, and caches the absence when WithNegativeTTL is set.
+var ErrLoadNotFound = errors.New("value not found")
+
+// loadGroup de-duplicates concurrent loads of the same key within the process.
+var loadGroup singleflight.Group
+
+// LoadOption configures the optional settings of GetOrLoad.
+type LoadOption func(*loadOptions)
+
+type loadOptions struct {
+	store       *RedisStore
+	jitter      float64
+	negativeTTL time.Duration
+	lockTTL     time.Duration
+	lockWait    time.Duration
+}
+
+// WithLoadStore sets the store used by GetOrLoad. The default is DefaultRedisStore().
+func WithLoadStore(store *RedisStore) LoadOption {
+	return func(o *loadOptions) {
+		o.store = store
+	}
+}
+
+// WithTTLJitter sets the fraction (between 0 and 1) by which the TTL is randomly shortened or extended,
+// so that keys loaded together don't expire together. Use 0 to disable the jitter.
+func WithTTLJitter(jitter float64) LoadOption {
+	return func(o *loadOptions) {
+		o.jitter = jitter
+	}
+}
+
+// WithNegativeTTL caches the absence of a value for the given duration when the loader returns ErrLoadNotFound.
+func WithNegativeTTL(ttl time.Duration) LoadOption {
+	return func(o *loadOptions) {
+		o.negativeTTL = ttl
+	}
+}
+
+// WithDistributedLock makes GetOrLoad take a redis lock before calling the loader, so that only one pod loads the key.
+// The other pods wait up to wait for the value to be cached, and call the loader themselves after that.
+// The lock expires after lockTTL, in case the pod holding it dies. If the lock can't be taken because of a redis
+// error, GetOrLoad returns the error instead of calling the loader without the lock.
+func WithDistributedLock(lockTTL, wait time.Duration) LoadOption {
+	return func(o *loadOptions) {
+		o.lockTTL = lockTTL
+		o.lockWait = wait
+	}
+}
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// GetOrLoad returns the cached value of key. On a cache miss it calls the loader, caches the result for ttl and returns it.
+// Concurrent misses of the same key on the same store in the process share a single loader call. The shared call
+// keeps running when a caller gives up on its context, so that the other callers still get the value.
+func GetOrLoad[T any](ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) (T, error), opts ...LoadOption) (result T, err error) {
+	o := &loadOptions{jitter: DefaultLoadTTLJitter}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.store == nil {
+		if o.store, err = DefaultRedisStore(); err != nil {
+			return
+		}
+	}
+
+	if result, err = getCached[T](ctx, o.store, key); err == nil || errors.Is(err, ErrLoadNotFound) {
+		return
+	}
+	// any other error (miss, unreachable redis, stale encoding) falls back to the loader
+
+	// the type is part of the group key, so that a key loaded as two types doesn't share a call
+	groupKey := fmt.Sprintf("%p|%T|%s", o.store, result, key)
+	loaded := loadGroup.DoChan(groupKey, func() (interface{}, error) {
+		return loadAndCache(context.WithoutCancel(ctx), o, key, ttl, loader)
+	})
+	select {
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
+	case res := <-loaded:
+		if err = res.Err; err != nil {
+			return
+		}
+		return res.Val.(T), nil
+	}
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+// getCached reads the key from the store, and converts a negative cache entry into ErrLoadNotFound.
+func getCached[T any](ctx context.Context, store *RedisStore, key string) (result T, err error) {
+	data, err := store.GetBytes(ctx, key)
+	if err != nil {
+		return
+	}
+	if bytes.Equal(data, negativeCacheMarker) {
+		err = ErrLoadNotFound
+		return
+	}
+	if err = store.codec.Unmarshal(data, &result); err != nil {
+		err = fmt.Errorf("%w: key %s: %w", ErrRedisDecode, key, err)
+	}
+	return
+}
+
+func loadAndCache[T any](ctx context.Context, o *loadOptions, key string, ttl time.Duration, loader func(ctx context.Context) (T, error)) (result T, err error) {
+	if o.lockTTL > 0 {
+		var release func()
+		if release, result, err = acquireLoadLock[T](ctx, o, key); release == nil {
+			// another pod loaded the value while we were waiting
+			if !errors.Is(err, ErrRedisKeyNotFound) {
+				return
+			}
+		} else {
+			defer release()
+		}
+	}
+
+	result, err = loader(ctx)
+	if errors.Is(err, ErrLoadNotFound) {
+		if o.negativeTTL > 0 {
+			_ = o.store.Set(ctx, key, negativeCacheMarker, jitterTTL(o.negativeTTL, o.jitter))
+		}
+		return
+	}
+	if err != nil {
+		return
+	}
+	if cacheErr := setWithCodec(ctx, o.store, o.store.codec, key, result, jitterTTL(ttl, o.jitter)); cacheErr != nil {
+		logger.GetLoggerV3().Warn(fmt.Sprintf("GetOrLoad | failed to cache key %s: %s", key, cacheErr))
+	}
+	return
+}
+
+// acquireLoadLock tries to take the load lock of the key. If the lock is held by another pod, it waits for
+// the value to be cached. It returns a release func only if the lock was taken. If neither the lock nor the value
+// could be obtained in time, it returns ErrRedisKeyNotFound so that the caller loads the value itself.
+// Any other redis error is returned, rather than loading the value without the lock.
+func acquireLoadLock[T any](ctx context.Context, o *loadOptions, key string) (release func(), result T, err error) {
+	locker := NewRedisLocker(o.store.client)
+	deadline := time.Now().Add(o.lockWait)
+	for {
+		lock, lockErr := locker.TryObtain(ctx, key+loadLockSuffix, o.lockTTL)
+		if lockErr == nil {
+			release = func() {
+				_ = lock.Release(context.Background())
+			}
+			return
+		}
+		if !errors.Is(lockErr, ErrLockNotAcquired) {
+			err = fmt.Errorf("GetOrLoad | error while taking the load lock of key %s: %w", key, lockErr)
+			return
+		}
+		if result, err = getCached[T](ctx, o.store, key); !errors.Is(err, ErrRedisKeyNotFound) {
+			return
+		}
+		if time.Now().After(deadline) {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case <-time.After(loadPollInterval):
+		}
+	}
+}
+
+// jitterTTL randomly shortens or extends the ttl by up to jitter times the ttl.
+func jitterTTL(ttl time.Duration, jitter float64) time.Duration {
+	if ttl <= 0 || jitter <= 0 {
+		return ttl
+	}
+	delta := time.Duration((rand.Float64()*2 - 1) * jitter * float64(ttl))
+	return ttl + delta
+}
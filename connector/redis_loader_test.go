@@ -0,0 +1,138 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetOrLoadSharesConcurrentLoads(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	ctx := context.Background()
+	var calls int32
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "gold", nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]string, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = GetOrLoad(ctx, "card:1", time.Hour, loader, WithLoadStore(store), WithDistributedLock(time.Second, time.Second))
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("loader called %d times, want 1", calls)
+	}
+	for _, result := range results {
+		if result != "gold" {
+			t.Fatalf("got %q", result)
+		}
+	}
+	if raw, _ := mr.Get("card:1"); raw != `"gold"` {
+		t.Errorf("cached %s", raw)
+	}
+	if mr.Exists("card:1" + loadLockSuffix) {
+		t.Error("load lock not released")
+	}
+}
+
+func TestGetOrLoadSeparatesStoresAndCallers(t *testing.T) {
+	first, _ := newTestRedisStore(t)
+	second, _ := newTestRedisStore(t)
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	loader := func(value string) func(context.Context) (string, error) {
+		return func(context.Context) (string, error) {
+			started <- struct{}{}
+			<-release
+			return value, nil
+		}
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := GetOrLoad(cancelled, "card:1", time.Hour, loader("first"), WithLoadStore(first))
+		errs <- err
+	}()
+	// the other callers only start once the load of the cancelled one runs
+	<-started
+	var firstResult, secondResult string
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		firstResult, _ = GetOrLoad(context.Background(), "card:1", time.Hour, loader("unused"), WithLoadStore(first))
+	}()
+	go func() {
+		defer wg.Done()
+		secondResult, _ = GetOrLoad(context.Background(), "card:1", time.Hour, loader("second"), WithLoadStore(second))
+	}()
+	<-started
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled caller: got %v", err)
+	}
+	close(release)
+	wg.Wait()
+
+	if firstResult != "first" || secondResult != "second" {
+		t.Errorf("got %q and %q, want the value of each store", firstResult, secondResult)
+	}
+}
+
+func TestGetOrLoadNegativeCacheAndJitter(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	ctx := context.Background()
+	var calls int
+	loader := func(ctx context.Context) (string, error) {
+		calls++
+		return "", ErrLoadNotFound
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := GetOrLoad(ctx, "card:404", time.Hour, loader, WithLoadStore(store), WithNegativeTTL(time.Minute)); !errors.Is(err, ErrLoadNotFound) {
+			t.Fatalf("got %v, want ErrLoadNotFound", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("loader called %d times, want 1 with the negative cache", calls)
+	}
+	if ttl := mr.TTL("card:404"); ttl < 54*time.Second || ttl > 66*time.Second {
+		t.Errorf("negative ttl %s not within the default jitter", ttl)
+	}
+
+	if _, err := GetOrLoad(ctx, "card:2", time.Hour, func(context.Context) (int, error) { return 2, nil },
+		WithLoadStore(store), WithTTLJitter(0.5)); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL("card:2"); ttl < 30*time.Minute || ttl > 90*time.Minute {
+		t.Errorf("ttl %s not within the jitter", ttl)
+	}
+}
+
+func TestGetOrLoadFailsWithoutLock(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	mr.Close()
+	var calls int
+	_, err := GetOrLoad(context.Background(), "card:1", time.Hour, func(context.Context) (string, error) {
+		calls++
+		return "gold", nil
+	}, WithLoadStore(store), WithDistributedLock(time.Second, time.Second))
+	if err == nil || calls != 0 {
+		t.Errorf("got %v after %d loads, want the lock error and no load", err, calls)
+	}
+}
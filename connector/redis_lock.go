@@ -0,0 +1,282 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/happay/cms-utils-go/v3/logger"
+	"github.com/redis/go-redis/v9"
+)
+
+// ============ Constants =============
+
+const (
+	DefaultLockRetryMin = 10 * time.Millisecond
+	DefaultLockRetryMax = 500 * time.Millisecond
+
+	// minLockRetryBackoff is the floor of the backoff, a zero backoff never growing
+	minLockRetryBackoff = time.Millisecond
+	// minLockRenewInterval is the floor of the renewal interval, as a ticker can't tick every 0s
+	minLockRenewInterval = time.Millisecond
+)
+
+// ============ Errors =============
+
+var (
+	// ErrLockNotAcquired is returned when the lock is held by someone else.
+	ErrLockNotAcquired = errors.New("redis lock not acquired")
+
+	// ErrLockNotHeld is returned when the lock has expired or has been taken over by someone else.
+	ErrLockNotHeld = errors.New("redis lock not held")
+
+	// ErrInvalidLockTTL is returned when a lock is acquired with a ttl which isn't positive,
+	// as such a lock would never expire.
+	ErrInvalidLockTTL = errors.New("redis lock ttl must be positive")
+)
+
+// releaseLockScript deletes the key only if it still holds the token set by the caller.
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// refreshLockScript extends the expiration of the key only if it still holds the token set by the caller.
+var refreshLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// RedisLocker creates distributed locks on a redis client. A lock is a key holding a random token,
+// so that only the owner of the lock can refresh or release it.
+type RedisLocker struct {
+	client redis.UniversalClient
+}
+
+// NewRedisLocker returns a RedisLocker backed by the given redis client.
+func NewRedisLocker(client redis.UniversalClient) *RedisLocker {
+	return &RedisLocker{client: client}
+}
+
+// DefaultRedisLocker returns a RedisLocker backed by the redis cluster client created by GetRedisConnectionWithAuth,
+// or ErrRedisUnavailable if the client couldn't be created.
+func DefaultRedisLocker() (*RedisLocker, error) {
+	client := GetRedisConnectionWithAuth(context.Background())
+	if client == nil {
+		return nil, ErrRedisUnavailable
+	}
+	return NewRedisLocker(client), nil
+}
+
+// LockOption configures the optional settings of RedisLocker.Obtain.
+type LockOption func(*lockOptions)
+
+type lockOptions struct {
+	retryMin   time.Duration
+	retryMax   time.Duration
+	autoRenew  bool
+	renewEvery time.Duration
+}
+
+// WithLockRetryBackoff sets the bounds of the exponential backoff between two acquire attempts.
+// A min below a millisecond is raised to a millisecond, and a max below min is raised to min.
+func WithLockRetryBackoff(min, max time.Duration) LockOption {
+	return func(o *lockOptions) {
+		o.retryMin = min
+		o.retryMax = max
+	}
+}
+
+// WithLockAutoRenew keeps extending the lock in a background goroutine until it is released.
+// The lock is extended every third of its ttl, unless a renewal interval is given, and at most every millisecond.
+func WithLockAutoRenew(every ...time.Duration) LockOption {
+	return func(o *lockOptions) {
+		o.autoRenew = true
+		if len(every) != 0 {
+			o.renewEvery = every[0]
+		}
+	}
+}
+
+// Obtain acquires the lock on key for ttl. If the lock is held by someone else, it retries with an exponential
+// backoff until the context is done, in which case it returns ErrLockNotAcquired.
+func (l *RedisLocker) Obtain(ctx context.Context, key string, ttl time.Duration, opts ...LockOption) (*RedisLock, error) {
+	o := &lockOptions{
+		retryMin: DefaultLockRetryMin,
+		retryMax: DefaultLockRetryMax,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.retryMin < minLockRetryBackoff {
+		o.retryMin = minLockRetryBackoff
+	}
+	if o.retryMax < o.retryMin {
+		o.retryMax = o.retryMin
+	}
+
+	backoff := o.retryMin
+	for {
+		lock, err := l.TryObtain(ctx, key, ttl)
+		if err == nil {
+			if o.autoRenew {
+				lock.startRenewal(o.renewEvery)
+			}
+			return lock, nil
+		}
+		if ctx.Err() != nil {
+			// the deadline can expire during the attempt itself
+			return nil, fmt.Errorf("%w: %s: %s", ErrLockNotAcquired, key, ctx.Err())
+		}
+		if !errors.Is(err, ErrLockNotAcquired) {
+			return nil, err
+		}
+
+		// full jitter, so that the waiting pods don't retry in lockstep
+		wait := time.Duration(rand.Int63n(int64(backoff) + 1))
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %s: %s", ErrLockNotAcquired, key, ctx.Err())
+		case <-time.After(wait):
+		}
+		if backoff *= 2; backoff > o.retryMax {
+			backoff = o.retryMax
+		}
+	}
+}
+
+// TryObtain makes a single attempt to acquire the lock on key for ttl.
+// It returns ErrLockNotAcquired if the lock is held by someone else, and ErrInvalidLockTTL if ttl isn't positive.
+func (l *RedisLocker) TryObtain(ctx context.Context, key string, ttl time.Duration) (*RedisLock, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("%w: %s: %s", ErrInvalidLockTTL, key, ttl)
+	}
+	token := uuid.NewString()
+	acquired, err := l.client.SetNX(ctx, key, token, ttl).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error while acquiring redis lock %s: %w", key, err)
+	}
+	if !acquired {
+		return nil, fmt.Errorf("%w: %s", ErrLockNotAcquired, key)
+	}
+	return &RedisLock{
+		client: l.client,
+		key:    key,
+		token:  token,
+		ttl:    ttl,
+		lost:   make(chan struct{}),
+	}, nil
+}
+
+// RedisLock is a lock acquired by RedisLocker.
+type RedisLock struct {
+	client redis.UniversalClient
+	key    string
+	token  string
+	ttl    time.Duration
+
+	stopRenewal context.CancelFunc
+	renewalDone chan struct{}
+	lost        chan struct{}
+}
+
+// Key returns the redis key of the lock.
+func (lk *RedisLock) Key() string {
+	return lk.key
+}
+
+// Token returns the random token identifying the owner of the lock.
+func (lk *RedisLock) Token() string {
+	return lk.token
+}
+
+// Lost returns a channel which is closed when the automatic renewal finds that the lock is no longer held.
+func (lk *RedisLock) Lost() <-chan struct{} {
+	return lk.lost
+}
+
+// TTL returns the remaining time to live of the lock. It returns ErrLockNotHeld if the lock is no longer held.
+func (lk *RedisLock) TTL(ctx context.Context) (time.Duration, error) {
+	token, err := lk.client.Get(ctx, lk.key).Result()
+	if errors.Is(err, redis.Nil) || (err == nil && token != lk.token) {
+		return 0, fmt.Errorf("%w: %s", ErrLockNotHeld, lk.key)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return lk.client.PTTL(ctx, lk.key).Result()
+}
+
+// Refresh extends the lock to ttl from now. It returns ErrLockNotHeld if the lock is no longer held.
+func (lk *RedisLock) Refresh(ctx context.Context, ttl time.Duration) error {
+	refreshed, err := refreshLockScript.Run(ctx, lk.client, []string{lk.key}, lk.token, ttl.Milliseconds()).Int()
+	if err != nil {
+		return fmt.Errorf("error while refreshing redis lock %s: %w", lk.key, err)
+	}
+	if refreshed == 0 {
+		return fmt.Errorf("%w: %s", ErrLockNotHeld, lk.key)
+	}
+	return nil
+}
+
+// Release stops the automatic renewal and releases the lock.
+// It returns ErrLockNotHeld if the lock had already expired or been taken over.
+func (lk *RedisLock) Release(ctx context.Context) error {
+	if lk.stopRenewal != nil {
+		lk.stopRenewal()
+		<-lk.renewalDone
+	}
+	released, err := releaseLockScript.Run(ctx, lk.client, []string{lk.key}, lk.token).Int()
+	if err != nil {
+		return fmt.Errorf("error while releasing redis lock %s: %w", lk.key, err)
+	}
+	if released == 0 {
+		return fmt.Errorf("%w: %s", ErrLockNotHeld, lk.key)
+	}
+	return nil
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+// startRenewal refreshes the lock periodically until Release is called or the lock is lost.
+func (lk *RedisLock) startRenewal(every time.Duration) {
+	if every <= 0 {
+		every = lk.ttl / 3
+	}
+	if every < minLockRenewInterval {
+		every = minLockRenewInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	lk.stopRenewal = cancel
+	lk.renewalDone = make(chan struct{})
+
+	go func() {
+		defer close(lk.renewalDone)
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := lk.Refresh(ctx, lk.ttl)
+				if errors.Is(err, ErrLockNotHeld) {
+					logger.GetLoggerV3().Error(err.Error())
+					close(lk.lost)
+					return
+				}
+				if err != nil && ctx.Err() == nil {
+					// transient failure, the next tick tries again before the lock expires
+					logger.GetLoggerV3().Warn(err.Error())
+				}
+			}
+		}
+	}()
+}
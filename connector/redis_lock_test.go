@@ -0,0 +1,110 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedisLocker(t *testing.T) (*RedisLocker, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRedisLocker(client), mr
+}
+
+func TestRedisLockObtainAndRelease(t *testing.T) {
+	locker, _ := newTestRedisLocker(t)
+	ctx := context.Background()
+
+	lock, err := locker.TryObtain(ctx, "settlement", time.Second)
+	if err != nil {
+		t.Fatalf("obtain: %s", err)
+	}
+	if _, err = locker.TryObtain(ctx, "settlement", time.Second); !errors.Is(err, ErrLockNotAcquired) {
+		t.Fatalf("second obtain: got %v, want ErrLockNotAcquired", err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err = locker.Obtain(waitCtx, "settlement", time.Second); !errors.Is(err, ErrLockNotAcquired) {
+		t.Fatalf("obtain with retry: got %v, want ErrLockNotAcquired", err)
+	}
+
+	if err = lock.Release(ctx); err != nil {
+		t.Fatalf("release: %s", err)
+	}
+	if err = lock.Release(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("second release: got %v, want ErrLockNotHeld", err)
+	}
+	if _, err = locker.TryObtain(ctx, "settlement", time.Second); err != nil {
+		t.Fatalf("obtain after release: %s", err)
+	}
+}
+
+func TestRedisLockExpiredIsNotHeld(t *testing.T) {
+	locker, mr := newTestRedisLocker(t)
+	ctx := context.Background()
+
+	lock, err := locker.TryObtain(ctx, "settlement", time.Second)
+	if err != nil {
+		t.Fatalf("obtain: %s", err)
+	}
+	mr.FastForward(2 * time.Second)
+	other, err := locker.TryObtain(ctx, "settlement", time.Second)
+	if err != nil {
+		t.Fatalf("obtain expired lock: %s", err)
+	}
+	if err = lock.Refresh(ctx, time.Second); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("refresh: got %v, want ErrLockNotHeld", err)
+	}
+	if err = lock.Release(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("release: got %v, want ErrLockNotHeld", err)
+	}
+	if err = other.Release(ctx); err != nil {
+		t.Fatalf("release by new owner: %s", err)
+	}
+}
+
+func TestRedisLockZeroBackoff(t *testing.T) {
+	locker, mr := newTestRedisLocker(t)
+	ctx := context.Background()
+	if _, err := locker.TryObtain(ctx, "settlement", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	before := mr.CommandCount()
+	if _, err := locker.Obtain(waitCtx, "settlement", time.Second, WithLockRetryBackoff(0, 20*time.Millisecond)); !errors.Is(err, ErrLockNotAcquired) {
+		t.Fatalf("got %v, want ErrLockNotAcquired", err)
+	}
+	// a busy loop would send thousands of commands
+	if attempts := mr.CommandCount() - before; attempts > 50 {
+		t.Errorf("%d attempts in 50ms", attempts)
+	}
+}
+
+func TestRedisLockInvalidTTL(t *testing.T) {
+	locker, _ := newTestRedisLocker(t)
+	ctx := context.Background()
+	for _, ttl := range []time.Duration{0, -time.Second} {
+		if _, err := locker.Obtain(ctx, "settlement", ttl, WithLockAutoRenew()); !errors.Is(err, ErrInvalidLockTTL) {
+			t.Errorf("ttl %s: got %v, want ErrInvalidLockTTL", ttl, err)
+		}
+	}
+
+	// a ttl under 3ns would renew every 0s
+	lock, err := locker.Obtain(ctx, "settlement", 2*time.Nanosecond, WithLockAutoRenew())
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err = lock.Release(ctx); err != nil && !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("release: %s", err)
+	}
+}
@@ -0,0 +1,169 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrRedisUnavailable is returned when the default redis client couldn't be created.
+var ErrRedisUnavailable = errors.New("redis client unavailable")
+
+// RedisStore provides context aware cache operations on top of any redis.UniversalClient
+// (standalone, cluster or sentinel). Callers should pass the request context (e.g. the gin
+// request context) so that deadlines and cancellations are propagated to redis.
+type RedisStore struct {
+	client redis.UniversalClient
+	codec  Codec
+}
+
+// RedisStoreOption configures the optional settings of a RedisStore.
+type RedisStoreOption func(*RedisStore)
+
+// WithCodec sets the codec used by SetObject and GetObject. The default codec is JSONCodec.
+func WithCodec(codec Codec) RedisStoreOption {
+	return func(s *RedisStore) {
+		s.codec = codec
+	}
+}
+
+// NewRedisStore returns a RedisStore backed by the given redis client.
+func NewRedisStore(client redis.UniversalClient, opts ...RedisStoreOption) *RedisStore {
+	s := &RedisStore{
+		client: client,
+		codec:  JSONCodec,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+var defaultRedisStore *RedisStore
+var defaultRedisStoreMu sync.Mutex
+
+// DefaultRedisStore returns the RedisStore backed by the redis cluster client created by GetRedisConnectionWithAuth,
+// or ErrRedisUnavailable if the client couldn't be created.
+func DefaultRedisStore() (*RedisStore, error) {
+	client := GetRedisConnectionWithAuth(context.Background())
+	if client == nil {
+		// a nil *redis.ClusterClient would be a non-nil redis.UniversalClient
+		return nil, ErrRedisUnavailable
+	}
+	defaultRedisStoreMu.Lock()
+	defer defaultRedisStoreMu.Unlock()
+	// the store is rebuilt when the client is recreated, e.g. after CloseRedisInstances
+	if defaultRedisStore == nil || defaultRedisStore.client != redis.UniversalClient(client) {
+		defaultRedisStore = NewRedisStore(client)
+	}
+	return defaultRedisStore, nil
+}
+
+// Client returns the underlying redis client.
+func (s *RedisStore) Client() redis.UniversalClient {
+	return s.client
+}
+
+// Get returns the string value stored at key. It returns ErrRedisKeyNotFound if the key doesn't exist.
+func (s *RedisStore) Get(ctx context.Context, key string) (string, error) {
+	result, err := s.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		err = fmt.Errorf("%w: %s", ErrRedisKeyNotFound, key)
+	}
+	return result, err
+}
+
+// GetBytes returns the value stored at key as bytes. It returns ErrRedisKeyNotFound if the key doesn't exist.
+func (s *RedisStore) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	result, err := s.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		err = fmt.Errorf("%w: %s", ErrRedisKeyNotFound, key)
+	}
+	return result, err
+}
+
+// Set stores value at key with the given expiration. A zero expiration means the key has no expiration.
+func (s *RedisStore) Set(ctx context.Context, key string, value interface{}, exp time.Duration) error {
+	return s.client.Set(ctx, key, value, exp).Err()
+}
+
+// SetNX stores value at key only if the key does not exist yet. It reports whether the key was set.
+func (s *RedisStore) SetNX(ctx context.Context, key string, value interface{}, exp time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, key, value, exp).Result()
+}
+
+// Exists reports whether the key exists.
+func (s *RedisStore) Exists(ctx context.Context, key string) (bool, error) {
+	count, err := s.client.Exists(ctx, key).Result()
+	return count == 1, err
+}
+
+// Delete removes the given keys. Keys that don't exist are ignored.
+func (s *RedisStore) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	// keys are deleted one by one in a pipeline, as a multi key DEL fails on a
+	// cluster when the keys belong to different hash slots
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, key)
+		}
+		return nil
+	})
+	return err
+}
+
+// TTL returns the remaining time to live of the key.
+// As in redis, it returns -1 if the key has no expiration and -2 if the key doesn't exist.
+func (s *RedisStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return s.client.TTL(ctx, key).Result()
+}
+
+// MGet returns the values of the given keys. Keys that don't exist are not present in the returned map.
+func (s *RedisStore) MGet(ctx context.Context, keys ...string) (map[string]string, error) {
+	result := make(map[string]string, len(keys))
+	if len(keys) == 0 {
+		return result, nil
+	}
+	// pipelined GETs are used instead of MGET, so that the keys can span multiple hash slots on a cluster
+	cmds := make([]*redis.StringCmd, len(keys))
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Get(ctx, key)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	for i, cmd := range cmds {
+		val, err := cmd.Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result[keys[i]] = val
+	}
+	return result, nil
+}
+
+// MSet stores all the given key/value pairs with the same expiration.
+func (s *RedisStore) MSet(ctx context.Context, values map[string]interface{}, exp time.Duration) error {
+	if len(values) == 0 {
+		return nil
+	}
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, value := range values {
+			pipe.Set(ctx, key, value, exp)
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,54 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedisStore(t *testing.T, opts ...RedisStoreOption) (*RedisStore, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRedisStore(client, opts...), mr
+}
+
+func TestRedisStore(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	ctx := context.Background()
+
+	if _, err := store.Get(ctx, "card:1"); !errors.Is(err, ErrRedisKeyNotFound) {
+		t.Fatalf("get missing key: got %v, want ErrRedisKeyNotFound", err)
+	}
+	if err := store.Set(ctx, "card:1", "active", time.Minute); err != nil {
+		t.Fatalf("set: %s", err)
+	}
+	if value, err := store.Get(ctx, "card:1"); err != nil || value != "active" {
+		t.Fatalf("get: got %q, %v", value, err)
+	}
+	if set, err := store.SetNX(ctx, "card:1", "blocked", time.Minute); err != nil || set {
+		t.Fatalf("setnx on existing key: got %t, %v", set, err)
+	}
+	if ttl, err := store.TTL(ctx, "card:1"); err != nil || ttl != time.Minute {
+		t.Fatalf("ttl: got %s, %v", ttl, err)
+	}
+
+	if err := store.MSet(ctx, map[string]interface{}{"card:2": "a", "card:3": "b"}, 0); err != nil {
+		t.Fatalf("mset: %s", err)
+	}
+	values, err := store.MGet(ctx, "card:1", "card:2", "card:4")
+	if err != nil || len(values) != 2 || values["card:2"] != "a" {
+		t.Fatalf("mget: got %v, %v", values, err)
+	}
+
+	if err = store.Delete(ctx, "card:1", "card:2"); err != nil {
+		t.Fatalf("delete: %s", err)
+	}
+	if exists, _ := store.Exists(ctx, "card:1"); exists || mr.Exists("card:2") || !mr.Exists("card:3") {
+		t.Error("delete removed the wrong keys")
+	}
+}
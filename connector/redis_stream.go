@@ -0,0 +1,306 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/happay/cms-utils-go/v3/logger"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ============ Constants =============
+
+const (
+	DefaultStreamBatchSize     = 10
+	DefaultStreamBlock         = 5 * time.Second
+	DefaultStreamMinIdle       = time.Minute
+	DefaultStreamMaxDeliveries = 5
+	DeadLetterStreamSuffix     = ":dead-letter"
+
+	// streamTraceFieldPrefix prefixes the message fields carrying the trace context.
+	streamTraceFieldPrefix = "trace-"
+	streamTracerName       = "github.com/happay/cms-utils-go/v3/connector"
+)
+
+// fields added to the messages moved to the dead letter stream
+const (
+	StreamFieldSourceStream = "dead-letter-stream"
+	StreamFieldSourceId     = "dead-letter-id"
+	StreamFieldDeliveries   = "dead-letter-deliveries"
+)
+
+// StreamMessage is a message read from a redis stream.
+type StreamMessage struct {
+	Id     string
+	Stream string
+	// Values are the fields of the message, without the trace context fields.
+	Values map[string]interface{}
+}
+
+// StreamHandler processes a message. The message is acknowledged when the handler returns nil,
+// and delivered again after the consumer MinIdle otherwise. The context carries the trace of the publisher.
+type StreamHandler func(ctx context.Context, msg StreamMessage) error
+
+// =========== Publisher ============
+
+// StreamPublisher appends messages to a redis stream.
+type StreamPublisher struct {
+	client redis.UniversalClient
+	stream string
+	maxLen int64
+}
+
+// NewStreamPublisher returns a publisher of the stream. If maxLen is positive, the stream is approximately
+// trimmed to that many messages.
+func NewStreamPublisher(client redis.UniversalClient, stream string, maxLen int64) *StreamPublisher {
+	return &StreamPublisher{
+		client: client,
+		stream: stream,
+		maxLen: maxLen,
+	}
+}
+
+// Publish appends the values to the stream with XADD, along with the trace context of ctx. It returns the message id.
+func (p *StreamPublisher) Publish(ctx context.Context, values map[string]interface{}) (string, error) {
+	fields := make(map[string]interface{}, len(values)+2)
+	for key, val := range values {
+		fields[key] = val
+	}
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	for key, val := range carrier {
+		fields[streamTraceFieldPrefix+key] = val
+	}
+	id, err := p.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: p.stream,
+		MaxLen: p.maxLen,
+		Approx: p.maxLen > 0,
+		Values: fields,
+	}).Result()
+	if err != nil {
+		return "", fmt.Errorf("error while publishing to stream %s: %w", p.stream, err)
+	}
+	return id, nil
+}
+
+// =========== Consumer ============
+
+// StreamConsumerConfig configures a consumer of a consumer group.
+type StreamConsumerConfig struct {
+	Stream   string
+	Group    string
+	Consumer string
+
+	// BatchSize is the maximum number of messages read at once. The default is DefaultStreamBatchSize.
+	BatchSize int64
+	// Block is how long a read waits for new messages. The default is DefaultStreamBlock.
+	Block time.Duration
+	// MinIdle is how long a message stays pending before it is reclaimed from a failed or dead consumer.
+	// The default is DefaultStreamMinIdle.
+	MinIdle time.Duration
+	// MaxDeliveries is the number of deliveries after which a pending message is moved to the dead letter stream.
+	// The default is DefaultStreamMaxDeliveries.
+	MaxDeliveries int64
+	// DeadLetterStream receives the messages delivered MaxDeliveries times. The default is Stream + DeadLetterStreamSuffix.
+	DeadLetterStream string
+}
+
+// StreamConsumer reads the messages of a stream as a member of a consumer group.
+type StreamConsumer struct {
+	client  redis.UniversalClient
+	cfg     StreamConsumerConfig
+	handler StreamHandler
+}
+
+// NewStreamConsumer returns a consumer which passes the messages of the group to the handler.
+func NewStreamConsumer(client redis.UniversalClient, cfg StreamConsumerConfig, handler StreamHandler) *StreamConsumer {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultStreamBatchSize
+	}
+	if cfg.Block <= 0 {
+		cfg.Block = DefaultStreamBlock
+	}
+	if cfg.MinIdle <= 0 {
+		cfg.MinIdle = DefaultStreamMinIdle
+	}
+	if cfg.MaxDeliveries <= 0 {
+		cfg.MaxDeliveries = DefaultStreamMaxDeliveries
+	}
+	if cfg.DeadLetterStream == "" {
+		cfg.DeadLetterStream = cfg.Stream + DeadLetterStreamSuffix
+	}
+	return &StreamConsumer{
+		client:  client,
+		cfg:     cfg,
+		handler: handler,
+	}
+}
+
+// Run creates the consumer group if needed and processes the messages until the context is done.
+// Each iteration first reclaims the messages left pending by failed deliveries, then reads new messages.
+func (sc *StreamConsumer) Run(ctx context.Context) error {
+	if err := sc.createGroup(ctx); err != nil {
+		return err
+	}
+	for ctx.Err() == nil {
+		if err := sc.reclaim(ctx); err != nil && ctx.Err() == nil {
+			logger.GetLoggerV3().Error(err.Error())
+		}
+		if err := sc.readNew(ctx); err != nil && ctx.Err() == nil {
+			logger.GetLoggerV3().Error(err.Error())
+			// avoid spinning while redis is unreachable
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}
+	return nil
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+func (sc *StreamConsumer) createGroup(ctx context.Context) error {
+	err := sc.client.XGroupCreateMkStream(ctx, sc.cfg.Stream, sc.cfg.Group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("error while creating consumer group %s on stream %s: %w", sc.cfg.Group, sc.cfg.Stream, err)
+	}
+	return nil
+}
+
+func (sc *StreamConsumer) readNew(ctx context.Context) error {
+	streams, err := sc.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    sc.cfg.Group,
+		Consumer: sc.cfg.Consumer,
+		Streams:  []string{sc.cfg.Stream, ">"},
+		Count:    sc.cfg.BatchSize,
+		Block:    sc.cfg.Block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error while reading stream %s: %w", sc.cfg.Stream, err)
+	}
+	for _, stream := range streams {
+		for _, msg := range stream.Messages {
+			sc.process(ctx, msg)
+		}
+	}
+	return nil
+}
+
+// reclaim claims the messages pending for longer than MinIdle, moves those already delivered MaxDeliveries times
+// to the dead letter stream, and processes the others.
+func (sc *StreamConsumer) reclaim(ctx context.Context) error {
+	messages, _, err := sc.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   sc.cfg.Stream,
+		Group:    sc.cfg.Group,
+		Consumer: sc.cfg.Consumer,
+		MinIdle:  sc.cfg.MinIdle,
+		Start:    "0-0",
+		Count:    sc.cfg.BatchSize,
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("error while claiming pending messages of stream %s: %w", sc.cfg.Stream, err)
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+
+	// the delivery counts of the claimed messages, including the claim itself
+	cmds := make([]*redis.XPendingExtCmd, len(messages))
+	_, err = sc.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, msg := range messages {
+			cmds[i] = pipe.XPendingExt(ctx, &redis.XPendingExtArgs{
+				Stream: sc.cfg.Stream,
+				Group:  sc.cfg.Group,
+				Start:  msg.ID,
+				End:    msg.ID,
+				Count:  1,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error while reading the deliveries of the pending messages of stream %s: %w", sc.cfg.Stream, err)
+	}
+	for i, msg := range messages {
+		if pending := cmds[i].Val(); len(pending) == 1 && pending[0].RetryCount > sc.cfg.MaxDeliveries {
+			if err = sc.deadLetter(ctx, msg, pending[0].RetryCount-1); err != nil {
+				return err
+			}
+			continue
+		}
+		sc.process(ctx, msg)
+	}
+	return nil
+}
+
+// deadLetter copies the message to the dead letter stream and acknowledges it on the source stream.
+func (sc *StreamConsumer) deadLetter(ctx context.Context, msg redis.XMessage, deliveries int64) error {
+	fields := make(map[string]interface{}, len(msg.Values)+3)
+	for key, val := range msg.Values {
+		fields[key] = val
+	}
+	fields[StreamFieldSourceStream] = sc.cfg.Stream
+	fields[StreamFieldSourceId] = msg.ID
+	fields[StreamFieldDeliveries] = deliveries
+	if err := sc.client.XAdd(ctx, &redis.XAddArgs{Stream: sc.cfg.DeadLetterStream, Values: fields}).Err(); err != nil {
+		return fmt.Errorf("error while moving message %s to stream %s: %w", msg.ID, sc.cfg.DeadLetterStream, err)
+	}
+	logger.GetLoggerV3().Warn(fmt.Sprintf("message %s of stream %s moved to %s after %d deliveries", msg.ID, sc.cfg.Stream, sc.cfg.DeadLetterStream, deliveries))
+	return sc.client.XAck(ctx, sc.cfg.Stream, sc.cfg.Group, msg.ID).Err()
+}
+
+// process runs the handler in a span continuing the trace of the publisher, and acknowledges the message on success.
+func (sc *StreamConsumer) process(ctx context.Context, msg redis.XMessage) {
+	values := make(map[string]interface{}, len(msg.Values))
+	carrier := propagation.MapCarrier{}
+	for key, val := range msg.Values {
+		if traceKey, found := strings.CutPrefix(key, streamTraceFieldPrefix); found {
+			carrier[traceKey] = fmt.Sprint(val)
+			continue
+		}
+		values[key] = val
+	}
+	msgCtx := otel.GetTextMapPropagator().Extract(ctx, carrier)
+	msgCtx, span := otel.Tracer(streamTracerName).Start(msgCtx, sc.cfg.Stream+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "redis"),
+			attribute.String("messaging.destination.name", sc.cfg.Stream),
+			attribute.String("messaging.consumer.group.name", sc.cfg.Group),
+			attribute.String("messaging.message.id", msg.ID),
+		),
+	)
+	defer span.End()
+
+	if err := sc.handle(msgCtx, StreamMessage{Id: msg.ID, Stream: sc.cfg.Stream, Values: values}); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		logger.GetLoggerV3().ErrorContext(msgCtx, fmt.Sprintf("error while handling message %s of stream %s: %s", msg.ID, sc.cfg.Stream, err))
+		return
+	}
+	if err := sc.client.XAck(ctx, sc.cfg.Stream, sc.cfg.Group, msg.ID).Err(); err != nil {
+		logger.GetLoggerV3().ErrorContext(msgCtx, fmt.Sprintf("error while acknowledging message %s of stream %s: %s", msg.ID, sc.cfg.Stream, err))
+	}
+}
+
+// handle calls the handler, converting a panic into an error so that the message is retried.
+func (sc *StreamConsumer) handle(ctx context.Context, msg StreamMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return sc.handler(ctx, msg)
+}
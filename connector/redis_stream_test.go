@@ -0,0 +1,157 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+var streamTestTime = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newTestStreamConsumer(t *testing.T, cfg StreamConsumerConfig, handler StreamHandler) (*StreamConsumer, *StreamPublisher, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	mr.SetTime(streamTestTime)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	cfg.Stream, cfg.Group, cfg.Consumer = "cards", "settlement", "worker-1"
+	cfg.Block = 10 * time.Millisecond
+	sc := NewStreamConsumer(client, cfg, handler)
+	if err := sc.createGroup(context.Background()); err != nil {
+		t.Fatalf("create group: %s", err)
+	}
+	return sc, NewStreamPublisher(client, cfg.Stream, 0), mr
+}
+
+func pendingCount(t *testing.T, sc *StreamConsumer) int64 {
+	t.Helper()
+	pending, err := sc.client.XPending(context.Background(), sc.cfg.Stream, sc.cfg.Group).Result()
+	if err != nil {
+		t.Fatalf("xpending: %s", err)
+	}
+	return pending.Count
+}
+
+func TestStreamConsumeAndAck(t *testing.T) {
+	ctx := context.Background()
+	var got []StreamMessage
+	sc, pub, _ := newTestStreamConsumer(t, StreamConsumerConfig{}, func(ctx context.Context, msg StreamMessage) error {
+		got = append(got, msg)
+		return nil
+	})
+
+	id, err := pub.Publish(ctx, map[string]interface{}{"card": "card-1"})
+	if err != nil {
+		t.Fatalf("publish: %s", err)
+	}
+	if err = sc.readNew(ctx); err != nil {
+		t.Fatalf("read: %s", err)
+	}
+	if len(got) != 1 || got[0].Id != id || got[0].Stream != "cards" || got[0].Values["card"] != "card-1" {
+		t.Fatalf("handled %+v", got)
+	}
+	if n := pendingCount(t, sc); n != 0 {
+		t.Errorf("%d messages still pending after ack", n)
+	}
+}
+
+func TestStreamReclaimAfterIdle(t *testing.T) {
+	ctx := context.Background()
+	deliveries := 0
+	sc, pub, mr := newTestStreamConsumer(t, StreamConsumerConfig{MinIdle: time.Minute}, func(ctx context.Context, msg StreamMessage) error {
+		deliveries++
+		if deliveries == 1 {
+			return errors.New("settlement service down")
+		}
+		return nil
+	})
+
+	if _, err := pub.Publish(ctx, map[string]interface{}{"card": "card-1"}); err != nil {
+		t.Fatalf("publish: %s", err)
+	}
+	if err := sc.readNew(ctx); err != nil {
+		t.Fatalf("read: %s", err)
+	}
+	if n := pendingCount(t, sc); n != 1 {
+		t.Fatalf("%d messages pending after a failed delivery, want 1", n)
+	}
+
+	// not idle for long enough yet
+	if err := sc.reclaim(ctx); err != nil {
+		t.Fatalf("reclaim: %s", err)
+	}
+	if deliveries != 1 {
+		t.Fatalf("message reclaimed before MinIdle: %d deliveries", deliveries)
+	}
+
+	mr.SetTime(streamTestTime.Add(2 * time.Minute))
+	if err := sc.reclaim(ctx); err != nil {
+		t.Fatalf("reclaim: %s", err)
+	}
+	if deliveries != 2 {
+		t.Fatalf("got %d deliveries, want 2", deliveries)
+	}
+	if n := pendingCount(t, sc); n != 0 {
+		t.Errorf("%d messages still pending after a successful redelivery", n)
+	}
+}
+
+func TestStreamDeadLetterAfterMaxDeliveries(t *testing.T) {
+	ctx := context.Background()
+	deliveries := map[string]int{}
+	// more failing messages than BatchSize, so that most of them are beyond the first page of the pending list
+	sc, pub, mr := newTestStreamConsumer(t, StreamConsumerConfig{BatchSize: 2, MinIdle: time.Minute, MaxDeliveries: 3},
+		func(ctx context.Context, msg StreamMessage) error {
+			deliveries[msg.Id]++
+			return errors.New("invalid card")
+		})
+
+	ids := map[string]bool{}
+	for i := 0; i < 5; i++ {
+		id, err := pub.Publish(ctx, map[string]interface{}{"n": i})
+		if err != nil {
+			t.Fatalf("publish: %s", err)
+		}
+		ids[id] = true
+	}
+	now := streamTestTime
+	for i := 0; i < 20; i++ {
+		if err := sc.reclaim(ctx); err != nil {
+			t.Fatalf("reclaim: %s", err)
+		}
+		if err := sc.readNew(ctx); err != nil {
+			t.Fatalf("read: %s", err)
+		}
+		if pendingCount(t, sc) == 0 {
+			break
+		}
+		now = now.Add(2 * time.Minute)
+		mr.SetTime(now)
+	}
+
+	if n := pendingCount(t, sc); n != 0 {
+		t.Fatalf("%d messages still pending", n)
+	}
+	for id := range ids {
+		if deliveries[id] != 3 {
+			t.Errorf("message %s delivered %d times, want 3", id, deliveries[id])
+		}
+	}
+	dead, err := sc.client.XRange(ctx, "cards"+DeadLetterStreamSuffix, "-", "+").Result()
+	if err != nil {
+		t.Fatalf("xrange: %s", err)
+	}
+	if len(dead) != len(ids) {
+		t.Fatalf("got %d dead letters, want %d", len(dead), len(ids))
+	}
+	for _, msg := range dead {
+		if msg.Values[StreamFieldSourceStream] != "cards" || !ids[msg.Values[StreamFieldSourceId].(string)] || msg.Values[StreamFieldDeliveries] != "3" {
+			t.Errorf("dead letter %+v", msg.Values)
+		}
+	}
+}
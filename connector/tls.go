@@ -0,0 +1,36 @@
+package connector
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+// newTLSConfig builds a TLS config trusting the PEM certificates of caCert and of the caFile file,
+// or the system certificates if both are empty. service names the server in the errors.
+func newTLSConfig(service, serverName string, insecureSkipVerify bool, caCert, caFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         serverName,
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+	caBundle := []byte(caCert)
+	if caFile != "" {
+		fileCert, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading %s CA file %s: %w", service, caFile, err)
+		}
+		caBundle = append(caBundle, fileCert...)
+	}
+	if len(caBundle) != 0 {
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("invalid %s CA bundle: no PEM certificate found", service)
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+	return tlsConfig, nil
+}
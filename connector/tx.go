@@ -0,0 +1,130 @@
+package connector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/happay/cms-utils-go/v3/logger"
+	"gorm.io/gorm"
+)
+
+// ============ Constants =============
+
+const (
+	DefaultTxMaxRetries = 3
+	DefaultTxBackoffMin = 10 * time.Millisecond
+	DefaultTxBackoffMax = time.Second
+)
+
+// retryable error codes
+const (
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+	mysqlDeadlock          = 1213
+)
+
+// TxOptions configures WithTx. The zero value runs a read-write transaction at the default isolation level
+// of the database, retried DefaultTxMaxRetries times.
+type TxOptions struct {
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+	// MaxRetries is the number of times a transaction failing with a retryable error is run again.
+	// The default is DefaultTxMaxRetries, a negative value disables the retries.
+	MaxRetries int
+	// BackoffMin and BackoffMax bound the exponential backoff between two runs.
+	// The defaults are DefaultTxBackoffMin and DefaultTxBackoffMax.
+	BackoffMin time.Duration
+	BackoffMax time.Duration
+}
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// WithTx runs fn in a transaction, committed if fn returns nil and rolled back otherwise, or if fn panics,
+// in which case the panic goes on once rolled back. opts may be nil.
+// A transaction failing on a serialization failure or a deadlock is run again from the start, with a backoff,
+// so fn must not have side effects outside of tx.
+//
+//	err := connector.WithTx(ctx, db, &connector.TxOptions{Isolation: sql.LevelSerializable}, func(tx *gorm.DB) error {
+//		return tx.Model(&card).Update("status", "blocked").Error
+//	})
+func WithTx(ctx context.Context, db *gorm.DB, opts *TxOptions, fn func(tx *gorm.DB) error) error {
+	o := TxOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = DefaultTxMaxRetries
+	}
+	if o.BackoffMin <= 0 {
+		o.BackoffMin = DefaultTxBackoffMin
+	}
+	if o.BackoffMax <= 0 {
+		o.BackoffMax = DefaultTxBackoffMax
+	}
+
+	backoff := o.BackoffMin
+	for attempt := 0; ; attempt++ {
+		err := runTx(ctx, db, o, fn)
+		if err == nil || !IsRetryableTxError(err) || attempt >= o.MaxRetries {
+			return err
+		}
+		logger.GetLoggerV3().WarnContext(ctx, fmt.Sprintf("WithTx | retrying transaction after: %s", err))
+
+		// full jitter, so that the conflicting transactions don't retry in lockstep
+		wait := time.Duration(rand.Int63n(int64(backoff) + 1))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", err, ctx.Err())
+		case <-time.After(wait):
+		}
+		if backoff *= 2; backoff > o.BackoffMax {
+			backoff = o.BackoffMax
+		}
+	}
+}
+
+// IsRetryableTxError tells whether err is a postgres serialization failure (40001) or deadlock (40P01),
+// or a mysql deadlock (1213), after which the transaction can be run again.
+func IsRetryableTxError(err error) bool {
+	// implemented by the errors of the pgx and lib/pq drivers
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		code := pgErr.SQLState()
+		return code == pgSerializationFailure || code == pgDeadlockDetected
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlDeadlock
+	}
+	return false
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+func runTx(ctx context.Context, db *gorm.DB, o TxOptions, fn func(tx *gorm.DB) error) (err error) {
+	tx := db.WithContext(ctx).Begin(&sql.TxOptions{Isolation: o.Isolation, ReadOnly: o.ReadOnly})
+	if tx.Error != nil {
+		return fmt.Errorf("error while beginning transaction: %w", tx.Error)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+	}()
+	if err = fn(tx); err != nil {
+		if rollbackErr := tx.Rollback().Error; rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			logger.GetLoggerV3().ErrorContext(ctx, fmt.Sprintf("WithTx | error while rolling back transaction: %s", rollbackErr))
+		}
+		return err
+	}
+	if err = tx.Commit().Error; err != nil {
+		return fmt.Errorf("error while committing transaction: %w", err)
+	}
+	return nil
+}
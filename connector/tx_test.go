@@ -0,0 +1,255 @@
+package connector
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+type sqlStateError string
+
+func (e sqlStateError) Error() string    { return "sql state " + string(e) }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+func TestIsRetryableTxError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{sqlStateError("40001"), true},
+		{fmt.Errorf("commit: %w", sqlStateError("40P01")), true},
+		{sqlStateError("23505"), false},
+		{&mysql.MySQLError{Number: 1213}, true},
+		{&mysql.MySQLError{Number: 1062}, false},
+		{errors.New("connection refused"), false},
+	}
+	for _, c := range cases {
+		if got := IsRetryableTxError(c.err); got != c.want {
+			t.Errorf("IsRetryableTxError(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+// fastTx retries without waiting.
+var fastTx = TxOptions{BackoffMin: time.Nanosecond, BackoffMax: time.Nanosecond}
+
+func TestWithTxRetries(t *testing.T) {
+	for _, retryable := range []error{sqlStateError("40001"), sqlStateError("40P01"), &mysql.MySQLError{Number: 1213}} {
+		db, conn := openFakeTxDB(t)
+		runs := 0
+		err := WithTx(context.Background(), db, &fastTx, func(tx *gorm.DB) error {
+			if runs++; runs < 3 {
+				return fmt.Errorf("update: %w", retryable)
+			}
+			return nil
+		})
+		if err != nil || runs != 3 {
+			t.Errorf("%v: got %v after %d runs, want the third run committed", retryable, err, runs)
+		}
+		if got := conn.counts(); got != (fakeTxCounts{begins: 3, commits: 1, rollbacks: 2}) {
+			t.Errorf("%v: got %+v", retryable, got)
+		}
+	}
+}
+
+func TestWithTxMaxRetries(t *testing.T) {
+	cases := []struct {
+		maxRetries int
+		runs       int
+	}{
+		{0, DefaultTxMaxRetries + 1},
+		{1, 2},
+		{-1, 1},
+	}
+	for _, c := range cases {
+		db, conn := openFakeTxDB(t)
+		opts := fastTx
+		opts.MaxRetries = c.maxRetries
+		runs := 0
+		err := WithTx(context.Background(), db, &opts, func(tx *gorm.DB) error {
+			runs++
+			return sqlStateError("40001")
+		})
+		if !IsRetryableTxError(err) || runs != c.runs {
+			t.Errorf("MaxRetries %d: got %v after %d runs, want %d runs", c.maxRetries, err, runs, c.runs)
+		}
+		if got := conn.counts(); got != (fakeTxCounts{begins: c.runs, rollbacks: c.runs}) {
+			t.Errorf("MaxRetries %d: got %+v", c.maxRetries, got)
+		}
+	}
+
+	// nil options
+	db, _ := openFakeTxDB(t)
+	runs := 0
+	WithTx(context.Background(), db, nil, func(tx *gorm.DB) error {
+		runs++
+		return sqlStateError("40001")
+	})
+	if runs != DefaultTxMaxRetries+1 {
+		t.Errorf("nil options: got %d runs, want %d", runs, DefaultTxMaxRetries+1)
+	}
+}
+
+func TestWithTxRetriesCommit(t *testing.T) {
+	db, conn := openFakeTxDB(t)
+	conn.commitErrs = []error{sqlStateError("40001")}
+	runs := 0
+	err := WithTx(context.Background(), db, &fastTx, func(tx *gorm.DB) error {
+		runs++
+		return nil
+	})
+	if err != nil || runs != 2 {
+		t.Errorf("got %v after %d runs, want the transaction run again after the failed commit", err, runs)
+	}
+}
+
+func TestWithTxRollsBack(t *testing.T) {
+	db, conn := openFakeTxDB(t)
+	failure := errors.New("card not found")
+	runs := 0
+	err := WithTx(context.Background(), db, &TxOptions{Isolation: sql.LevelSerializable, ReadOnly: true}, func(tx *gorm.DB) error {
+		runs++
+		return failure
+	})
+	if err != failure || runs != 1 {
+		t.Errorf("got %v after %d runs, want the error of fn without retry", err, runs)
+	}
+	if got := conn.counts(); got != (fakeTxCounts{begins: 1, rollbacks: 1}) {
+		t.Errorf("got %+v", got)
+	}
+	if got := conn.options(); got != (driver.TxOptions{Isolation: driver.IsolationLevel(sql.LevelSerializable), ReadOnly: true}) {
+		t.Errorf("began with %+v", got)
+	}
+}
+
+func TestWithTxRollsBackOnPanic(t *testing.T) {
+	db, conn := openFakeTxDB(t)
+	defer func() {
+		if r := recover(); r != "boom" {
+			t.Errorf("recovered %v, want the panic of fn", r)
+		}
+		if got := conn.counts(); got != (fakeTxCounts{begins: 1, rollbacks: 1}) {
+			t.Errorf("got %+v", got)
+		}
+	}()
+	WithTx(context.Background(), db, nil, func(tx *gorm.DB) error {
+		panic("boom")
+	})
+	t.Error("the panic of fn was swallowed")
+}
+
+func TestWithTxContextDoneDuringBackoff(t *testing.T) {
+	db, _ := openFakeTxDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := WithTx(ctx, db, &TxOptions{BackoffMin: time.Hour, BackoffMax: time.Hour}, func(tx *gorm.DB) error {
+		return sqlStateError("40001")
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || !IsRetryableTxError(err) {
+		t.Errorf("got %v, want both the deadline and the error of the transaction", err)
+	}
+}
+
+// ============ Fake database ==============
+
+var fakeTxConns sync.Map
+
+func init() {
+	sql.Register("tx-fake", fakeTxDriver{})
+}
+
+// openFakeTxDB returns a gorm connection on a fake database recording its transactions.
+func openFakeTxDB(t *testing.T) (*gorm.DB, *fakeTxConn) {
+	t.Helper()
+	conn := &fakeTxConn{}
+	name := fmt.Sprintf("%s-%p", t.Name(), conn)
+	fakeTxConns.Store(name, conn)
+	sqlDB, err := sql.Open("tx-fake", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: gormlogger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, conn
+}
+
+type fakeTxCounts struct {
+	begins, commits, rollbacks int
+}
+
+// fakeTxConn counts the transactions, and fails the commits with commitErrs, in order.
+type fakeTxConn struct {
+	mu         sync.Mutex
+	count      fakeTxCounts
+	lastOpts   driver.TxOptions
+	commitErrs []error
+}
+
+func (c *fakeTxConn) counts() fakeTxCounts {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.count
+}
+
+func (c *fakeTxConn) options() driver.TxOptions {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastOpts
+}
+
+func (c *fakeTxConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeTxConn) Close() error                        { return nil }
+func (c *fakeTxConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeTxConn) BeginTx(_ context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.count.begins++
+	c.lastOpts = opts
+	return c, nil
+}
+
+func (c *fakeTxConn) Commit() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.commitErrs) != 0 {
+		err := c.commitErrs[0]
+		c.commitErrs = c.commitErrs[1:]
+		return err
+	}
+	c.count.commits++
+	return nil
+}
+
+func (c *fakeTxConn) Rollback() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.count.rollbacks++
+	return nil
+}
+
+type fakeTxDriver struct{}
+
+func (fakeTxDriver) Open(name string) (driver.Conn, error) {
+	conn, found := fakeTxConns.Load(name)
+	if !found {
+		return nil, fmt.Errorf("unknown fake database %s", name)
+	}
+	return conn.(*fakeTxConn), nil
+}
@@ -0,0 +1,72 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm/schema"
+)
+
+// EncryptedString is a string column of a gorm v2 model, encrypted with the default key ring. It is stored as the
+// ciphertext of KeyRing.Encrypt bound to its table.column, in a text column, and decrypted when scanned, so that
+// a ciphertext copied to another column can't be decrypted. The empty string is stored as is.
+//
+// It is a gorm serializer rather than a driver.Valuer, to know its column: the legacy jinzhu/gorm connections and
+// database/sql would store it unencrypted, so use KeyRing.Encrypt and KeyRing.Decrypt there.
+type EncryptedString string
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// Value encrypts the string with the default key ring, bound to the table.column of the field.
+func (es EncryptedString) Value(_ context.Context, field *schema.Field, _ reflect.Value, _ interface{}) (interface{}, error) {
+	if es == "" {
+		return "", nil
+	}
+	kr, err := DefaultKeyRing()
+	if err != nil {
+		return nil, err
+	}
+	return kr.Encrypt([]byte(es), columnAAD(field))
+}
+
+// Scan decrypts the ciphertext of the column with the default key ring.
+// It returns ErrDecryptFailed if the ciphertext was encrypted for another column.
+func (es *EncryptedString) Scan(_ context.Context, field *schema.Field, _ reflect.Value, dbValue interface{}) error {
+	var ciphertext string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		ciphertext = v
+	case []byte:
+		ciphertext = string(v)
+	default:
+		return fmt.Errorf("type assertion .([]byte) failed, got %T", dbValue)
+	}
+	if ciphertext == "" {
+		*es = ""
+		return nil
+	}
+	kr, err := DefaultKeyRing()
+	if err != nil {
+		return err
+	}
+	plaintext, err := kr.Decrypt(ciphertext, columnAAD(field))
+	if err != nil {
+		return fmt.Errorf("error while decrypting %s: %w", columnAAD(field), err)
+	}
+	*es = EncryptedString(plaintext)
+	return nil
+}
+
+// GormDataType stores the ciphertext in a text column.
+func (EncryptedString) GormDataType() string {
+	return "text"
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+// columnAAD binds the ciphertexts to the table.column of the field.
+func columnAAD(field *schema.Field) string {
+	return field.Schema.Table + "." + field.DBName
+}
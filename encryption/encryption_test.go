@@ -0,0 +1,117 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm/schema"
+)
+
+func testKeyRing(t *testing.T, primaryId string) *KeyRing {
+	kr, err := NewKeyRing(primaryId, map[string][]byte{
+		"2023": bytes.Repeat([]byte{1}, KeySize),
+		"2024": bytes.Repeat([]byte{2}, KeySize),
+	}, bytes.Repeat([]byte{3}, KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kr
+}
+
+func TestKeyRotation(t *testing.T) {
+	old := testKeyRing(t, "2023")
+	ciphertext, err := old.Encrypt([]byte("4111111111111111"), "cards.pan")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := testKeyRing(t, "2024")
+	plaintext, err := rotated.Decrypt(ciphertext, "cards.pan")
+	if err != nil || string(plaintext) != "4111111111111111" {
+		t.Fatalf("got %q, %v after rotation", plaintext, err)
+	}
+	rewrapped, changed, err := rotated.Rewrap(ciphertext, "cards.pan")
+	if err != nil || !changed || !strings.HasPrefix(rewrapped, "v1.2024.") {
+		t.Fatalf("got %s, %t, %v on rewrap", rewrapped, changed, err)
+	}
+	if plaintext, err = rotated.Decrypt(rewrapped, "cards.pan"); err != nil || string(plaintext) != "4111111111111111" {
+		t.Errorf("got %q, %v after rewrap", plaintext, err)
+	}
+
+	tampered := []byte(ciphertext)
+	// not the last character, whose low bits may be padding
+	tampered[len(tampered)-5] ^= 'A' ^ 'B'
+	if _, err = rotated.Decrypt(string(tampered), "cards.pan"); !errors.Is(err, ErrDecryptFailed) && !errors.Is(err, ErrMalformed) {
+		t.Errorf("got error %v for a tampered ciphertext", err)
+	}
+}
+
+func TestCiphertextBoundToAAD(t *testing.T) {
+	kr := testKeyRing(t, "2024")
+	ciphertext, err := kr.Encrypt([]byte("4111111111111111"), "cards.pan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = kr.Decrypt(ciphertext, "cards.cvv"); !errors.Is(err, ErrDecryptFailed) {
+		t.Errorf("decrypt with another aad: got %v, want ErrDecryptFailed", err)
+	}
+	if _, _, err = testKeyRing(t, "2023").Rewrap(ciphertext, "cards.cvv"); !errors.Is(err, ErrDecryptFailed) {
+		t.Errorf("rewrap with another aad: got %v, want ErrDecryptFailed", err)
+	}
+}
+
+func TestLoadKeyRingDuplicateKeyId(t *testing.T) {
+	key := func(b byte) string { return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, KeySize)) }
+	t.Setenv(EncryptionKeys, "2024:"+key(1)+",2024:"+key(2))
+	t.Setenv(EncryptionPrimaryKeyId, "2024")
+	t.Setenv(BlindIndexKey, key(3))
+	if _, err := LoadKeyRing(); err == nil || !strings.Contains(err.Error(), "duplicate") {
+		t.Errorf("got %v, want the duplicate key id rejected", err)
+	}
+}
+
+func TestEncryptedString(t *testing.T) {
+	SetDefaultKeyRing(testKeyRing(t, "2024"))
+	defer SetDefaultKeyRing(nil)
+	type card struct {
+		ID  uint64
+		Pan EncryptedString
+		Cvv EncryptedString
+	}
+	s, err := schema.Parse(&card{}, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pan, cvv := s.LookUpField("Pan"), s.LookUpField("Cvv")
+	if pan.Serializer == nil {
+		t.Fatal("EncryptedString is not used as the serializer of its field")
+	}
+	ctx := context.Background()
+
+	value, err := EncryptedString("4111111111111111").Value(ctx, pan, reflect.Value{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(value.(string), "4111") {
+		t.Errorf("value %s is not encrypted", value)
+	}
+	var scanned EncryptedString
+	if err = scanned.Scan(ctx, pan, reflect.Value{}, []byte(value.(string))); err != nil || scanned != "4111111111111111" {
+		t.Errorf("got %q, %v", scanned, err)
+	}
+	// copied to another column
+	if err = scanned.Scan(ctx, cvv, reflect.Value{}, value); !errors.Is(err, ErrDecryptFailed) {
+		t.Errorf("scan of another column: got %v, want ErrDecryptFailed", err)
+	}
+
+	index, _ := BlindIndex("4111111111111111")
+	if other, _ := BlindIndex("4111111111111112"); index == other || len(index) != 64 {
+		t.Errorf("unexpected blind indexes %s and %s", index, other)
+	}
+}
@@ -0,0 +1,292 @@
+// Package encryption encrypts the sensitive columns, e.g. the PAN, with AES-256-GCM envelope encryption, and computes
+// their keyed blind index for the lookups.
+//
+// Every value is encrypted with its own random data key, which is itself encrypted (wrapped) with the primary
+// key-encryption key of the key ring. The ciphertext records the id of the key that wrapped its data key, so that
+// the key ring can keep the previous keys to decrypt the values written before a rotation. A ciphertext is bound to
+// additional data given by the caller, e.g. the table.column it is stored in, without which it can't be decrypted,
+// so that it can't be copied to another column.
+//
+//	kr, err := encryption.LoadKeyRing()
+//	encryption.SetDefaultKeyRing(kr)
+//
+//	type Card struct {
+//		ID       uint64
+//		Pan      encryption.EncryptedString
+//		PanIndex string `gorm:"index"`
+//	}
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/happay/cms-utils-go/v3/util"
+)
+
+// ============ Constants =============
+
+// config values read by LoadKeyRing, from the environment or the parameter store
+const (
+	// EncryptionKeys lists the key-encryption keys as comma-separated id:base64 pairs, e.g. "2024-06:...,2023-01:...".
+	// The keys are 32 bytes long.
+	EncryptionKeys = "ENCRYPTION_KEYS"
+	// EncryptionPrimaryKeyId is the id of the key wrapping the new data keys. The default is the first key.
+	EncryptionPrimaryKeyId = "ENCRYPTION_PRIMARY_KEY_ID"
+	// BlindIndexKey is the base64 HMAC key of the blind indexes, at least 32 bytes long. It must differ from the
+	// encryption keys, and can't be rotated without recomputing all the indexes.
+	BlindIndexKey = "BLIND_INDEX_KEY"
+)
+
+const (
+	KeySize      = 32
+	cipherPrefix = "v1"
+)
+
+var (
+	ErrNoKeyRing     = errors.New("encryption key ring is not set")
+	ErrUnknownKey    = errors.New("unknown encryption key")
+	ErrMalformed     = errors.New("malformed ciphertext")
+	ErrDecryptFailed = errors.New("decryption failed")
+)
+
+// KeyRing holds the key-encryption keys, of which the primary one wraps the new data keys, and the blind index key.
+type KeyRing struct {
+	primaryId string
+	keys      map[string]cipher.AEAD
+	indexKey  []byte
+}
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// NewKeyRing returns a key ring wrapping the new data keys with the primaryId key of keys.
+// The key ids can't contain "." or ":", and the keys must be KeySize bytes long.
+func NewKeyRing(primaryId string, keys map[string][]byte, indexKey []byte) (*KeyRing, error) {
+	kr := &KeyRing{primaryId: primaryId, keys: make(map[string]cipher.AEAD, len(keys)), indexKey: indexKey}
+	for id, key := range keys {
+		if id == "" || strings.ContainsAny(id, ".:,") {
+			return nil, fmt.Errorf("invalid encryption key id %q", id)
+		}
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("encryption key %s is %d bytes long instead of %d", id, len(key), KeySize)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		kr.keys[id] = aead
+	}
+	if _, found := kr.keys[primaryId]; !found {
+		return nil, fmt.Errorf("%w: primary key %s", ErrUnknownKey, primaryId)
+	}
+	if len(indexKey) < KeySize {
+		return nil, fmt.Errorf("blind index key is %d bytes long instead of at least %d", len(indexKey), KeySize)
+	}
+	for _, key := range keys {
+		if hmac.Equal(key, indexKey) {
+			return nil, fmt.Errorf("blind index key must differ from the encryption keys")
+		}
+	}
+	return kr, nil
+}
+
+// LoadKeyRing returns the key ring of the EncryptionKeys, EncryptionPrimaryKeyId and BlindIndexKey config values.
+func LoadKeyRing() (*KeyRing, error) {
+	keys := make(map[string][]byte)
+	primaryId := strings.TrimSpace(util.GetConfigValue(EncryptionPrimaryKeyId))
+	for _, pair := range strings.Split(util.GetConfigValue(EncryptionKeys), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		id, encodedKey, found := strings.Cut(strings.TrimSpace(pair), ":")
+		if !found {
+			return nil, fmt.Errorf("invalid %s entry, expected id:base64", EncryptionKeys)
+		}
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s key %s: %w", EncryptionKeys, id, err)
+		}
+		if _, found := keys[id]; found {
+			return nil, fmt.Errorf("duplicate %s key id %s", EncryptionKeys, id)
+		}
+		keys[id] = key
+		if primaryId == "" {
+			primaryId = id
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s is not set", EncryptionKeys)
+	}
+	indexKey, err := base64.StdEncoding.DecodeString(strings.TrimSpace(util.GetConfigValue(BlindIndexKey)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", BlindIndexKey, err)
+	}
+	return NewKeyRing(primaryId, keys, indexKey)
+}
+
+// SetDefaultKeyRing sets the key ring used by EncryptedString and BlindIndex.
+func SetDefaultKeyRing(kr *KeyRing) {
+	defaultKeyRingMu.Lock()
+	defer defaultKeyRingMu.Unlock()
+	defaultKeyRing = kr
+}
+
+// DefaultKeyRing returns the key ring set by SetDefaultKeyRing, or ErrNoKeyRing.
+func DefaultKeyRing() (*KeyRing, error) {
+	defaultKeyRingMu.RLock()
+	defer defaultKeyRingMu.RUnlock()
+	if defaultKeyRing == nil {
+		return nil, ErrNoKeyRing
+	}
+	return defaultKeyRing, nil
+}
+
+// Encrypt encrypts the plaintext with a new data key, wrapped with the primary key. The ciphertext is bound to aad,
+// e.g. the table.column it is stored in, and can only be decrypted with the same aad.
+// The ciphertext is v1.<key id>.<base64 wrapped data key>.<base64 sealed plaintext>.
+func (kr *KeyRing) Encrypt(plaintext []byte, aad string) (string, error) {
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", fmt.Errorf("error while generating data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(aead, plaintext, []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return kr.wrap(dataKey, sealed, aad)
+}
+
+// Decrypt decrypts a ciphertext of Encrypt, wrapped with any key of the key ring, and bound to aad.
+// It returns ErrDecryptFailed if the ciphertext was encrypted with another aad.
+func (kr *KeyRing) Decrypt(ciphertext, aad string) ([]byte, error) {
+	_, dataKey, sealed, err := kr.unwrap(ciphertext, aad)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, sealed, []byte(aad))
+}
+
+// Rewrap wraps again the data key of the ciphertext with the primary key, without decrypting the value,
+// and reports whether the ciphertext changed, i.e. whether it was wrapped with a previous key and must be saved.
+// aad is the one the ciphertext was encrypted with.
+func (kr *KeyRing) Rewrap(ciphertext, aad string) (string, bool, error) {
+	keyId, dataKey, sealed, err := kr.unwrap(ciphertext, aad)
+	if err != nil {
+		return "", false, err
+	}
+	if keyId == kr.primaryId {
+		return ciphertext, false, nil
+	}
+	rewrapped, err := kr.wrap(dataKey, sealed, aad)
+	return rewrapped, err == nil, err
+}
+
+// BlindIndex returns the hex HMAC-SHA256 of the value with the blind index key, to store along the encrypted value
+// and look it up by equality. The value should be normalized first, e.g. a PAN stripped of its spaces.
+func (kr *KeyRing) BlindIndex(value string) string {
+	mac := hmac.New(sha256.New, kr.indexKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// BlindIndex returns the blind index of the value with the default key ring.
+func BlindIndex(value string) (string, error) {
+	kr, err := DefaultKeyRing()
+	if err != nil {
+		return "", err
+	}
+	return kr.BlindIndex(value), nil
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+var defaultKeyRing *KeyRing
+var defaultKeyRingMu sync.RWMutex
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the plaintext with a random nonce, returned before the sealed text.
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("error while generating nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	return plaintext, nil
+}
+
+// wrap encrypts the data key with the primary key, bound to its id and to aad, and formats the ciphertext.
+func (kr *KeyRing) wrap(dataKey, sealed []byte, aad string) (string, error) {
+	wrappedKey, err := seal(kr.keys[kr.primaryId], dataKey, wrappedKeyAAD(kr.primaryId, aad))
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{
+		cipherPrefix,
+		kr.primaryId,
+		base64.RawStdEncoding.EncodeToString(wrappedKey),
+		base64.RawStdEncoding.EncodeToString(sealed),
+	}, "."), nil
+}
+
+// unwrap parses the ciphertext and decrypts its data key.
+func (kr *KeyRing) unwrap(ciphertext, aad string) (keyId string, dataKey, sealed []byte, err error) {
+	parts := strings.Split(ciphertext, ".")
+	if len(parts) != 4 || parts[0] != cipherPrefix {
+		return "", nil, nil, ErrMalformed
+	}
+	keyId = parts[1]
+	kek, found := kr.keys[keyId]
+	if !found {
+		return "", nil, nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyId)
+	}
+	wrappedKey, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	if sealed, err = base64.RawStdEncoding.DecodeString(parts[3]); err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	if dataKey, err = open(kek, wrappedKey, wrappedKeyAAD(keyId, aad)); err != nil {
+		return "", nil, nil, err
+	}
+	return keyId, dataKey, sealed, nil
+}
+
+// wrappedKeyAAD is the additional data of a wrapped data key. The key ids can't contain ":", so that it is unambiguous.
+func wrappedKeyAAD(keyId, aad string) []byte {
+	return []byte(keyId + ":" + aad)
+}
@@ -69,6 +69,7 @@ func initializeLogger(logPrefix, logPath string) {
 // GetLogger returns the logger object. It takes two input parameters.
 // - logPrefix - it is a string used as Prefix on each log line
 // - logPath - absolute path of the log file where the logs will be written
+// Unlike GetLoggerV3, its logs aren't masked by the redact package.
 func GetLogger(logPrefix, logPath string) *log.Logger {
 	logInit.Do(func() {
 		initializeLogger(logPrefix, logPath)
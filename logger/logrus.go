@@ -64,6 +64,7 @@ func initializeLoggerV2(logPrefix, logPath, appName string) {
 // - logPrefix - it is a string used as Prefix on each log line
 // - logPath - absolute path of the log file where the logs will be written
 // - appName - It is app Name, from which service this function is being called to route the log to a specific Graylog stream.
+// Unlike GetLoggerV3, its logs aren't masked by the redact package.
 func GetLoggerV2(logPrefix, logPath, appName string) *logrus.Logger {
 	logInit.Do(func() {
 		initializeLoggerV2(logPrefix, logPath, appName)
@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"log/slog"
+
+	"github.com/happay/cms-utils-go/v3/redact"
 )
 
 // ============ Internal(private) Methods - can only be called from inside this package ==============
@@ -18,6 +20,8 @@ type ContextAppId struct{}
 func initializeLoggerV3() *slog.Logger {
 	enc := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		AddSource: true,
+		// masks the card numbers, secrets and the other sensitive fields, see redact.SetDefault
+		ReplaceAttr: redact.ReplaceAttr,
 	})
 	h := ContextHandler{enc, []any{
 		ContextReqId{},
@@ -0,0 +1,302 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/happay/cms-utils-go/v3/logger"
+	"github.com/happay/cms-utils-go/v3/response"
+	"github.com/happay/cms-utils-go/v3/util"
+	"github.com/redis/go-redis/v9"
+)
+
+// ============ Constants =============
+
+// RateLimitAlgorithm is the algorithm used to count the requests against a quota.
+type RateLimitAlgorithm string
+
+const (
+	// SlidingWindow allows Limit requests in any Window, weighting the previous window count
+	// by its overlap with the sliding window.
+	SlidingWindow RateLimitAlgorithm = "sliding-window"
+	// TokenBucket allows bursts of up to Limit requests, refilled at a rate of Limit per Window.
+	TokenBucket RateLimitAlgorithm = "token-bucket"
+)
+
+// rate limit response headers
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+	HeaderRetryAfter         = "Retry-After"
+)
+
+const (
+	DefaultRateLimitKeyPrefix = "ratelimit"
+	RateLimitExceededMessage  = "rate limit exceeded. please retry after some time."
+	// RateLimitUnavailableMessage is sent with the 503 response when the quotas can't be checked and FailOpen is off.
+	RateLimitUnavailableMessage = "rate limiting is unavailable. please retry after some time."
+)
+
+// slidingWindowScript counts the request in each key, a hash holding one counter per fixed window, only if all
+// the quotas allow it. ARGV holds now, then the limit and window of each key.
+// It returns {allowed, remaining, retry after in ms, reset in ms} for each key, flattened.
+var slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local results = {}
+local denied = false
+for i, key in ipairs(KEYS) do
+	local limit = tonumber(ARGV[2 * i])
+	local window = tonumber(ARGV[2 * i + 1])
+	local current = math.floor(now / window)
+	local elapsed = now - current * window
+	local currCount = tonumber(redis.call("HGET", key, tostring(current)) or "0")
+	local prevCount = tonumber(redis.call("HGET", key, tostring(current - 1)) or "0")
+	local count = prevCount * (window - elapsed) / window + currCount
+	local reset = window - elapsed
+	if count + 1 > limit then
+		local retry = reset
+		if currCount + 1 <= limit and prevCount > 0 then
+			-- wait until enough of the previous window has slid out
+			retry = math.ceil(window - (limit - currCount - 1) * window / prevCount - elapsed)
+		end
+		denied = true
+		results[i] = {0, 0, math.max(retry, 1), reset, current, window}
+	else
+		results[i] = {1, math.floor(limit - count - 1), 0, reset, current, window}
+	end
+end
+local reply = {}
+for i, key in ipairs(KEYS) do
+	local r = results[i]
+	if denied then
+		if r[1] == 1 then
+			-- not counted, as another quota denied the request
+			r[2] = r[2] + 1
+		end
+	else
+		redis.call("HINCRBY", key, tostring(r[5]), 1)
+		redis.call("HDEL", key, tostring(r[5] - 2))
+		redis.call("PEXPIRE", key, r[6] * 2)
+	end
+	for j = 1, 4 do
+		table.insert(reply, r[j])
+	end
+end
+return reply
+`)
+
+// tokenBucketScript takes a token from the bucket of each key, a hash refilled for the elapsed time, only if all
+// the buckets have one. ARGV holds now, then the limit and window of each key, the limit being the capacity of
+// the bucket, refilled at limit per window.
+// It returns {allowed, remaining, retry after in ms, reset in ms} for each key, flattened.
+var tokenBucketScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local results = {}
+local denied = false
+for i, key in ipairs(KEYS) do
+	local capacity = tonumber(ARGV[2 * i])
+	local rate = capacity / tonumber(ARGV[2 * i + 1])
+	local bucket = redis.call("HMGET", key, "tokens", "ts")
+	local tokens = tonumber(bucket[1]) or capacity
+	local ts = tonumber(bucket[2]) or now
+	tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+	local retry = 0
+	if tokens < 1 then
+		denied = true
+		retry = math.ceil((1 - tokens) / rate)
+	end
+	results[i] = {tokens, retry, capacity, rate}
+end
+local reply = {}
+for i, key in ipairs(KEYS) do
+	local tokens, retry, capacity, rate = unpack(results[i])
+	local allowed = 0
+	if not denied then
+		allowed = 1
+		tokens = tokens - 1
+		redis.call("HMSET", key, "tokens", tostring(tokens), "ts", tostring(now))
+		redis.call("PEXPIRE", key, math.ceil(capacity / rate) + 1000)
+	elseif retry == 0 then
+		-- not taken, as another bucket is empty
+		allowed = 1
+	end
+	table.insert(reply, allowed)
+	table.insert(reply, math.floor(tokens))
+	table.insert(reply, retry)
+	table.insert(reply, math.ceil((capacity - tokens) / rate))
+end
+return reply
+`)
+
+// Quota is the number of requests allowed in a window. A zero Limit means no limit.
+type Quota struct {
+	Limit  int64
+	Window time.Duration
+}
+
+// RateLimiterConfig configures RateLimitMiddleware.
+type RateLimiterConfig struct {
+	// Client is the redis client holding the counters, e.g. connector.GetRedisConnectionWithAuth.
+	Client    redis.UniversalClient
+	Algorithm RateLimitAlgorithm
+
+	// DefaultQuota applies to each caller on each route which has no entry in RouteQuotas.
+	DefaultQuota Quota
+	// RouteQuotas applies to each caller on a route, keyed by method and route path, e.g. "POST /v1/cards/:id".
+	RouteQuotas map[string]Quota
+	// AppQuotas applies to a caller across all routes, keyed by App-ID.
+	AppQuotas map[string]Quota
+
+	// KeyFunc identifies the caller. The default is the App-ID header, or the client IP when it is missing.
+	KeyFunc func(c *gin.Context) string
+	// KeyPrefix prefixes the redis keys of the counters. The default is DefaultRateLimitKeyPrefix.
+	KeyPrefix string
+	// FailOpen lets the requests through when redis is unreachable. Otherwise they are rejected with a 503.
+	FailOpen bool
+}
+
+// RateLimitResult is the outcome of counting a request against a quota.
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int64
+	Remaining  int64
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// RateLimitMiddleware throttles the requests with the quotas of the config. It sets the RateLimit-* headers of the
+// most restrictive quota, and aborts the request with a 429 response and a Retry-After header when a quota is exceeded.
+// The route quota and the app quota are checked and counted together: a request denied by one isn't counted on
+// the other.
+func RateLimitMiddleware(cfg RateLimiterConfig) gin.HandlerFunc {
+	if cfg.KeyFunc == nil {
+		cfg.KeyFunc = appIdOrClientIP
+	}
+	if cfg.KeyPrefix == "" {
+		cfg.KeyPrefix = DefaultRateLimitKeyPrefix
+	}
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = SlidingWindow
+	}
+	return func(c *gin.Context) {
+		caller := cfg.KeyFunc(c)
+		route := c.Request.Method + " " + c.FullPath()
+
+		// the route quota and then the app quota, their keys sharing the {caller} hash tag so that both are
+		// checked and counted atomically, in the same slot on a cluster
+		var keys []string
+		var quotas []Quota
+		routeQuota, found := cfg.RouteQuotas[route]
+		if !found {
+			routeQuota = cfg.DefaultQuota
+		}
+		if routeQuota.Limit > 0 && routeQuota.Window > 0 {
+			keys = append(keys, fmt.Sprintf("%s:{%s}:%s", cfg.KeyPrefix, caller, route))
+			quotas = append(quotas, routeQuota)
+		}
+		if appQuota, found := cfg.AppQuotas[caller]; found && appQuota.Limit > 0 && appQuota.Window > 0 {
+			keys = append(keys, fmt.Sprintf("%s:{%s}", cfg.KeyPrefix, caller))
+			quotas = append(quotas, appQuota)
+		}
+		if len(keys) == 0 {
+			c.Next()
+			return
+		}
+
+		results, err := AllowAll(c.Request.Context(), cfg.Client, cfg.Algorithm, keys, quotas)
+		if err != nil {
+			logger.GetLoggerV3().ErrorContext(c.Request.Context(), fmt.Sprintf("RateLimitMiddleware | error while checking quotas %v: %s", keys, err))
+			if cfg.FailOpen {
+				c.Next()
+				return
+			}
+			response.SendServiceUnavailable503Response(c, RateLimitUnavailableMessage)
+			return
+		}
+		var mostRestrictive *RateLimitResult
+		for _, result := range results {
+			if mostRestrictive == nil || (mostRestrictive.Allowed && (!result.Allowed || result.Remaining < mostRestrictive.Remaining)) {
+				mostRestrictive = result
+			}
+		}
+
+		c.Header(HeaderRateLimitLimit, strconv.FormatInt(mostRestrictive.Limit, 10))
+		c.Header(HeaderRateLimitRemaining, strconv.FormatInt(mostRestrictive.Remaining, 10))
+		c.Header(HeaderRateLimitReset, strconv.FormatInt(ceilSeconds(mostRestrictive.Reset), 10))
+		if !mostRestrictive.Allowed {
+			c.Header(HeaderRetryAfter, strconv.FormatInt(ceilSeconds(mostRestrictive.RetryAfter), 10))
+			response.SendTooManyRequests429Response(c, RateLimitExceededMessage)
+			return
+		}
+		c.Next()
+	}
+}
+
+// Allow counts one request on the redis key against the quota, using the given algorithm.
+func Allow(ctx context.Context, client redis.UniversalClient, algorithm RateLimitAlgorithm, key string, quota Quota) (*RateLimitResult, error) {
+	results, err := AllowAll(ctx, client, algorithm, []string{key}, []Quota{quota})
+	if err != nil {
+		return nil, err
+	}
+	return results[0], nil
+}
+
+// AllowAll counts one request on each redis key against its quota, using the given algorithm, only if all the quotas
+// allow it: a request denied by one quota doesn't use up the others. The result of each key is returned in order.
+// On a cluster, the keys must be in the same slot, e.g. by sharing a {hash tag}.
+func AllowAll(ctx context.Context, client redis.UniversalClient, algorithm RateLimitAlgorithm, keys []string, quotas []Quota) ([]*RateLimitResult, error) {
+	if len(keys) != len(quotas) {
+		return nil, fmt.Errorf("%d rate limit keys for %d quotas", len(keys), len(quotas))
+	}
+	var script *redis.Script
+	switch algorithm {
+	case TokenBucket:
+		script = tokenBucketScript
+	case SlidingWindow:
+		script = slidingWindowScript
+	default:
+		return nil, fmt.Errorf("unknown rate limit algorithm %q", algorithm)
+	}
+	args := []interface{}{time.Now().UnixMilli()}
+	for _, quota := range quotas {
+		args = append(args, quota.Limit, quota.Window.Milliseconds())
+	}
+	values, err := script.Run(ctx, client, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != 4*len(keys) {
+		return nil, fmt.Errorf("unexpected rate limit script reply %v", values)
+	}
+	results := make([]*RateLimitResult, len(keys))
+	for i := range keys {
+		results[i] = &RateLimitResult{
+			Allowed:    values[4*i] == 1,
+			Limit:      quotas[i].Limit,
+			Remaining:  values[4*i+1],
+			RetryAfter: time.Duration(values[4*i+2]) * time.Millisecond,
+			Reset:      time.Duration(values[4*i+3]) * time.Millisecond,
+		}
+	}
+	return results, nil
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+func appIdOrClientIP(c *gin.Context) string {
+	if appId := c.GetHeader(util.AppID); appId != "" {
+		return appId
+	}
+	return c.ClientIP()
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/happay/cms-utils-go/v3/util"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestAllow(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	ctx := context.Background()
+	quota := Quota{Limit: 3, Window: time.Minute}
+
+	for _, algorithm := range []RateLimitAlgorithm{SlidingWindow, TokenBucket} {
+		key := "ratelimit:test:" + string(algorithm)
+		for i := int64(1); i <= quota.Limit; i++ {
+			result, err := Allow(ctx, client, algorithm, key, quota)
+			if err != nil {
+				t.Fatalf("%s: %s", algorithm, err)
+			}
+			if !result.Allowed || result.Remaining != quota.Limit-i {
+				t.Fatalf("%s: request %d got %+v, want allowed with %d remaining", algorithm, i, result, quota.Limit-i)
+			}
+		}
+		result, err := Allow(ctx, client, algorithm, key, quota)
+		if err != nil {
+			t.Fatalf("%s: %s", algorithm, err)
+		}
+		if result.Allowed || result.RetryAfter <= 0 || result.RetryAfter > quota.Window {
+			t.Fatalf("%s: got %+v, want denied with retry after within the window", algorithm, result)
+		}
+	}
+}
+
+func newRateLimitedRouter(cfg RateLimiterConfig) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RateLimitMiddleware(cfg))
+	router.GET("/v1/cards/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/v1/users/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return router
+}
+
+func get(router *gin.Engine, path, appId string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set(util.AppID, appId)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	for _, algorithm := range []RateLimitAlgorithm{SlidingWindow, TokenBucket} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			testRateLimitMiddleware(t, algorithm)
+		})
+	}
+}
+
+func testRateLimitMiddleware(t *testing.T, algorithm RateLimitAlgorithm) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	router := newRateLimitedRouter(RateLimiterConfig{
+		Client:       client,
+		Algorithm:    algorithm,
+		DefaultQuota: Quota{Limit: 2, Window: time.Minute},
+		RouteQuotas:  map[string]Quota{"GET /v1/users/:id": {Limit: 1, Window: time.Minute}},
+		AppQuotas:    map[string]Quota{"app-1": {Limit: 3, Window: time.Minute}},
+	})
+
+	w := get(router, "/v1/cards/1", "app-1")
+	if w.Code != http.StatusOK || w.Header().Get(HeaderRateLimitLimit) != "2" || w.Header().Get(HeaderRateLimitRemaining) != "1" ||
+		w.Header().Get(HeaderRateLimitReset) == "" {
+		t.Fatalf("got %d with headers %v", w.Code, w.Header())
+	}
+	// denied by the route quota, without using up the app quota
+	get(router, "/v1/users/1", "app-1")
+	for i := 0; i < 3; i++ {
+		if w = get(router, "/v1/users/2", "app-1"); w.Code != http.StatusTooManyRequests {
+			t.Fatalf("got %d, want 429", w.Code)
+		}
+	}
+	if retry, _ := strconv.Atoi(w.Header().Get(HeaderRetryAfter)); retry <= 0 || retry > 60 {
+		t.Errorf("got Retry-After %q", w.Header().Get(HeaderRetryAfter))
+	}
+	if w = get(router, "/v1/cards/2", "app-1"); w.Code != http.StatusOK || w.Header().Get(HeaderRateLimitRemaining) != "0" {
+		t.Errorf("got %d with %s remaining, want the third request of the app quota allowed", w.Code, w.Header().Get(HeaderRateLimitRemaining))
+	}
+	if w = get(router, "/v1/cards/3", "app-2"); w.Code != http.StatusOK {
+		t.Errorf("got %d for another app", w.Code)
+	}
+}
+
+func TestRateLimitMiddlewareRedisDown(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	defer client.Close()
+	mr.Close()
+
+	cfg := RateLimiterConfig{Client: client, DefaultQuota: Quota{Limit: 1, Window: time.Minute}}
+	if w := get(newRateLimitedRouter(cfg), "/v1/cards/1", "app-1"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("fail closed: got %d, want 503", w.Code)
+	}
+	cfg.FailOpen = true
+	if w := get(newRateLimitedRouter(cfg), "/v1/cards/1", "app-1"); w.Code != http.StatusOK || w.Header().Get(HeaderRateLimitLimit) != "" {
+		t.Errorf("fail open: got %d with headers %v", w.Code, w.Header())
+	}
+}
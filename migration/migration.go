@@ -0,0 +1,531 @@
+// Package migration applies versioned SQL migrations to a postgres or mysql database.
+//
+// The migrations are pairs of files named <version>_<name>.up.sql and <version>_<name>.down.sql,
+// usually embedded in the service binary:
+//
+//	//go:embed migrations/*.sql
+//	var migrations embed.FS
+//
+//	migrator, err := migration.NewFromGorm(db, migrations, "migrations")
+//	applied, err := migrator.Up(ctx)
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/happay/cms-utils-go/v3/logger"
+	"gorm.io/gorm"
+)
+
+// ============ Constants =============
+
+// Dialect is the SQL dialect of the database.
+type Dialect string
+
+const (
+	Postgres Dialect = "postgres"
+	MySQL    Dialect = "mysql"
+)
+
+const (
+	DefaultTable       = "schema_migrations"
+	DefaultLockTimeout = 5 * time.Minute
+
+	upSuffix   = ".up.sql"
+	downSuffix = ".down.sql"
+)
+
+var (
+	ErrNoDownMigration = errors.New("no down migration")
+	ErrLockTimeout     = errors.New("timeout while waiting for the migration lock")
+)
+
+// Migration is a version of the schema.
+type Migration struct {
+	Version uint64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status tells whether a migration is applied.
+type Status struct {
+	Version   uint64
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+	// Missing is set for an applied version which has no migration file.
+	Missing bool
+}
+
+// Option configures a Migrator.
+type Option func(*Migrator)
+
+// WithTable sets the table recording the applied versions. The default is DefaultTable.
+func WithTable(table string) Option {
+	return func(m *Migrator) {
+		m.table = table
+	}
+}
+
+// WithDryRun makes Up and Down log the SQL they would execute, without executing it.
+func WithDryRun(dryRun bool) Option {
+	return func(m *Migrator) {
+		m.dryRun = dryRun
+	}
+}
+
+// WithLockTimeout sets how long a mysql migration waits for the lock held by another pod.
+// The default is DefaultLockTimeout. On postgres the wait is only bounded by the context.
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(m *Migrator) {
+		m.lockTimeout = timeout
+	}
+}
+
+// Migrator applies the migrations of a directory.
+type Migrator struct {
+	db          *sql.DB
+	dialect     Dialect
+	migrations  []Migration
+	table       string
+	dryRun      bool
+	lockTimeout time.Duration
+}
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// New reads the migrations of the dir directory of fsys, to be applied to db.
+// With a gorm v1 connection, e.g. from connector.GetMySqlConn, pass db.DB().
+func New(db *sql.DB, dialect Dialect, fsys fs.FS, dir string, opts ...Option) (*Migrator, error) {
+	if dialect != Postgres && dialect != MySQL {
+		return nil, fmt.Errorf("unsupported migration dialect %q", dialect)
+	}
+	migrations, err := loadMigrations(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	m := &Migrator{
+		db:          db,
+		dialect:     dialect,
+		migrations:  migrations,
+		table:       DefaultTable,
+		lockTimeout: DefaultLockTimeout,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m, nil
+}
+
+// NewFromGorm is New for a gorm v2 connection, e.g. from connector.GetPostgres, the dialect being detected.
+func NewFromGorm(db *gorm.DB, fsys fs.FS, dir string, opts ...Option) (*Migrator, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	return New(sqlDB, Dialect(db.Dialector.Name()), fsys, dir, opts...)
+}
+
+// Up applies the pending migrations in version order, each in its own transaction, and returns them.
+// A lock is held meanwhile, so that the other pods starting at the same time wait instead of migrating too.
+func (m *Migrator) Up(ctx context.Context) (applied []Migration, err error) {
+	err = m.withLock(ctx, func(conn *sql.Conn) error {
+		appliedVersions, err := m.appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, found := appliedVersions[migration.Version]; found {
+				continue
+			}
+			if err = m.run(ctx, conn, migration, migration.Up, true); err != nil {
+				return err
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return
+}
+
+// Down reverts the last steps applied migrations, most recent first, and returns them.
+func (m *Migrator) Down(ctx context.Context, steps int) (reverted []Migration, err error) {
+	err = m.withLock(ctx, func(conn *sql.Conn) error {
+		appliedVersions, err := m.appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := m.migrations[i]
+			if _, found := appliedVersions[migration.Version]; !found {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("%w for version %d %s", ErrNoDownMigration, migration.Version, migration.Name)
+			}
+			if err = m.run(ctx, conn, migration, migration.Down, false); err != nil {
+				return err
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	return
+}
+
+// Status lists the migrations and the applied versions, in version order.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	appliedVersions, err := m.appliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if applied, found := appliedVersions[migration.Version]; found {
+			status.Applied = true
+			status.AppliedAt = &applied.AppliedAt
+			delete(appliedVersions, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for version, applied := range appliedVersions {
+		applied := applied
+		statuses = append(statuses, Status{Version: version, Name: applied.Name, Applied: true, AppliedAt: &applied.AppliedAt, Missing: true})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+// loadMigrations reads the <version>_<name>.up.sql and <version>_<name>.down.sql files of dir.
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading migrations directory %s: %w", dir, err)
+	}
+	byVersion := make(map[uint64]*Migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(fileName, ".sql") {
+			continue
+		}
+		var up bool
+		var base string
+		switch {
+		case strings.HasSuffix(fileName, upSuffix):
+			up, base = true, strings.TrimSuffix(fileName, upSuffix)
+		case strings.HasSuffix(fileName, downSuffix):
+			base = strings.TrimSuffix(fileName, downSuffix)
+		default:
+			return nil, fmt.Errorf("migration file %s is neither %s nor %s", fileName, upSuffix, downSuffix)
+		}
+		versionStr, name, _ := strings.Cut(base, "_")
+		version, err := strconv.ParseUint(versionStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %s doesn't start with a version: %w", fileName, err)
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, fileName))
+		if err != nil {
+			return nil, fmt.Errorf("error while reading migration file %s: %w", fileName, err)
+		}
+
+		migration, found := byVersion[version]
+		if !found {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		} else if migration.Name != name {
+			return nil, fmt.Errorf("migration version %d is used by both %s and %s", version, migration.Name, name)
+		}
+		if up {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration version %d %s has no up file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// withLock runs fn on a connection holding the migration lock. The lock is a session lock,
+// hence the single connection, released even if the pod dies as the connection closes with it.
+// A dry run doesn't lock, as it changes nothing.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if m.dryRun {
+		return fn(conn)
+	}
+
+	lockId := m.lockId()
+	switch m.dialect {
+	case Postgres:
+		_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockId)
+	case MySQL:
+		var locked sql.NullInt64
+		err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", strconv.FormatInt(lockId, 10), int(m.lockTimeout.Seconds())).Scan(&locked)
+		if err == nil && locked.Int64 != 1 {
+			err = ErrLockTimeout
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error while taking the migration lock: %w", err)
+	}
+	defer func() {
+		// the context may be done, yet the lock must be released for the connection to go back to the pool
+		var unlockErr error
+		switch m.dialect {
+		case Postgres:
+			_, unlockErr = conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockId)
+		case MySQL:
+			_, unlockErr = conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", strconv.FormatInt(lockId, 10))
+		}
+		if unlockErr != nil {
+			logger.GetLoggerV3().Error(fmt.Sprintf("error while releasing the migration lock: %s", unlockErr))
+		}
+	}()
+
+	if err = m.createTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// lockId derives the lock from the table name, so that the services sharing a database
+// with their own migration tables don't wait for each other.
+func (m *Migrator) lockId() int64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(m.table))
+	return int64(hash.Sum64())
+}
+
+func (m *Migrator) createTable(ctx context.Context, conn *sql.Conn) error {
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	version BIGINT NOT NULL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`, m.table)
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("error while creating migration table %s: %w", m.table, err)
+	}
+	return nil
+}
+
+type appliedVersion struct {
+	Name      string
+	AppliedAt time.Time
+}
+
+// appliedVersions returns the applied versions. A missing table means that no version is applied yet.
+func (m *Migrator) appliedVersions(ctx context.Context, conn *sql.Conn) (map[uint64]appliedVersion, error) {
+	exists, err := m.tableExists(ctx, conn)
+	if err != nil || !exists {
+		return map[uint64]appliedVersion{}, err
+	}
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT version, name, applied_at FROM %s", m.table))
+	if err != nil {
+		return nil, fmt.Errorf("error while reading migration table %s: %w", m.table, err)
+	}
+	defer rows.Close()
+	versions := make(map[uint64]appliedVersion)
+	for rows.Next() {
+		var version uint64
+		var applied appliedVersion
+		if err = rows.Scan(&version, &applied.Name, &applied.AppliedAt); err != nil {
+			return nil, fmt.Errorf("error while reading migration table %s: %w", m.table, err)
+		}
+		versions[version] = applied
+	}
+	return versions, rows.Err()
+}
+
+func (m *Migrator) tableExists(ctx context.Context, conn *sql.Conn) (bool, error) {
+	var query string
+	switch m.dialect {
+	case Postgres:
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1"
+	case MySQL:
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?"
+	}
+	var count int
+	if err := conn.QueryRowContext(ctx, query, m.table).Scan(&count); err != nil {
+		return false, fmt.Errorf("error while looking up migration table %s: %w", m.table, err)
+	}
+	return count > 0, nil
+}
+
+// run executes the SQL of the migration and records it as applied, or reverted, in the same transaction.
+// Note that mysql commits implicitly after each DDL statement, so a failed mysql migration may be partly applied.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, migration Migration, query string, up bool) error {
+	direction := "down"
+	if up {
+		direction = "up"
+	}
+	if m.dryRun {
+		logger.GetLoggerV3().Info(fmt.Sprintf("dry run | migration %d %s %s:\n%s", migration.Version, migration.Name, direction, query))
+		return nil
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, statement := range m.statements(query) {
+		if _, err = tx.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("error while running migration %d %s %s: %w", migration.Version, migration.Name, direction, err)
+		}
+	}
+	if up {
+		_, err = tx.ExecContext(ctx, m.bind(fmt.Sprintf("INSERT INTO %s (version, name) VALUES (?, ?)", m.table)), migration.Version, migration.Name)
+	} else {
+		_, err = tx.ExecContext(ctx, m.bind(fmt.Sprintf("DELETE FROM %s WHERE version = ?", m.table)), migration.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("error while recording migration %d %s %s: %w", migration.Version, migration.Name, direction, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error while committing migration %d %s %s: %w", migration.Version, migration.Name, direction, err)
+	}
+	logger.GetLoggerV3().Info(fmt.Sprintf("migration %d %s %s applied", migration.Version, migration.Name, direction))
+	return nil
+}
+
+// statements splits the SQL of a migration into its statements, as the drivers run a single statement per call:
+// the mysql driver unless multiStatements is set, and pgx as it prepares them. The semicolons of the quoted strings
+// and identifiers, of the comments and of the postgres dollar quoted bodies don't end a statement.
+// The parts holding only comments are dropped, as mysql rejects an empty query.
+func (m *Migrator) statements(query string) []string {
+	var statements []string
+	start := 0
+	hasCode := false // whether the current statement holds more than spaces and comments
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || (c == '`' && m.dialect == MySQL):
+			i = m.skipQuoted(query, i)
+			hasCode = true
+		case strings.HasPrefix(query[i:], "--") || (c == '#' && m.dialect == MySQL):
+			if end := strings.IndexByte(query[i:], '\n'); end >= 0 {
+				i += end + 1
+			} else {
+				i = len(query)
+			}
+		case strings.HasPrefix(query[i:], "/*"):
+			if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+				i += 2 + end + 2
+			} else {
+				i = len(query)
+			}
+		case c == '$' && m.dialect == Postgres:
+			if tag := dollarQuoteTag(query[i:]); tag != "" {
+				if end := strings.Index(query[i+len(tag):], tag); end >= 0 {
+					i += len(tag) + end + len(tag)
+				} else {
+					i = len(query)
+				}
+			} else {
+				i++
+			}
+			hasCode = true
+		case c == ';':
+			if hasCode {
+				statements = append(statements, strings.TrimSpace(query[start:i+1]))
+			}
+			i++
+			start, hasCode = i, false
+		default:
+			if !unicode.IsSpace(rune(c)) {
+				hasCode = true
+			}
+			i++
+		}
+	}
+	if hasCode {
+		statements = append(statements, strings.TrimSpace(query[start:]))
+	}
+	return statements
+}
+
+// skipQuoted returns the index following the string or identifier opened by the quote at start.
+// A doubled quote is read as two adjacent strings, which gives the same result.
+func (m *Migrator) skipQuoted(query string, start int) int {
+	quote := query[start]
+	// backslashes escape on mysql, and on postgres in the E'...' strings only
+	backslash := quote != '`' && (m.dialect == MySQL ||
+		(quote == '\'' && start > 0 && (query[start-1] == 'E' || query[start-1] == 'e')))
+	for i := start + 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if backslash {
+				i++
+			}
+		case quote:
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+// dollarQuoteTag returns the $tag$ or $$ opening a postgres dollar quoted string at the start of query, if any.
+func dollarQuoteTag(query string) string {
+	for i := 1; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '$':
+			return query[:i+1]
+		case c == '_' || unicode.IsLetter(rune(c)) || (i > 1 && c >= '0' && c <= '9'):
+		default:
+			// e.g. the $1 placeholder
+			return ""
+		}
+	}
+	return ""
+}
+
+// bind replaces the ? placeholders with the $n placeholders of postgres.
+func (m *Migrator) bind(query string) string {
+	if m.dialect != Postgres {
+		return query
+	}
+	var builder strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			builder.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
@@ -0,0 +1,325 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0002_add_email.up.sql":      {Data: []byte("ALTER TABLE users ADD email TEXT;")},
+		"migrations/0002_add_email.down.sql":    {Data: []byte("ALTER TABLE users DROP email;")},
+		"migrations/0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id BIGINT);")},
+		"migrations/0001_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		"migrations/README.md":                  {Data: []byte("ignored")},
+	}
+	migrations, err := loadMigrations(fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Migration{
+		{Version: 1, Name: "create_users", Up: "CREATE TABLE users (id BIGINT);", Down: "DROP TABLE users;"},
+		{Version: 2, Name: "add_email", Up: "ALTER TABLE users ADD email TEXT;", Down: "ALTER TABLE users DROP email;"},
+	}
+	if !reflect.DeepEqual(migrations, want) {
+		t.Errorf("got %+v, want %+v", migrations, want)
+	}
+
+	fsys["migrations/0003_orphan.down.sql"] = &fstest.MapFile{Data: []byte("SELECT 1;")}
+	if _, err = loadMigrations(fsys, "migrations"); err == nil {
+		t.Error("expected an error for a migration without up file")
+	}
+}
+
+func TestStatements(t *testing.T) {
+	for name, tc := range map[string]struct {
+		dialect Dialect
+		query   string
+		want    []string
+	}{
+		"mysql lines": {
+			MySQL, "CREATE TABLE a (id INT);\nCREATE TABLE b (\n  id INT\n);\n",
+			[]string{"CREATE TABLE a (id INT);", "CREATE TABLE b (\n  id INT\n);"},
+		},
+		"same line": {
+			Postgres, "UPDATE a SET x = 1; UPDATE b SET y = 2",
+			[]string{"UPDATE a SET x = 1;", "UPDATE b SET y = 2"},
+		},
+		"strings": {
+			MySQL, `INSERT INTO t VALUES ('a;b', "c;d", 'it''s;', 'e\';f'); SELECT ` + "`x;y`" + ` FROM t;`,
+			[]string{`INSERT INTO t VALUES ('a;b', "c;d", 'it''s;', 'e\';f');`, "SELECT `x;y` FROM t;"},
+		},
+		"postgres escapes": {
+			Postgres, `INSERT INTO t VALUES ('a\', E'b\';c'); SELECT 1;`,
+			[]string{`INSERT INTO t VALUES ('a\', E'b\';c');`, "SELECT 1;"},
+		},
+		"comments": {
+			MySQL, "-- create; the table\nCREATE TABLE a (id INT); /* done; */\n# trailing; comment\n",
+			[]string{"-- create; the table\nCREATE TABLE a (id INT);"},
+		},
+		"dollar quotes": {
+			Postgres, "CREATE FUNCTION f() RETURNS INT AS $body$ BEGIN RETURN 1; END; $body$ LANGUAGE plpgsql;\n" +
+				"DO $$ BEGIN PERFORM 1; END $$;\nSELECT $1::text;",
+			[]string{
+				"CREATE FUNCTION f() RETURNS INT AS $body$ BEGIN RETURN 1; END; $body$ LANGUAGE plpgsql;",
+				"DO $$ BEGIN PERFORM 1; END $$;",
+				"SELECT $1::text;",
+			},
+		},
+		"empty": {Postgres, " ;\n-- nothing\n", nil},
+	} {
+		m := &Migrator{dialect: tc.dialect}
+		if statements := m.statements(tc.query); !reflect.DeepEqual(statements, tc.want) {
+			t.Errorf("%s: got %q, want %q", name, statements, tc.want)
+		}
+	}
+}
+
+func TestUpDown(t *testing.T) {
+	ctx := context.Background()
+	fsys := fstest.MapFS{
+		"migrations/0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id BIGINT);")},
+		"migrations/0001_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		"migrations/0002_add_email.up.sql":      {Data: []byte("ALTER TABLE users ADD email TEXT; CREATE INDEX users_email ON users (email);")},
+		"migrations/0002_add_email.down.sql":    {Data: []byte("ALTER TABLE users DROP email;")},
+		"migrations/0003_add_name.up.sql":       {Data: []byte("ALTER TABLE users ADD name TEXT;")},
+		"migrations/0003_add_name.down.sql":     {Data: []byte("ALTER TABLE users DROP name;")},
+	}
+	db, state := openFakeDB(t)
+	state.versions[1] = "create_users"
+	migrator, err := New(db, Postgres, fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions := migrationVersions(applied); !reflect.DeepEqual(versions, []uint64{2, 3}) {
+		t.Errorf("applied %v, want the pending versions 2 and 3", versions)
+	}
+	wantExecuted := []string{
+		"ALTER TABLE users ADD email TEXT;",
+		"CREATE INDEX users_email ON users (email);",
+		"ALTER TABLE users ADD name TEXT;",
+	}
+	if !reflect.DeepEqual(state.executed, wantExecuted) {
+		t.Errorf("executed %q, want %q", state.executed, wantExecuted)
+	}
+	if !reflect.DeepEqual(state.versions, map[uint64]string{1: "create_users", 2: "add_email", 3: "add_name"}) {
+		t.Errorf("recorded versions %v", state.versions)
+	}
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if !status.Applied || status.Missing {
+			t.Errorf("status %+v, want applied", status)
+		}
+	}
+
+	state.executed = nil
+	reverted, err := migrator.Down(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions := migrationVersions(reverted); !reflect.DeepEqual(versions, []uint64{3, 2}) {
+		t.Errorf("reverted %v, want the most recent first", versions)
+	}
+	if want := []string{"ALTER TABLE users DROP name;", "ALTER TABLE users DROP email;"}; !reflect.DeepEqual(state.executed, want) {
+		t.Errorf("executed %q, want %q", state.executed, want)
+	}
+	if !reflect.DeepEqual(state.versions, map[uint64]string{1: "create_users"}) {
+		t.Errorf("recorded versions %v", state.versions)
+	}
+}
+
+func TestUpFailureAndDryRun(t *testing.T) {
+	ctx := context.Background()
+	fsys := fstest.MapFS{
+		"migrations/0001_create_users.up.sql": {Data: []byte("CREATE TABLE users (id BIGINT);")},
+		"migrations/0002_broken.up.sql":       {Data: []byte("ALTER TABLE users ADD email TEXT; FAIL;")},
+		"migrations/0003_add_name.up.sql":     {Data: []byte("ALTER TABLE users ADD name TEXT;")},
+	}
+	db, state := openFakeDB(t)
+
+	dryRun, err := New(db, MySQL, fsys, "migrations", WithDryRun(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied, err := dryRun.Up(ctx); err != nil || len(applied) != 3 {
+		t.Fatalf("dry run applied %v, %v", migrationVersions(applied), err)
+	}
+	if len(state.executed) != 0 || len(state.versions) != 0 || state.tableCreated {
+		t.Fatalf("dry run changed the database: %q, %v", state.executed, state.versions)
+	}
+
+	migrator, err := New(db, MySQL, fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied, err := migrator.Up(ctx)
+	if err == nil || !strings.Contains(err.Error(), "migration 2 broken up") {
+		t.Fatalf("got error %v, want the failure of migration 2", err)
+	}
+	if versions := migrationVersions(applied); !reflect.DeepEqual(versions, []uint64{1}) {
+		t.Errorf("applied %v, want only version 1", versions)
+	}
+	if !reflect.DeepEqual(state.versions, map[uint64]string{1: "create_users"}) {
+		t.Errorf("recorded versions %v, want only version 1", state.versions)
+	}
+}
+
+func migrationVersions(migrations []Migration) []uint64 {
+	var versions []uint64
+	for _, migration := range migrations {
+		versions = append(versions, migration.Version)
+	}
+	return versions
+}
+
+// ============ Fake database ==============
+
+// fakeState is a database understanding the queries of the Migrator. The other statements are recorded,
+// and those starting with FAIL fail. The changes of a transaction are applied on commit.
+type fakeState struct {
+	mu           sync.Mutex
+	tableCreated bool
+	versions     map[uint64]string
+	executed     []string
+}
+
+var fakeDBs sync.Map
+
+func init() {
+	sql.Register("migration-fake", fakeDriver{})
+}
+
+func openFakeDB(t *testing.T) (*sql.DB, *fakeState) {
+	t.Helper()
+	state := &fakeState{versions: map[uint64]string{}}
+	fakeDBs.Store(t.Name(), state)
+	db, err := sql.Open("migration-fake", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, state
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	state, found := fakeDBs.Load(name)
+	if !found {
+		return nil, fmt.Errorf("unknown fake database %s", name)
+	}
+	return &fakeConn{state: state.(*fakeState)}, nil
+}
+
+type fakeConn struct {
+	state   *fakeState
+	pending []func()
+	inTx    bool
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.inTx = true
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	for _, change := range c.pending {
+		change()
+	}
+	c.pending, c.inTx = nil, false
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.pending, c.inTx = nil, false
+	return nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	var change func()
+	switch {
+	case strings.HasPrefix(query, "SELECT pg_advisory_") || strings.HasPrefix(query, "SELECT RELEASE_LOCK"):
+		return driver.ResultNoRows, nil
+	case strings.HasPrefix(query, "CREATE TABLE IF NOT EXISTS schema_migrations"):
+		change = func() { c.state.tableCreated = true }
+	case strings.HasPrefix(query, "INSERT INTO schema_migrations"):
+		change = func() { c.state.versions[uint64(args[0].Value.(int64))] = args[1].Value.(string) }
+	case strings.HasPrefix(query, "DELETE FROM schema_migrations"):
+		change = func() { delete(c.state.versions, uint64(args[0].Value.(int64))) }
+	case strings.HasPrefix(query, "FAIL"):
+		return nil, errors.New("syntax error")
+	default:
+		change = func() { c.state.executed = append(c.state.executed, query) }
+	}
+	if c.inTx {
+		c.pending = append(c.pending, change)
+	} else {
+		c.state.mu.Lock()
+		change()
+		c.state.mu.Unlock()
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	rows := &fakeRows{}
+	switch {
+	case strings.HasPrefix(query, "SELECT GET_LOCK"):
+		rows.columns, rows.values = []string{"locked"}, [][]driver.Value{{int64(1)}}
+	case strings.HasPrefix(query, "SELECT COUNT(*) FROM information_schema.tables"):
+		count := int64(0)
+		if c.state.tableCreated || len(c.state.versions) != 0 {
+			count = 1
+		}
+		rows.columns, rows.values = []string{"count"}, [][]driver.Value{{count}}
+	case strings.HasPrefix(query, "SELECT version, name, applied_at FROM schema_migrations"):
+		rows.columns = []string{"version", "name", "applied_at"}
+		for version, name := range c.state.versions {
+			rows.values = append(rows.values, []driver.Value{int64(version), name, time.Now()})
+		}
+	default:
+		return nil, fmt.Errorf("unexpected query %s", query)
+	}
+	return rows, nil
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
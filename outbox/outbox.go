@@ -0,0 +1,108 @@
+// Package outbox implements the transactional outbox pattern: the messages are written to an outbox table in
+// the same transaction as the business data, and a relay pushes them to SQS once committed, so that a message is
+// never lost, nor sent for a rolled back change.
+//
+//	err := connector.WithTx(ctx, db, nil, func(tx *gorm.DB) error {
+//		if err := tx.Create(&card).Error; err != nil {
+//			return err
+//		}
+//		return outbox.Add(tx, "card-events", sqs.QueueMessage{Message: body})
+//	})
+//
+// The messages are delivered at least once, and not necessarily in order when a delivery is retried.
+package outbox
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/happay/cms-utils-go/v3/connector/aws/sqs"
+	"gorm.io/gorm"
+)
+
+// ============ Constants =============
+
+// Status is the delivery status of a message.
+type Status string
+
+const (
+	StatusPending Status = "pending"
+	StatusSent    Status = "sent"
+	// StatusFailed is set once a message failed MaxAttempts times. It is no longer retried.
+	StatusFailed Status = "failed"
+)
+
+const TableName = "outbox_messages"
+
+// ============ Structs =============
+
+// Message is a row of the outbox table.
+type Message struct {
+	ID            uint64     `gorm:"primaryKey" json:"id"`
+	Queue         string     `gorm:"size:255;not null" json:"queue"`
+	Body          string     `gorm:"type:text;not null" json:"body"`
+	Attributes    Attributes `gorm:"type:text" json:"attributes,omitempty"`
+	Delay         int64      `gorm:"not null;default:0" json:"delay"`
+	Status        Status     `gorm:"size:16;not null;index:idx_outbox_messages_pending,priority:1" json:"status"`
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time  `gorm:"not null;index:idx_outbox_messages_pending,priority:2" json:"next_attempt_at"`
+	LastError     string     `gorm:"type:text" json:"last_error,omitempty"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+func (Message) TableName() string {
+	return TableName
+}
+
+// Attributes are the SQS message attributes, stored as json.
+type Attributes map[string]string
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+func (a Attributes) Value() (driver.Value, error) {
+	if a == nil {
+		return nil, nil
+	}
+	j, err := json.Marshal(a)
+	return string(j), err
+}
+
+func (a *Attributes) Scan(src interface{}) error {
+	switch source := src.(type) {
+	case nil:
+		*a = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(source, a)
+	case string:
+		return json.Unmarshal([]byte(source), a)
+	default:
+		return fmt.Errorf("unsupported type %T for outbox attributes", src)
+	}
+}
+
+// Migrate creates or updates the outbox table.
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(&Message{})
+}
+
+// Add writes the message to the outbox with tx, the transaction of the business data, for the relay to send it
+// to the queue once committed. The queue is one of the keys of RelayConfig.Queues.
+func Add(tx *gorm.DB, queue string, msg sqs.QueueMessage) error {
+	row := &Message{
+		Queue:         queue,
+		Body:          msg.Message,
+		Attributes:    msg.Attributes,
+		Delay:         msg.Delay,
+		Status:        StatusPending,
+		NextAttemptAt: time.Now(),
+	}
+	if err := tx.Create(row).Error; err != nil {
+		return fmt.Errorf("error while adding message to outbox of %s: %w", queue, err)
+	}
+	return nil
+}
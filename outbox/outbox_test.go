@@ -0,0 +1,324 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/happay/cms-utils-go/v3/connector/aws/sqs"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+func TestAttributesRoundTrip(t *testing.T) {
+	attributes := Attributes{"event": "card.created", "app-id": "partner-app"}
+	value, err := attributes.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scanned Attributes
+	if err = scanned.Scan([]byte(value.(string))); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(scanned, attributes) {
+		t.Errorf("got %v, want %v", scanned, attributes)
+	}
+}
+
+func TestRelayBackoff(t *testing.T) {
+	r := NewRelay(RelayConfig{BackoffMin: time.Second, BackoffMax: 10 * time.Second})
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 10: 10 * time.Second} {
+		if got := r.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+// fakeQueue records the messages sent, and fails them while err is set.
+type fakeQueue struct {
+	sent []string
+	err  error
+}
+
+func (q *fakeQueue) Enqueue(msg sqs.QueueMessage) error {
+	if q.err != nil {
+		return q.err
+	}
+	q.sent = append(q.sent, msg.Message)
+	return nil
+}
+
+func TestRelayOnce(t *testing.T) {
+	ctx := context.Background()
+	db, store := openFakeOutbox(t)
+	past := time.Now().Add(-time.Second)
+	store.add(Message{ID: 1, Queue: "card-events", Body: "card 1 created", Status: StatusPending, NextAttemptAt: past})
+	store.add(Message{ID: 2, Queue: "settlements", Body: "settlement 1", Status: StatusPending, NextAttemptAt: past})
+	store.add(Message{ID: 3, Queue: "settlements", Body: "settlement 2", Status: StatusPending, NextAttemptAt: past, Attempts: 2})
+	store.add(Message{ID: 4, Queue: "card-events", Body: "card 2 created", Status: StatusPending, NextAttemptAt: time.Now().Add(time.Hour)})
+	cardEvents := &fakeQueue{}
+	settlements := &fakeQueue{err: errors.New("queue unavailable")}
+	relay := NewRelay(RelayConfig{
+		DB:          db,
+		Queues:      map[string]Enqueuer{"card-events": cardEvents, "settlements": settlements},
+		MaxAttempts: 3,
+	})
+
+	processed, err := relay.RelayOnce(ctx)
+	if err != nil || processed != 3 {
+		t.Fatalf("got %d processed, %v, want the 3 messages due", processed, err)
+	}
+	if !reflect.DeepEqual(cardEvents.sent, []string{"card 1 created"}) {
+		t.Errorf("sent %q", cardEvents.sent)
+	}
+	if msg := store.get(1); msg.Status != StatusSent || msg.Attempts != 1 || msg.SentAt == nil {
+		t.Errorf("sent message %+v", msg)
+	}
+	if msg := store.get(2); msg.Status != StatusPending || msg.Attempts != 1 || msg.LastError != "queue unavailable" ||
+		!msg.NextAttemptAt.After(time.Now()) {
+		t.Errorf("failed message %+v, want it pending with a backoff", msg)
+	}
+	if msg := store.get(3); msg.Status != StatusFailed || msg.Attempts != 3 {
+		t.Errorf("message failed MaxAttempts times %+v, want it failed", msg)
+	}
+	if msg := store.get(4); msg.Status != StatusPending || msg.Attempts != 0 {
+		t.Errorf("message not due %+v", msg)
+	}
+
+	// nothing due anymore
+	if processed, err = relay.RelayOnce(ctx); err != nil || processed != 0 {
+		t.Errorf("got %d processed, %v, want none", processed, err)
+	}
+}
+
+func TestRelayOnceDoesNotResendOnUpdateFailure(t *testing.T) {
+	ctx := context.Background()
+	db, store := openFakeOutbox(t)
+	store.add(Message{ID: 1, Queue: "card-events", Body: "card 1 created", Status: StatusPending, NextAttemptAt: time.Now().Add(-time.Second)})
+	cardEvents := &fakeQueue{}
+	relay := NewRelay(RelayConfig{DB: db, Queues: map[string]Enqueuer{"card-events": cardEvents}, Lease: 50 * time.Millisecond})
+
+	store.failStatusUpdates = true
+	if _, err := relay.RelayOnce(ctx); err == nil {
+		t.Fatal("got no error, want the failure to record the outcome")
+	}
+	if len(cardEvents.sent) != 1 {
+		t.Fatalf("sent %d times, want once", len(cardEvents.sent))
+	}
+	if processed, err := relay.RelayOnce(ctx); err != nil || processed != 0 || len(cardEvents.sent) != 1 {
+		t.Fatalf("got %d processed, %v, %d sends, want the claimed message skipped", processed, err, len(cardEvents.sent))
+	}
+
+	// at least once: sent again once the lease expires
+	store.failStatusUpdates = false
+	time.Sleep(60 * time.Millisecond)
+	if processed, err := relay.RelayOnce(ctx); err != nil || processed != 1 || len(cardEvents.sent) != 2 {
+		t.Fatalf("got %d processed, %v, %d sends, want the message sent again after its lease", processed, err, len(cardEvents.sent))
+	}
+	if msg := store.get(1); msg.Status != StatusSent {
+		t.Errorf("message %+v, want it sent", msg)
+	}
+}
+
+// ============ Fake database ==============
+
+// fakeOutbox is an outbox table understanding the queries of the relay, as generated by gorm for postgres.
+type fakeOutbox struct {
+	mu       sync.Mutex
+	messages map[uint64]*Message
+	// failStatusUpdates fails the updates recording the outcome of a send
+	failStatusUpdates bool
+}
+
+var fakeOutboxes sync.Map
+
+func init() {
+	sql.Register("outbox-fake", fakeDriver{})
+}
+
+func openFakeOutbox(t *testing.T) (*gorm.DB, *fakeOutbox) {
+	t.Helper()
+	store := &fakeOutbox{messages: map[uint64]*Message{}}
+	fakeOutboxes.Store(t.Name(), store)
+	sqlDB, err := sql.Open("outbox-fake", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: gormlogger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, store
+}
+
+func (o *fakeOutbox) add(msg Message) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.messages[msg.ID] = &msg
+}
+
+func (o *fakeOutbox) get(id uint64) Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return *o.messages[id]
+}
+
+var (
+	fakeSetPattern   = regexp.MustCompile(`"(\w+)"=\$(\d+)`)
+	fakeWherePattern = regexp.MustCompile(`WHERE id (?:= \$(\d+)|IN \(([$\d,]+)\))`)
+)
+
+func (o *fakeOutbox) query(query string, args []driver.NamedValue) (driver.Rows, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if strings.HasPrefix(query, "UPDATE ") {
+		return &fakeRows{}, o.update(query, args)
+	}
+	if !strings.HasPrefix(query, `SELECT * FROM "outbox_messages" WHERE status = $1 AND next_attempt_at <= $2 ORDER BY id`) {
+		return nil, fmt.Errorf("unexpected query %s", query)
+	}
+	status, now := args[0].Value.(string), args[1].Value.(time.Time)
+	rows := &fakeRows{columns: []string{"id", "queue", "body", "status", "attempts", "next_attempt_at", "last_error", "sent_at"}}
+	ids := make([]uint64, 0, len(o.messages))
+	for id := range o.messages {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		msg := o.messages[id]
+		if string(msg.Status) != status || msg.NextAttemptAt.After(now) {
+			continue
+		}
+		var sentAt driver.Value
+		if msg.SentAt != nil {
+			sentAt = *msg.SentAt
+		}
+		rows.values = append(rows.values, []driver.Value{
+			int64(msg.ID), msg.Queue, msg.Body, string(msg.Status), int64(msg.Attempts), msg.NextAttemptAt, msg.LastError, sentAt,
+		})
+	}
+	return rows, nil
+}
+
+// update must be called with the lock held.
+func (o *fakeOutbox) update(query string, args []driver.NamedValue) error {
+	arg := func(placeholder string) driver.Value {
+		n, _ := strconv.Atoi(strings.TrimPrefix(placeholder, "$"))
+		return args[n-1].Value
+	}
+	where := fakeWherePattern.FindStringSubmatch(query)
+	if where == nil {
+		return fmt.Errorf("unexpected update %s", query)
+	}
+	placeholders := []string{where[1]}
+	if where[1] == "" {
+		placeholders = strings.Split(where[2], ",")
+	}
+	sets := fakeSetPattern.FindAllStringSubmatch(query[:strings.Index(query, " WHERE ")], -1)
+	for _, set := range sets {
+		if set[1] == "status" && o.failStatusUpdates {
+			// retryable by connector.WithTx, which must not send the message again
+			return serializationError{}
+		}
+	}
+	for _, placeholder := range placeholders {
+		msg, found := o.messages[uint64(arg(placeholder).(int64))]
+		if !found {
+			continue
+		}
+		for _, set := range sets {
+			value := arg("$" + set[2])
+			switch set[1] {
+			case "status":
+				msg.Status = Status(value.(string))
+			case "attempts":
+				msg.Attempts = int(value.(int64))
+			case "next_attempt_at":
+				msg.NextAttemptAt = value.(time.Time)
+			case "last_error":
+				msg.LastError = value.(string)
+			case "sent_at":
+				sentAt := value.(time.Time)
+				msg.SentAt = &sentAt
+			case "updated_at":
+				msg.UpdatedAt = value.(time.Time)
+			default:
+				return fmt.Errorf("unexpected column %s", set[1])
+			}
+		}
+	}
+	return nil
+}
+
+type serializationError struct{}
+
+func (serializationError) Error() string {
+	return "could not serialize access due to concurrent update"
+}
+func (serializationError) SQLState() string { return "40001" }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	store, found := fakeOutboxes.Load(name)
+	if !found {
+		return nil, fmt.Errorf("unknown fake outbox %s", name)
+	}
+	return &fakeConn{store: store.(*fakeOutbox)}, nil
+}
+
+// fakeConn runs the transactions without isolation, which the tests don't need.
+type fakeConn struct {
+	store *fakeOutbox
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return c, nil }
+func (c *fakeConn) Commit() error                       { return nil }
+func (c *fakeConn) Rollback() error                     { return nil }
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.store.query(query, args)
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	if !strings.HasPrefix(query, "UPDATE ") {
+		return nil, fmt.Errorf("unexpected statement %s", query)
+	}
+	if err := c.store.update(query, args); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
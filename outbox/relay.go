@@ -0,0 +1,211 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/happay/cms-utils-go/v3/connector"
+	"github.com/happay/cms-utils-go/v3/connector/aws/sqs"
+	"github.com/happay/cms-utils-go/v3/logger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ============ Constants =============
+
+const (
+	DefaultBatchSize    = 100
+	DefaultPollInterval = time.Second
+	DefaultMaxAttempts  = 10
+	DefaultBackoffMin   = time.Second
+	DefaultBackoffMax   = 5 * time.Minute
+	DefaultLease        = time.Minute
+)
+
+// Enqueuer sends a message to a queue. It is implemented by *sqs.QueueClient.
+type Enqueuer interface {
+	Enqueue(q sqs.QueueMessage) error
+}
+
+// RelayConfig configures a Relay.
+type RelayConfig struct {
+	DB *gorm.DB
+	// Queues are the clients of the queues the messages are sent to, keyed by the queue given to Add.
+	Queues map[string]Enqueuer
+
+	// BatchSize is the maximum number of messages sent per poll. The default is DefaultBatchSize.
+	BatchSize int
+	// PollInterval is the wait between two polls finding no message. The default is DefaultPollInterval.
+	PollInterval time.Duration
+	// MaxAttempts is the number of failed sends after which a message is marked failed. The default is DefaultMaxAttempts.
+	MaxAttempts int
+	// BackoffMin and BackoffMax bound the exponential backoff between two sends of a message.
+	// The defaults are DefaultBackoffMin and DefaultBackoffMax.
+	BackoffMin time.Duration
+	BackoffMax time.Duration
+	// Lease is how long the messages claimed by a relay are hidden from the others while it sends them.
+	// It must be longer than the sending of a batch. The default is DefaultLease.
+	Lease time.Duration
+}
+
+// Relay sends the pending messages of the outbox to their queues. Several relays can run at once, e.g. one per pod,
+// as the messages claimed by a relay are skipped by the others until their lease expires.
+type Relay struct {
+	cfg RelayConfig
+}
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// NewRelay returns a relay of the outbox of cfg.DB.
+func NewRelay(cfg RelayConfig) *Relay {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = DefaultPollInterval
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+	if cfg.BackoffMin <= 0 {
+		cfg.BackoffMin = DefaultBackoffMin
+	}
+	if cfg.BackoffMax <= 0 {
+		cfg.BackoffMax = DefaultBackoffMax
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = DefaultLease
+	}
+	return &Relay{cfg: cfg}
+}
+
+// Run sends the pending messages until the context is done. It polls again right away after a full batch.
+func (r *Relay) Run(ctx context.Context) error {
+	for ctx.Err() == nil {
+		sent, err := r.RelayOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			logger.GetLoggerV3().ErrorContext(ctx, fmt.Sprintf("outbox relay | %s", err))
+		}
+		if err == nil && sent == r.cfg.BatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(r.cfg.PollInterval):
+		}
+	}
+	return nil
+}
+
+// RelayOnce sends a batch of the messages due, and returns the number of messages processed.
+// The messages are claimed for the Lease in a short transaction, then sent outside of it, the outcome of each
+// message being recorded on its own. A message whose outcome couldn't be recorded is sent again once its lease
+// expires, as the messages are delivered at least once.
+func (r *Relay) RelayOnce(ctx context.Context) (processed int, err error) {
+	messages, err := r.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var errs []error
+	for _, msg := range messages {
+		if err = r.send(ctx, msg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return len(messages), errors.Join(errs...)
+}
+
+// PurgeSent deletes the messages sent before olderThan, and returns the number of messages deleted.
+func PurgeSent(ctx context.Context, db *gorm.DB, olderThan time.Time) (int64, error) {
+	result := db.WithContext(ctx).Where("status = ? AND sent_at < ?", StatusSent, olderThan).Delete(&Message{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("error while purging outbox: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+// claim reads a batch of the messages due, and pushes their next attempt past the lease so that the other relays,
+// and the next polls of this one, skip them while they are sent. The rows locked by another relay claiming its own
+// batch are skipped, on postgres and on mysql 8.
+func (r *Relay) claim(ctx context.Context) (messages []Message, err error) {
+	err = connector.WithTx(ctx, r.cfg.DB, nil, func(tx *gorm.DB) error {
+		messages = nil
+		locking := clause.Locking{Strength: "UPDATE"}
+		if name := tx.Dialector.Name(); name == "postgres" || name == "mysql" {
+			locking.Options = "SKIP LOCKED"
+		}
+		now := time.Now()
+		err := tx.Clauses(locking).
+			Where("status = ? AND next_attempt_at <= ?", StatusPending, now).
+			Order("id").
+			Limit(r.cfg.BatchSize).
+			Find(&messages).Error
+		if err != nil {
+			return fmt.Errorf("error while reading outbox: %w", err)
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+		ids := make([]uint64, 0, len(messages))
+		for _, msg := range messages {
+			ids = append(ids, msg.ID)
+		}
+		if err = tx.Model(&Message{}).Where("id IN ?", ids).Update("next_attempt_at", now.Add(r.cfg.Lease)).Error; err != nil {
+			return fmt.Errorf("error while claiming outbox messages: %w", err)
+		}
+		return nil
+	})
+	return
+}
+
+// send enqueues the message and records the outcome. Only a failure to record it is returned,
+// a failed send being retried later.
+func (r *Relay) send(ctx context.Context, msg Message) error {
+	var sendErr error
+	if queue, found := r.cfg.Queues[msg.Queue]; !found {
+		sendErr = fmt.Errorf("no client for queue %s", msg.Queue)
+	} else {
+		sendErr = queue.Enqueue(sqs.QueueMessage{
+			Message:    msg.Body,
+			Attributes: msg.Attributes,
+			Delay:      msg.Delay,
+		})
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{"attempts": msg.Attempts + 1}
+	if sendErr == nil {
+		updates["status"] = StatusSent
+		updates["sent_at"] = now
+		updates["last_error"] = ""
+	} else {
+		updates["last_error"] = sendErr.Error()
+		if msg.Attempts+1 >= r.cfg.MaxAttempts {
+			updates["status"] = StatusFailed
+			logger.GetLoggerV3().ErrorContext(ctx, fmt.Sprintf("outbox relay | message %d to %s failed after %d attempts: %s", msg.ID, msg.Queue, msg.Attempts+1, sendErr))
+		} else {
+			updates["next_attempt_at"] = now.Add(r.backoff(msg.Attempts + 1))
+			logger.GetLoggerV3().WarnContext(ctx, fmt.Sprintf("outbox relay | message %d to %s failed, retrying: %s", msg.ID, msg.Queue, sendErr))
+		}
+	}
+	if err := r.cfg.DB.WithContext(ctx).Model(&Message{}).Where("id = ?", msg.ID).Updates(updates).Error; err != nil {
+		return fmt.Errorf("error while updating outbox message %d, processed again after its lease: %w", msg.ID, err)
+	}
+	return nil
+}
+
+// backoff is the wait before the next send of a message which failed attempts times.
+func (r *Relay) backoff(attempts int) time.Duration {
+	backoff := r.cfg.BackoffMin
+	for i := 1; i < attempts && backoff < r.cfg.BackoffMax; i++ {
+		backoff *= 2
+	}
+	if backoff > r.cfg.BackoffMax {
+		backoff = r.cfg.BackoffMax
+	}
+	return backoff
+}
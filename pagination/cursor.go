@@ -0,0 +1,128 @@
+// Package pagination implements keyset pagination with opaque signed cursors, for both the SQL queries and the
+// elastic/opensearch searches. A cursor holds the sort values of the last item of a page, and the next page is the
+// items sorted after them, which stays fast on large tables and indices unlike an offset.
+//
+//	codec := pagination.NewCursorCodec([]byte(util.GetConfigValue("CURSOR_SECRET")))
+//	sort := []pagination.SortField{{Column: "created_at", Desc: true}, {Column: "id", Desc: true}}
+//	after, err := codec.Decode(c.Query("cursor"))
+//	err = pagination.GormKeyset(db, sort, after).Limit(limit + 1).Find(&cards).Error
+package pagination
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ============ Constants =============
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// cursorTimeKey tags the time values of the cursor payload, {"$time": "<RFC 3339>"}, so that they are decoded as times.
+const cursorTimeKey = "$time"
+
+// SortField is a key of the sort order. The last field must be unique, e.g. the primary key, for the order to be total.
+type SortField struct {
+	Column string
+	Desc   bool
+}
+
+// CursorCodec encodes the sort values of a cursor, and signs them so that a client can't forge a cursor.
+type CursorCodec struct {
+	secret []byte
+}
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// NewCursorCodec returns a codec signing the cursors with the secret, which must be shared by all the pods.
+// Without secret the cursors are only encoded, and can be forged.
+func NewCursorCodec(secret []byte) *CursorCodec {
+	return &CursorCodec{secret: secret}
+}
+
+// Encode returns the opaque cursor of the sort values. The time values keep their type, so that they are bound as
+// times by the drivers, mysql comparing a DATETIME with a RFC 3339 string as a string.
+func (cc *CursorCodec) Encode(values ...interface{}) (string, error) {
+	encoded := make([]interface{}, len(values))
+	for i, value := range values {
+		if t, ok := value.(time.Time); ok {
+			value = map[string]string{cursorTimeKey: t.Format(time.RFC3339Nano)}
+		}
+		encoded[i] = value
+	}
+	payload, err := json.Marshal(encoded)
+	if err != nil {
+		return "", fmt.Errorf("error while encoding cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(cc.sign(payload)), nil
+}
+
+// Decode returns the sort values of the cursor, or nil for an empty cursor, i.e. the first page.
+// The integers are decoded as int64, the other numbers as float64, and the times as time.Time.
+// It returns ErrInvalidCursor if the cursor is malformed or its signature doesn't match.
+func (cc *CursorCodec) Decode(cursor string) ([]interface{}, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	encodedPayload, encodedSignature, found := strings.Cut(cursor, ".")
+	if !found {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidCursor)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCursor, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCursor, err)
+	}
+	if !hmac.Equal(signature, cc.sign(payload)) {
+		return nil, fmt.Errorf("%w: signature mismatch", ErrInvalidCursor)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var values []interface{}
+	if err = decoder.Decode(&values); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCursor, err)
+	}
+	for i, value := range values {
+		switch v := value.(type) {
+		case json.Number:
+			if n, err := v.Int64(); err == nil {
+				values[i] = n
+			} else if f, err := v.Float64(); err == nil {
+				values[i] = f
+			}
+		case map[string]interface{}:
+			encodedTime, ok := v[cursorTimeKey].(string)
+			if !ok || len(v) != 1 {
+				return nil, fmt.Errorf("%w: unsupported value %v", ErrInvalidCursor, v)
+			}
+			t, err := time.Parse(time.RFC3339Nano, encodedTime)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidCursor, err)
+			}
+			values[i] = t
+		case []interface{}:
+			return nil, fmt.Errorf("%w: unsupported value %v", ErrInvalidCursor, v)
+		}
+	}
+	return values, nil
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+func (cc *CursorCodec) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, cc.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
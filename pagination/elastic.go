@@ -0,0 +1,25 @@
+package pagination
+
+import (
+	"github.com/olivere/elastic/v7"
+)
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// ElasticSearchAfter sorts the search by the sort fields, and starts it after the after values, decoded from the
+// cursor of the previous page. A nil after is the first page. The last sort field should be a unique keyword field,
+// as the elastic _id can't be sorted on.
+func ElasticSearchAfter(search *elastic.SearchService, sort []SortField, after []interface{}) *elastic.SearchService {
+	for _, field := range sort {
+		search = search.Sort(field.Column, !field.Desc)
+	}
+	if len(after) != 0 {
+		search = search.SearchAfter(after...)
+	}
+	return search
+}
+
+// EncodeHit returns the cursor of the page ending with the hit, from the sort values elastic returns with it.
+func (cc *CursorCodec) EncodeHit(hit *elastic.SearchHit) (string, error) {
+	return cc.Encode(hit.Sort...)
+}
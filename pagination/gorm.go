@@ -0,0 +1,91 @@
+package pagination
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// GormKeyset orders the query by the sort fields, and keeps the rows sorted after the after values, decoded from
+// the cursor of the previous page. A nil after is the first page. The columns come from the code, not the request,
+// and can be qualified with the table, e.g. "cards.id".
+//
+// When all the fields have the same direction the condition is a row comparison, e.g. (created_at, id) > (?, ?),
+// which an index on (created_at, id) serves. Mixed directions are expanded into OR conditions.
+func GormKeyset(query *gorm.DB, sort []SortField, after []interface{}) *gorm.DB {
+	if len(sort) == 0 {
+		query.AddError(fmt.Errorf("pagination: no sort field"))
+		return query
+	}
+	if len(after) != 0 && len(after) != len(sort) {
+		query.AddError(fmt.Errorf("%w: %d values for %d sort fields", ErrInvalidCursor, len(after), len(sort)))
+		return query
+	}
+
+	columns := make([]clause.Column, len(sort))
+	for i, field := range sort {
+		columns[i] = gormColumn(field.Column)
+		query = query.Order(clause.OrderByColumn{Column: columns[i], Desc: field.Desc})
+	}
+	if len(after) == 0 {
+		return query
+	}
+	if sameDirection(sort) {
+		return query.Where(rowComparison(columns, after, comparison(sort[0])))
+	}
+
+	// (a > ?) OR (a = ? AND b < ?) OR (a = ? AND b = ? AND c > ?)
+	var or []clause.Expression
+	for i := range sort {
+		var and []clause.Expression
+		for j := 0; j < i; j++ {
+			and = append(and, clause.Eq{Column: columns[j], Value: after[j]})
+		}
+		and = append(and, clause.Expr{SQL: "? " + comparison(sort[i]) + " ?", Vars: []interface{}{columns[i], after[i]}})
+		or = append(or, clause.And(and...))
+	}
+	return query.Where(clause.Or(or...))
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+func gormColumn(column string) clause.Column {
+	if table, name, found := strings.Cut(column, "."); found {
+		return clause.Column{Table: table, Name: name}
+	}
+	return clause.Column{Name: column}
+}
+
+func sameDirection(sort []SortField) bool {
+	for _, field := range sort[1:] {
+		if field.Desc != sort[0].Desc {
+			return false
+		}
+	}
+	return true
+}
+
+func comparison(field SortField) string {
+	if field.Desc {
+		return "<"
+	}
+	return ">"
+}
+
+// rowComparison builds (a, b) > (?, ?), or a > ? for a single column.
+func rowComparison(columns []clause.Column, after []interface{}, op string) clause.Expr {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	vars := make([]interface{}, 0, 2*len(columns))
+	for _, column := range columns {
+		vars = append(vars, column)
+	}
+	vars = append(vars, after...)
+	if len(columns) == 1 {
+		return clause.Expr{SQL: "? " + op + " ?", Vars: vars}
+	}
+	return clause.Expr{SQL: "(" + placeholders + ") " + op + " (" + placeholders + ")", Vars: vars}
+}
@@ -0,0 +1,107 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	codec := NewCursorCodec([]byte("secret"))
+	createdAt := time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC)
+	cursor, err := codec.Encode(createdAt, 42, 1.5, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := codec.Decode(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{createdAt, int64(42), 1.5, "abc"}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("got %#v, want %#v", values, want)
+	}
+
+	if _, err = NewCursorCodec([]byte("other")).Decode(cursor); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("got error %v for a cursor signed with another secret, want ErrInvalidCursor", err)
+	}
+	if _, err = codec.Decode("Wzk5XQ." + cursor[len(cursor)-43:]); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("got error %v for a tampered cursor, want ErrInvalidCursor", err)
+	}
+	for _, payload := range []string{`[{"$time":"yesterday"}]`, `[{"$time":"2024-03-01T10:30:00Z","x":1}]`, `[[1]]`} {
+		forged := base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+			base64.RawURLEncoding.EncodeToString(codec.sign([]byte(payload)))
+		if _, err = codec.Decode(forged); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("got error %v for %s, want ErrInvalidCursor", err, payload)
+		}
+	}
+}
+
+func TestGormKeysetTimeKeyMySQL(t *testing.T) {
+	db, err := gorm.Open(mysql.New(mysql.Config{DSN: "user@tcp(localhost:3306)/cards?parseTime=true", SkipInitializeWithVersion: true}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec := NewCursorCodec([]byte("secret"))
+	createdAt := time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC)
+	cursor, err := codec.Encode(createdAt, uint64(42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := codec.Decode(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort := []SortField{{Column: "created_at", Desc: true}, {Column: "id", Desc: true}}
+	stmt := GormKeyset(db.Table("cards"), sort, after).Find(&[]map[string]interface{}{}).Statement
+	if bound, ok := stmt.Vars[0].(time.Time); !ok || !bound.Equal(createdAt) {
+		t.Errorf("bound %#v, want the time %s", stmt.Vars[0], createdAt)
+	}
+	want := "SELECT * FROM `cards` WHERE (`created_at`, `id`) < ('2024-03-01 10:30:00', 42) ORDER BY `created_at` DESC,`id` DESC"
+	if got := db.Dialector.Explain(stmt.SQL.String(), stmt.Vars...); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestGormKeyset(t *testing.T) {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		sort []SortField
+		want string
+	}{
+		{
+			name: "same direction",
+			sort: []SortField{{Column: "created_at", Desc: true}, {Column: "cards.id", Desc: true}},
+			want: `SELECT * FROM "cards" WHERE ("created_at", "cards"."id") < ($1, $2) ORDER BY "created_at" DESC,"cards"."id" DESC`,
+		},
+		{
+			name: "mixed directions",
+			sort: []SortField{{Column: "status"}, {Column: "id", Desc: true}},
+			want: `SELECT * FROM "cards" WHERE ("status" > $1 OR ("status" = $2 AND "id" < $3)) ORDER BY "status","id" DESC`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stmt := GormKeyset(db.Table("cards"), test.sort, []interface{}{"a", int64(1)}).Find(&[]map[string]interface{}{}).Statement
+			if got := stmt.SQL.String(); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
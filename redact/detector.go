@@ -0,0 +1,54 @@
+package redact
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Detector returns the string with its sensitive parts masked.
+type Detector func(s string) string
+
+// ============ Constants =============
+
+var (
+	// 13 to 19 digits, optionally grouped by spaces or dashes
+	panPattern   = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@([A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,})`)
+)
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// PANDetector masks the Luhn-valid card numbers but their last 4 digits, e.g. "************1111".
+func PANDetector(s string) string {
+	return panPattern.ReplaceAllStringFunc(s, func(match string) string {
+		digits := strings.NewReplacer(" ", "", "-", "").Replace(match)
+		if !luhnValid(digits) {
+			return match
+		}
+		return strings.Repeat("*", len(digits)-4) + digits[len(digits)-4:]
+	})
+}
+
+// EmailDetector masks the local part of the emails, e.g. "***@example.com".
+func EmailDetector(s string) string {
+	return emailPattern.ReplaceAllString(s, DefaultMask+"@$1")
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+func luhnValid(digits string) bool {
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		digit := int(digits[i] - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,251 @@
+// Package redact masks the sensitive fields of the logs, e.g. the card numbers, CVVs, OTPs and auth headers of the
+// request logs, before they leave the service.
+//
+// A Redactor masks the values at the configured paths, the values of the keys matching the key patterns, and the
+// sensitive parts of the strings found by its detectors:
+//
+//	r := redact.New(
+//		redact.WithPaths("card.holder", "items.*.pan"),
+//		redact.WithKeyPatterns(redact.DefaultKeyPattern),
+//		redact.WithDetectors(redact.PANDetector, redact.EmailDetector),
+//	)
+//	redact.SetDefault(r)
+//
+// The default redactor is used by the slog logger, util.MakeHttpRequest and the request logs shipped to OpenSearch.
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ============ Constants =============
+
+// DefaultMask replaces the masked values.
+const DefaultMask = "***"
+
+// DefaultKeyPattern matches the names of the usual secrets: passwords, tokens, auth headers, card numbers, CVVs,
+// PINs and OTPs.
+var DefaultKeyPattern = regexp.MustCompile(`(?i)(passw(or)?d|secret|token|authorization|cookie|api[-_]?key|` +
+	`card[-_]?(number|no)|^pan$|cvv|cvc|^pin$|^otp$)`)
+
+// Redactor masks the sensitive fields of the values. A Redactor is safe for concurrent use.
+type Redactor struct {
+	paths     [][]string
+	keys      []*regexp.Regexp
+	detectors []Detector
+	mask      string
+}
+
+type Option func(*Redactor)
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// WithPaths masks the values at the dot-separated paths, e.g. "card.holder". A "*" segment matches any key or
+// index, e.g. "items.*.pan".
+func WithPaths(paths ...string) Option {
+	return func(r *Redactor) {
+		for _, path := range paths {
+			r.paths = append(r.paths, strings.Split(path, "."))
+		}
+	}
+}
+
+// WithKeyPatterns masks the values of the keys matching one of the patterns, at any depth.
+func WithKeyPatterns(patterns ...*regexp.Regexp) Option {
+	return func(r *Redactor) {
+		r.keys = append(r.keys, patterns...)
+	}
+}
+
+// WithDetectors masks the sensitive parts of the strings, and of the numbers, found by the detectors.
+func WithDetectors(detectors ...Detector) Option {
+	return func(r *Redactor) {
+		r.detectors = append(r.detectors, detectors...)
+	}
+}
+
+// WithMask replaces the masked values with mask instead of DefaultMask.
+func WithMask(mask string) Option {
+	return func(r *Redactor) {
+		r.mask = mask
+	}
+}
+
+// New returns a redactor masking nothing but what the options configure.
+func New(opts ...Option) *Redactor {
+	r := &Redactor{mask: DefaultMask}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+var (
+	defaultRedactor     = New(WithKeyPatterns(DefaultKeyPattern), WithDetectors(PANDetector, EmailDetector))
+	defaultRedactorLock sync.RWMutex
+)
+
+// SetDefault replaces the default redactor, which masks the keys matching DefaultKeyPattern, the card numbers and
+// the emails.
+func SetDefault(r *Redactor) {
+	defaultRedactorLock.Lock()
+	defer defaultRedactorLock.Unlock()
+	defaultRedactor = r
+}
+
+// Default returns the default redactor.
+func Default() *Redactor {
+	defaultRedactorLock.RLock()
+	defer defaultRedactorLock.RUnlock()
+	return defaultRedactor
+}
+
+// Map returns a masked copy of the map, e.g. of a request log. The map itself is left unchanged.
+func (r *Redactor) Map(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	return r.value(nil, m).(map[string]interface{})
+}
+
+// Value returns a masked copy of the value. The maps, the slices and the structs are returned as the
+// map[string]interface{} and []interface{} of their JSON encoding.
+func (r *Redactor) Value(v interface{}) interface{} {
+	return r.value(nil, v)
+}
+
+// String masks the sensitive parts of the string found by the detectors.
+func (r *Redactor) String(s string) string {
+	for _, detect := range r.detectors {
+		s = detect(s)
+	}
+	return s
+}
+
+// Masks tells whether the value at the path is masked whole. The path lists the map keys and the slice indices
+// leading to the value.
+func (r *Redactor) Masks(path []string) bool {
+	if len(path) == 0 {
+		return false
+	}
+	for _, pattern := range r.keys {
+		if pattern.MatchString(path[len(path)-1]) {
+			return true
+		}
+	}
+	for _, masked := range r.paths {
+		if matchPath(masked, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+func (r *Redactor) value(path []string, v interface{}) interface{} {
+	if r.Masks(path) {
+		return r.mask
+	}
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return r.String(v)
+	case bool:
+		return v
+	case json.Number:
+		return r.number(v.String(), v)
+	case float64:
+		return r.number(strconv.FormatFloat(v, 'f', -1, 64), v)
+	case error:
+		return r.String(v.Error())
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			masked[key] = r.value(append(path[:len(path):len(path)], key), value)
+		}
+		return masked
+	case []interface{}:
+		masked := make([]interface{}, len(v))
+		for i, value := range v {
+			masked[i] = r.value(append(path[:len(path):len(path)], strconv.Itoa(i)), value)
+		}
+		return masked
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String:
+		return r.String(rv.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return r.number(strconv.FormatInt(rv.Int(), 10), v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return r.number(strconv.FormatUint(rv.Uint(), 10), v)
+	case reflect.Float32:
+		return r.number(strconv.FormatFloat(rv.Float(), 'f', -1, 32), v)
+	case reflect.Map:
+		if rv.Type().Key().Kind() == reflect.String {
+			m := make(map[string]interface{}, rv.Len())
+			for iter := rv.MapRange(); iter.Next(); {
+				m[iter.Key().String()] = iter.Value().Interface()
+			}
+			return r.value(path, m)
+		}
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() != reflect.Uint8 {
+			s := make([]interface{}, rv.Len())
+			for i := range s {
+				s[i] = rv.Index(i).Interface()
+			}
+			return r.value(path, s)
+		}
+	case reflect.Pointer:
+		if rv.IsNil() {
+			return nil
+		}
+		if _, marshaler := v.(json.Marshaler); !marshaler {
+			return r.value(path, rv.Elem().Interface())
+		}
+	}
+
+	// the structs, and the other values, are masked as their JSON encoding
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return r.String(fmt.Sprint(v))
+	}
+	var decoded interface{}
+	decoder := json.NewDecoder(strings.NewReader(string(raw)))
+	decoder.UseNumber()
+	if err = decoder.Decode(&decoded); err != nil {
+		return r.String(fmt.Sprint(v))
+	}
+	return r.value(path, decoded)
+}
+
+// number returns the masked digits of the number if a detector found something in them, the number otherwise,
+// the card numbers being sometimes sent as numbers.
+func (r *Redactor) number(digits string, v interface{}) interface{} {
+	if masked := r.String(digits); masked != digits {
+		return masked
+	}
+	return v
+}
+
+func matchPath(pattern, path []string) bool {
+	if len(pattern) != len(path) {
+		return false
+	}
+	for i := range pattern {
+		if pattern[i] != "*" && pattern[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,54 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedactorMap(t *testing.T) {
+	r := New(
+		WithPaths("card.holder", "items.*.ref"),
+		WithKeyPatterns(DefaultKeyPattern),
+		WithDetectors(PANDetector, EmailDetector),
+	)
+	respLog := map[string]interface{}{
+		"headers": map[string]string{"Authorization": "Bearer abc", "Accept": "application/json"},
+		"card":    map[string]interface{}{"holder": "Jane Doe", "cvv": "123", "expiry": "12/30"},
+		"items":   []interface{}{map[string]interface{}{"ref": "r1", "qty": 1}},
+		"message": "card 4111 1111 1111 1111 of jane.doe@example.com, order 4111111111111112",
+		"pan":     "4111111111111111",
+		"number":  json.Number("5555555555554444"),
+	}
+	masked := r.Map(respLog)
+
+	raw, _ := json.Marshal(masked)
+	want := `{"card":{"cvv":"***","expiry":"12/30","holder":"***"},` +
+		`"headers":{"Accept":"application/json","Authorization":"***"},` +
+		`"items":[{"qty":1,"ref":"***"}],` +
+		`"message":"card ************1111 of ***@example.com, order 4111111111111112",` +
+		`"number":"************4444","pan":"***"}`
+	if string(raw) != want {
+		t.Errorf("got %s, want %s", raw, want)
+	}
+	if respLog["card"].(map[string]interface{})["cvv"] != "123" {
+		t.Error("the map was changed")
+	}
+}
+
+func TestReplaceAttr(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{ReplaceAttr: ReplaceAttr}))
+	log.Info("paid with 4111111111111111",
+		slog.String("password", "hunter2"),
+		slog.Group("request", slog.Any("body", map[string]interface{}{"otp": "000000", "amount": 10})),
+	)
+
+	for _, want := range []string{`"msg":"paid with ************1111"`, `"password":"***"`, `"body":{"amount":10,"otp":"***"}`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log %s doesn't contain %s", buf.String(), want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package redact
+
+import (
+	"log/slog"
+)
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// ReplaceAttr masks the attributes of the slog records, as the slog.HandlerOptions ReplaceAttr. The path of an
+// attribute is its groups followed by its key, and the fields of its value.
+func (r *Redactor) ReplaceAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 {
+		switch a.Key {
+		case slog.TimeKey, slog.LevelKey, slog.SourceKey:
+			return a
+		}
+	}
+	path := append(groups[:len(groups):len(groups)], a.Key)
+	if r.Masks(path) {
+		return slog.String(a.Key, r.mask)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(r.String(a.Value.String()))
+	case slog.KindInt64, slog.KindUint64:
+		a.Value = slog.AnyValue(r.number(a.Value.String(), a.Value.Any()))
+	case slog.KindAny:
+		a.Value = slog.AnyValue(r.value(path, a.Value.Any()))
+	}
+	return a
+}
+
+// ReplaceAttr masks the attributes of the slog records with the default redactor, as the slog.HandlerOptions
+// ReplaceAttr.
+func ReplaceAttr(groups []string, a slog.Attr) slog.Attr {
+	return Default().ReplaceAttr(groups, a)
+}
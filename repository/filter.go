@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Operator compares a column to the value of a Filter.
+type Operator string
+
+const (
+	OpEq     Operator = "="
+	OpNe     Operator = "<>"
+	OpGt     Operator = ">"
+	OpGte    Operator = ">="
+	OpLt     Operator = "<"
+	OpLte    Operator = "<="
+	OpIn     Operator = "IN"
+	OpLike   Operator = "LIKE"
+	OpIsNull Operator = "IS NULL"
+)
+
+// Filter is a condition on a column of the model. The column is the field name or the column name,
+// and is checked against the model, so that a filter can't inject SQL.
+type Filter struct {
+	Column string
+	Op     Operator
+	Value  interface{}
+}
+
+// Eq, Ne, Gt, Gte, Lt, Lte, In, Like and IsNull build the filter of their operator.
+func Eq(column string, value interface{}) Filter {
+	return Filter{Column: column, Op: OpEq, Value: value}
+}
+
+func Ne(column string, value interface{}) Filter {
+	return Filter{Column: column, Op: OpNe, Value: value}
+}
+
+func Gt(column string, value interface{}) Filter {
+	return Filter{Column: column, Op: OpGt, Value: value}
+}
+
+func Gte(column string, value interface{}) Filter {
+	return Filter{Column: column, Op: OpGte, Value: value}
+}
+
+func Lt(column string, value interface{}) Filter {
+	return Filter{Column: column, Op: OpLt, Value: value}
+}
+
+func Lte(column string, value interface{}) Filter {
+	return Filter{Column: column, Op: OpLte, Value: value}
+}
+
+func In(column string, values interface{}) Filter {
+	return Filter{Column: column, Op: OpIn, Value: values}
+}
+
+func Like(column string, pattern string) Filter {
+	return Filter{Column: column, Op: OpLike, Value: pattern}
+}
+
+func IsNull(column string) Filter {
+	return Filter{Column: column, Op: OpIsNull}
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+// applyFilters adds the conditions of the filters to the query.
+func (r *Repository[T]) applyFilters(query *gorm.DB, filters []Filter) (*gorm.DB, error) {
+	for _, filter := range filters {
+		field := r.schema.LookUpField(filter.Column)
+		if field == nil || field.DBName == "" {
+			return nil, fmt.Errorf("%w: unknown column %s of %s", ErrInvalidFilter, filter.Column, r.schema.Name)
+		}
+		column := query.Statement.Quote(field.DBName)
+		switch filter.Op {
+		case OpEq, OpNe, OpGt, OpGte, OpLt, OpLte, OpLike:
+			query = query.Where(fmt.Sprintf("%s %s ?", column, filter.Op), filter.Value)
+		case OpIn:
+			query = query.Where(fmt.Sprintf("%s IN ?", column), filter.Value)
+		case OpIsNull:
+			query = query.Where(fmt.Sprintf("%s IS NULL", column))
+		default:
+			return nil, fmt.Errorf("%w: unknown operator %q", ErrInvalidFilter, filter.Op)
+		}
+	}
+	return query, nil
+}
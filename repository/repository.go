@@ -0,0 +1,285 @@
+// Package repository provides a generic CRUD repository on top of gorm v2, for the models embedding util.BaseModel.
+//
+//	cards, err := repository.New[Card](db)
+//	card, err := cards.FindByID(ctx, id)
+//	if errors.Is(err, repository.ErrNotFound) {
+//		...
+//	}
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/happay/cms-utils-go/v3/pagination"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// ============ Constants =============
+
+const (
+	DefaultVersionColumn = "version"
+	DeletedAtColumn      = "deleted_at"
+	DefaultPageLimit     = 50
+	MaxPageLimit         = 1000
+)
+
+var (
+	// ErrNotFound is returned when no row matches, or the row is soft deleted.
+	ErrNotFound = errors.New("record not found")
+	// ErrVersionConflict is returned by Update when the row was updated by someone else since it was read.
+	ErrVersionConflict = errors.New("record was modified concurrently")
+	ErrInvalidFilter   = errors.New("invalid filter")
+	ErrInvalidCursor   = pagination.ErrInvalidCursor
+	// ErrSoftDeleteUnsupported is returned by SoftDelete and Restore for a model without deleted_at column.
+	ErrSoftDeleteUnsupported = errors.New("model has no deleted_at column")
+	// ErrNoCursorCodec is returned by FindPage for a repository created without WithCursorCodec.
+	ErrNoCursorCodec = errors.New("no cursor codec, see WithCursorCodec")
+)
+
+// Option configures a Repository.
+type Option func(*options)
+
+type options struct {
+	versionColumn string
+	cursorCodec   *pagination.CursorCodec
+}
+
+// WithVersionColumn sets the column used for optimistic locking. The default is DefaultVersionColumn.
+// A model without this column is updated without locking.
+func WithVersionColumn(column string) Option {
+	return func(o *options) {
+		o.versionColumn = column
+	}
+}
+
+// WithCursorCodec sets the codec of the page cursors, which should sign them with a secret shared by all the pods.
+// It is required by FindPage, so that the clients can't forge the cursors of an unsigned default.
+func WithCursorCodec(codec *pagination.CursorCodec) Option {
+	return func(o *options) {
+		o.cursorCodec = codec
+	}
+}
+
+// PageRequest asks for the rows after the cursor of the previous page, if any.
+type PageRequest struct {
+	Cursor string
+	// Limit is the page size. The default is DefaultPageLimit, and it can't exceed MaxPageLimit.
+	Limit int
+}
+
+// Page is a page of rows in primary key order. NextCursor is empty on the last page.
+type Page[T any] struct {
+	Items      []T
+	NextCursor string
+}
+
+// Repository reads and writes the rows of the model T. The soft deleted rows are excluded from the reads.
+type Repository[T any] struct {
+	db        *gorm.DB
+	schema    *schema.Schema
+	primary   *schema.Field
+	version   *schema.Field
+	deletedAt *schema.Field
+	cursors   *pagination.CursorCodec
+}
+
+// =========== Exposed (public) Methods - can be called from external packages ============
+
+// New returns the repository of the model T, which must have a single primary key, and an integer version column if any.
+func New[T any](db *gorm.DB, opts ...Option) (*Repository[T], error) {
+	o := &options{versionColumn: DefaultVersionColumn}
+	for _, opt := range opts {
+		opt(o)
+	}
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(new(T)); err != nil {
+		return nil, fmt.Errorf("error while parsing repository model: %w", err)
+	}
+	r := &Repository[T]{
+		db:        db,
+		schema:    stmt.Schema,
+		primary:   stmt.Schema.PrioritizedPrimaryField,
+		version:   stmt.Schema.LookUpField(o.versionColumn),
+		deletedAt: stmt.Schema.LookUpField(DeletedAtColumn),
+		cursors:   o.cursorCodec,
+	}
+	if r.primary == nil {
+		return nil, fmt.Errorf("repository model %s has no single primary key", stmt.Schema.Name)
+	}
+	if r.version != nil {
+		switch r.version.FieldType.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			return nil, fmt.Errorf("repository model %s version field %s is a %s, not an integer", stmt.Schema.Name, r.version.Name, r.version.FieldType)
+		}
+	}
+	return r, nil
+}
+
+// WithDB returns the repository using the connection db, e.g. the transaction of connector.WithTx.
+func (r *Repository[T]) WithDB(db *gorm.DB) *Repository[T] {
+	clone := *r
+	clone.db = db
+	return &clone
+}
+
+// FindByID returns the row of the primary key id, or ErrNotFound.
+func (r *Repository[T]) FindByID(ctx context.Context, id interface{}) (*T, error) {
+	entity := new(T)
+	err := r.query(ctx).Where(r.primaryColumn()+" = ?", id).Take(entity).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s %v", ErrNotFound, r.schema.Name, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entity, nil
+}
+
+// FindWhere returns the rows matching all the filters, in primary key order.
+func (r *Repository[T]) FindWhere(ctx context.Context, filters ...Filter) ([]T, error) {
+	query, err := r.applyFilters(r.query(ctx), filters)
+	if err != nil {
+		return nil, err
+	}
+	var entities []T
+	if err = query.Order(r.primaryColumn()).Find(&entities).Error; err != nil {
+		return nil, err
+	}
+	return entities, nil
+}
+
+// FindPage returns a page of the rows matching all the filters, in primary key order.
+func (r *Repository[T]) FindPage(ctx context.Context, req PageRequest, filters ...Filter) (*Page[T], error) {
+	limit := req.Limit
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	}
+	if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
+	if r.cursors == nil {
+		return nil, ErrNoCursorCodec
+	}
+	query, err := r.applyFilters(r.query(ctx), filters)
+	if err != nil {
+		return nil, err
+	}
+	after, err := r.cursors.Decode(req.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	sort := []pagination.SortField{{Column: r.schema.Table + "." + r.primary.DBName}}
+	var entities []T
+	// one more row tells whether there is a next page
+	if err = pagination.GormKeyset(query, sort, after).Limit(limit + 1).Find(&entities).Error; err != nil {
+		return nil, err
+	}
+	page := &Page[T]{Items: entities}
+	if len(entities) > limit {
+		page.Items = entities[:limit]
+		last, _ := r.primary.ValueOf(ctx, reflect.ValueOf(&page.Items[limit-1]).Elem())
+		if page.NextCursor, err = r.cursors.Encode(last); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// Create inserts the entity, setting its primary key and timestamps. The version, if any, starts at 1.
+func (r *Repository[T]) Create(ctx context.Context, entity *T) error {
+	if r.version != nil {
+		rv := reflect.ValueOf(entity).Elem()
+		if _, zero := r.version.ValueOf(ctx, rv); zero {
+			if err := r.version.Set(ctx, rv, 1); err != nil {
+				return err
+			}
+		}
+	}
+	return r.db.WithContext(ctx).Create(entity).Error
+}
+
+// Update saves all the fields of the entity, or returns ErrNotFound. If the model has a version column, the update
+// only applies if the version is still the one the entity was read with, and increments it, otherwise it returns
+// ErrVersionConflict.
+func (r *Repository[T]) Update(ctx context.Context, entity *T) error {
+	rv := reflect.ValueOf(entity).Elem()
+	id, _ := r.primary.ValueOf(ctx, rv)
+	query := r.query(ctx).Model(entity).Where(r.primaryColumn()+" = ?", id)
+
+	var version int64
+	if r.version != nil {
+		value, _ := r.version.ValueOf(ctx, rv)
+		version = reflect.ValueOf(value).Convert(reflect.TypeOf(version)).Int()
+		query = query.Where(r.db.Statement.Quote(r.version.DBName)+" = ?", version)
+		if err := r.version.Set(ctx, rv, version+1); err != nil {
+			return err
+		}
+	}
+	result := query.Select("*").Omit(r.primary.Name, "CreatedAt").Updates(entity)
+	if result.Error != nil || result.RowsAffected != 0 {
+		if result.Error != nil && r.version != nil {
+			r.version.Set(ctx, rv, version)
+		}
+		return result.Error
+	}
+
+	// no row matched, or on mysql the row is unchanged, which can only happen without version as it is incremented
+	if r.version != nil {
+		r.version.Set(ctx, rv, version)
+	}
+	if _, err := r.FindByID(ctx, id); err != nil || r.version == nil {
+		return err
+	}
+	return fmt.Errorf("%w: %s %v", ErrVersionConflict, r.schema.Name, id)
+}
+
+// SoftDelete sets the deleted_at of the row of the primary key id, or returns ErrNotFound.
+func (r *Repository[T]) SoftDelete(ctx context.Context, id interface{}) error {
+	return r.setDeletedAt(ctx, id, time.Now(), r.db.Statement.Quote(DeletedAtColumn)+" IS NULL")
+}
+
+// Restore clears the deleted_at of the soft deleted row of the primary key id, or returns ErrNotFound.
+func (r *Repository[T]) Restore(ctx context.Context, id interface{}) error {
+	return r.setDeletedAt(ctx, id, nil, r.db.Statement.Quote(DeletedAtColumn)+" IS NOT NULL")
+}
+
+// ============ Internal(private) Methods - can only be called from inside this package ==============
+
+// query starts a query excluding the soft deleted rows. gorm's own soft delete is disabled, so that the models
+// embedding util.BaseModel, whose DeletedAt is a *time.Time, and the models using gorm.DeletedAt behave the same.
+func (r *Repository[T]) query(ctx context.Context) *gorm.DB {
+	query := r.db.WithContext(ctx).Unscoped().Model(new(T))
+	if r.deletedAt != nil {
+		query = query.Where(query.Statement.Quote(r.deletedAt.DBName) + " IS NULL")
+	}
+	return query
+}
+
+func (r *Repository[T]) primaryColumn() string {
+	return r.db.Statement.Quote(clause.Column{Table: r.schema.Table, Name: r.primary.DBName})
+}
+
+func (r *Repository[T]) setDeletedAt(ctx context.Context, id interface{}, deletedAt interface{}, condition string) error {
+	if r.deletedAt == nil {
+		return fmt.Errorf("%w: %s", ErrSoftDeleteUnsupported, r.schema.Name)
+	}
+	result := r.db.WithContext(ctx).Unscoped().Model(new(T)).
+		Where(r.primaryColumn()+" = ?", id).
+		Where(condition).
+		Update(r.deletedAt.DBName, deletedAt)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: %s %v", ErrNotFound, r.schema.Name, id)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/happay/cms-utils-go/v3/pagination"
+	"github.com/happay/cms-utils-go/v3/util"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type card struct {
+	ID      uint64
+	Status  string
+	Version int
+	util.BaseModel
+}
+
+// dryRunRepository returns a repository generating the SQL without running it, and the SQL generated.
+func dryRunRepository(t *testing.T) (*Repository[card], *[]string) {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statements []string
+	record := func(tx *gorm.DB) { statements = append(statements, tx.Statement.SQL.String()) }
+	db.Callback().Query().After("gorm:query").Register("test:record", record)
+	db.Callback().Update().After("gorm:update").Register("test:record", record)
+	r, err := New[card](db, WithCursorCodec(pagination.NewCursorCodec([]byte("secret"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, &statements
+}
+
+func TestFindPageQuery(t *testing.T) {
+	r, statements := dryRunRepository(t)
+	cursor, _ := r.cursors.Encode(uint64(42))
+	if _, err := r.FindPage(context.Background(), PageRequest{Cursor: cursor, Limit: 2}, Eq("status", "active")); err != nil {
+		t.Fatal(err)
+	}
+	want := `SELECT * FROM "cards" WHERE "deleted_at" IS NULL AND "status" = $1 AND "cards"."id" > $2 ORDER BY "cards"."id" LIMIT $3`
+	if (*statements)[0] != want {
+		t.Errorf("got %s, want %s", (*statements)[0], want)
+	}
+
+	if _, err := r.FindWhere(context.Background(), Eq("status; DROP TABLE cards", "x")); !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("got error %v, want ErrInvalidFilter", err)
+	}
+	if _, err := r.FindPage(context.Background(), PageRequest{Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("got error %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestUpdateChecksVersion(t *testing.T) {
+	r, statements := dryRunRepository(t)
+	entity := &card{ID: 5, Status: "blocked", Version: 3}
+	// nothing is updated on a dry run, as on a concurrent update
+	if err := r.Update(context.Background(), entity); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("got error %v, want ErrVersionConflict", err)
+	}
+	if entity.Version != 3 {
+		t.Errorf("got version %d after a conflict, want 3", entity.Version)
+	}
+	update := (*statements)[0]
+	if !strings.Contains(update, `"version"=$2`) || !strings.Contains(update, `"version" = $6`) {
+		t.Errorf("update %s doesn't set and check the version", update)
+	}
+}
+
+func TestUpdateWithoutVersion(t *testing.T) {
+	r, _ := dryRunRepository(t)
+	tags, err := New[tag](r.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// no row affected, as on mysql for an unchanged row, isn't a conflict without version
+	if err = tags.Update(context.Background(), &tag{ID: 5, Name: "travel"}); err != nil {
+		t.Errorf("got error %v, want none", err)
+	}
+}
+
+func TestNewValidatesOptions(t *testing.T) {
+	r, _ := dryRunRepository(t)
+	if _, err := New[versionedByString](r.db); err == nil || !strings.Contains(err.Error(), "not an integer") {
+		t.Errorf("got error %v, want the invalid version field", err)
+	}
+	if _, err := New[versionedByPointer](r.db); err == nil {
+		t.Error("got no error for a pointer version field")
+	}
+
+	tags, err := New[tag](r.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tags.FindPage(context.Background(), PageRequest{}); !errors.Is(err, ErrNoCursorCodec) {
+		t.Errorf("got error %v, want ErrNoCursorCodec", err)
+	}
+}
+
+type tag struct {
+	ID   uint64
+	Name string
+}
+
+type versionedByString struct {
+	ID      uint64
+	Version string
+}
+
+type versionedByPointer struct {
+	ID      uint64
+	Version *int
+}
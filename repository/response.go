@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/happay/cms-utils-go/v3/logger"
+	"github.com/happay/cms-utils-go/v3/response"
+)
+
+// SendErrorResponse answers the request with the response matching the repository error: 404 for ErrNotFound,
+// 409 for ErrVersionConflict, 400 for ErrInvalidFilter and ErrInvalidCursor, and 500 otherwise.
+func SendErrorResponse(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		response.SendNotFound404Response(c, err.Error())
+	case errors.Is(err, ErrVersionConflict):
+		response.SendResourceConflict409Response(c, err.Error())
+	case errors.Is(err, ErrInvalidFilter), errors.Is(err, ErrInvalidCursor):
+		response.SendBadRequest400Response(c, err.Error())
+	default:
+		logger.GetLoggerV3().ErrorContext(c.Request.Context(), fmt.Sprintf("repository error: %s", err))
+		response.SendServerError500Response(c)
+	}
+}
@@ -92,6 +92,17 @@ func SendResourceConflict409Response(c *gin.Context, userErrMessage string) {
 	c.Abort()
 }
 
+// SendTooManyRequests429Response sets the response status code to Too Many Requests (http 429) on the gin context and
+// send userErrMessage to user. Finally it also aborts any other handlers in-line by calling Abort.
+// The Retry-After header should be set by the caller.
+func SendTooManyRequests429Response(c *gin.Context, userErrMessage string) {
+	c.JSON(http.StatusTooManyRequests,
+		gin.H{
+			"msg": userErrMessage,
+		})
+	c.Abort()
+}
+
 // SendServerError500Response sets the response status code to Unauthorised (http 500) on the gin context and
 // send userErrMessage to user. Finally it also aborts any other handlers in-line by calling Abort.
 func SendServerError500Response(c *gin.Context) {
@@ -102,6 +113,16 @@ func SendServerError500Response(c *gin.Context) {
 	c.Abort()
 }
 
+// SendServiceUnavailable503Response sets the response status code to Service Unavailable (http 503) on the gin context
+// and send userErrMessage to user. Finally it also aborts any other handlers in-line by calling Abort.
+func SendServiceUnavailable503Response(c *gin.Context, userErrMessage string) {
+	c.JSON(http.StatusServiceUnavailable,
+		gin.H{
+			"msg": userErrMessage,
+		})
+	c.Abort()
+}
+
 // SendStatusProcessing sets the response status code to Unauthorised (http 102) on the gin context and
 // send userErrMessage to user. Finally it also aborts any other handlers in-line by calling Abort.
 func SendStatusProcessing(c *gin.Context, userErrMessage string) {
@@ -111,3 +132,31 @@ func SendStatusProcessing(c *gin.Context, userErrMessage string) {
 		})
 	c.Abort()
 }
+
+// PageInfo describes the position of a page in a paginated response. NextCursor is empty on the last page.
+type PageInfo struct {
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+	Limit      int    `json:"limit"`
+}
+
+// PaginatedResponse is the standard body of the paginated list endpoints.
+type PaginatedResponse struct {
+	Items      interface{} `json:"items"`
+	Pagination PageInfo    `json:"pagination"`
+}
+
+// SendPaginated200Response sets a ok status (HTTP 200) on the gin context and
+// send the items of a page, with the cursor of the next page, as a PaginatedResponse.
+// The items should be an empty slice rather than nil, to be rendered as [] instead of null.
+// Finally it also aborts any other handlers in-line by calling Abort.
+func SendPaginated200Response(c *gin.Context, items interface{}, nextCursor string, limit int) {
+	SendOK200Response(c, PaginatedResponse{
+		Items: items,
+		Pagination: PageInfo{
+			NextCursor: nextCursor,
+			HasMore:    nextCursor != "",
+			Limit:      limit,
+		},
+	})
+}
@@ -54,6 +54,8 @@ func CreateUUID() string {
 }
 
 // Returns the sha256 hash value of the input string
+//
+// Deprecated: an unsalted hash of a PAN is brute-forced in minutes. Use the keyed encryption.BlindIndex.
 func GeneratePanHash(Pan string) string {
 	hash := sha256.New()
 	hash.Write([]byte(Pan))
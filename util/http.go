@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/happay/cms-utils-go/v3/logger"
+	"github.com/happay/cms-utils-go/v3/redact"
 )
 
 type Options struct {
@@ -20,10 +21,11 @@ type Options struct {
 	timeout               time.Duration
 	caCert                []byte
 	insecureSkipVerify    bool
+	redactor              *redact.Redactor
 }
 
 func MakeHttpRequest(method, path string, opts ...HttpOption) (responseBody *http.Response, err error) {
-	h := &Options{}
+	h := &Options{redactor: redact.Default()}
 	for _, opt := range opts {
 		opt(h)
 	}
@@ -67,6 +69,16 @@ func WithCertificate(publicKey, privateKey, caCert []byte, insecureSkipVerify bo
 	}
 }
 
+// WithRedactor masks the logged request body, query params and headers with the redactor,
+// instead of the default one of redact.Default. A nil redactor keeps the default one.
+func WithRedactor(redactor *redact.Redactor) HttpOption {
+	return func(h *Options) {
+		if redactor != nil {
+			h.redactor = redactor
+		}
+	}
+}
+
 func addClientConfig(opt *Options) *http.Client {
 	client := &http.Client{}
 	tlsConfig := &tls.Config{
@@ -108,11 +120,11 @@ func httpRequest(method, path string, opt *Options) (resp *http.Response, err er
 	}
 
 	logger.GetLoggerV3().Info("[httpRequest] method: , path: ", slog.String("Method", method), slog.String("path", path))
-	logger.GetLoggerV3().Info("[httpRequest] request body:", slog.Any("requestBody", opt.requestBody))
+	logger.GetLoggerV3().Info("[httpRequest] request body:", slog.Any("requestBody", opt.redactor.Value(opt.requestBody)))
 
 	// if query param exits, add
 	if len(opt.queryParams) != 0 {
-		logger.GetLoggerV3().Info("[httpRequest] query param: ", slog.Any("Query Params", opt.queryParams))
+		logger.GetLoggerV3().Info("[httpRequest] query param: ", slog.Any("Query Params", opt.redactor.Value(opt.queryParams)))
 		queryParams := opt.queryParams
 		q := req.URL.Query()
 		for key, val := range queryParams {
@@ -121,7 +133,7 @@ func httpRequest(method, path string, opt *Options) (resp *http.Response, err er
 		req.URL.RawQuery = q.Encode()
 	}
 	if len(opt.header) != 0 {
-		logger.GetLoggerV3().Info("[httpRequest] Header:  %v\n", slog.Any("Header", opt.header))
+		logger.GetLoggerV3().Info("[httpRequest] Header:  %v\n", slog.Any("Header", opt.redactor.Value(opt.header)))
 		for key, val := range opt.header {
 			req.Header.Set(key, val)
 		}